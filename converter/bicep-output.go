@@ -0,0 +1,274 @@
+package converter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+)
+
+// bicepDirName is the directory below the output directory holding the Bicep
+// template and its bicepconfig.json.
+const bicepDirName = "bicep"
+
+const (
+	bicepTemplateFileName = "main.bicep"
+	bicepConfigFileName   = "bicepconfig.json"
+
+	// bicepExtension is the Microsoft Graph Bicep extension the template
+	// uses, and bicepExtensionRef the registry reference bicepconfig.json
+	// resolves it to. Conditional access is only in the beta types.
+	bicepExtension    = "microsoftGraphBeta"
+	bicepExtensionRef = "br:mcr.microsoft.com/bicep/extensions/microsoftgraph/beta:0.2.0-preview"
+)
+
+// bicepObjectKind is one kind of object the template declares, with the
+// resource type and the prefix of its symbolic names.
+type bicepObjectKind struct {
+	resourceType string
+	prefix       string
+}
+
+var (
+	bicepPolicy        = bicepObjectKind{"Microsoft.Graph/conditionalAccessPolicies@beta", "policy"}
+	bicepNamedLocation = bicepObjectKind{"Microsoft.Graph/namedLocations@beta", "location"}
+	bicepUser          = bicepObjectKind{"Microsoft.Graph/users@beta", "user"}
+)
+
+// bicepReferenceKinds maps the data source kinds of the HCL generator onto
+// the objects the template refers to instead. Groups have no key the
+// template can look them up by, so policies keep their object IDs.
+var bicepReferenceKinds = map[string]bicepObjectKind{
+	userDataSource.dataType:          bicepUser,
+	namedLocationDataSource.dataType: bicepNamedLocation,
+}
+
+var bicepInvalidSymbolChars = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+var bicepIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// bicepSymbol returns the symbolic name for an object of kind, built from
+// the Terraform label the HCL generator uses for it.
+func bicepSymbol(kind bicepObjectKind, label string) string {
+	return kind.prefix + "_" + bicepInvalidSymbolChars.ReplaceAllString(label, "_")
+}
+
+// writeBicepFiles writes a Bicep template declaring every policy and the
+// named locations they use, with existing references to the users they
+// include or exclude, to dir. Groups are referred to by object ID. The
+// definitions of named locations are read through directory so the template
+// can create them too.
+func writeBicepFiles(out OutputWriter, log io.Writer, dir string, policies []models.ConditionalAccessPolicy, directory *directoryCache) error {
+	t := &bicepTemplate{declared: map[string]string{}}
+	type bicepPolicyEntry struct {
+		p    *caPolicy
+		body map[string]any
+	}
+	var entries []bicepPolicyEntry
+	for i := range policies {
+		p, err := newCAPolicy(policies[i], directory)
+		if err != nil {
+			return err
+		}
+		body, err := policyGraphBody(&policies[i])
+		if err != nil {
+			return fmt.Errorf("error serializing policy %q: %v", p.DisplayName, err)
+		}
+		entries = append(entries, bicepPolicyEntry{p, body})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].p.DisplayName < entries[j].p.DisplayName })
+
+	for _, entry := range entries {
+		users := &entry.p.Conditions.Users
+		for _, refs := range [][]principalRef{users.IncludeUsers, users.ExcludeUsers} {
+			for _, ref := range refs {
+				if err := t.existing(bicepUser, userDataName(ref.Name), ref.ID, "userPrincipalName", ref.Name); err != nil {
+					return err
+				}
+			}
+		}
+		if locations := entry.p.Conditions.Locations; locations != nil {
+			for _, refs := range [][]principalRef{locations.IncludeLocations, locations.ExcludeLocations} {
+				for _, ref := range refs {
+					if err := t.namedLocation(directory, ref); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	for _, entry := range entries {
+		symbol := bicepSymbol(bicepPolicy, policyResourceName(entry.p.DisplayName))
+		identity := entry.p.ID
+		if identity == "" {
+			identity = entry.p.DisplayName
+		}
+		if err := t.resource(bicepPolicy, symbol, identity, false, bicepValue(entry.body, resolvedNames(entry.p), "", 0)); err != nil {
+			return err
+		}
+	}
+
+	config, err := json.MarshalIndent(map[string]any{
+		"experimentalFeaturesEnabled": map[string]bool{"extensibility": true},
+		"extensions":                  map[string]string{bicepExtension: bicepExtensionRef},
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	files := []struct {
+		name    string
+		content string
+	}{
+		{bicepTemplateFileName, t.text()},
+		{bicepConfigFileName, string(config) + "\n"},
+	}
+	for _, file := range files {
+		path := filepath.Join(dir, file.name)
+		change, err := writeFileIfChanged(out, path, []byte(file.content))
+		if err != nil {
+			return err
+		}
+		if change != fileUnchanged {
+			fmt.Fprintf(log, "%s Bicep file: %s\n", change, path)
+		}
+	}
+	return nil
+}
+
+// bicepTemplate builds the template from resource declarations, each object
+// declared once.
+type bicepTemplate struct {
+	strings.Builder
+	// declared maps the symbols declared so far onto the ID of the object
+	// they stand for.
+	declared map[string]string
+}
+
+// resource declares the object with the given ID as symbol. Declaring the
+// same object again does nothing; declaring another object under the same
+// symbol is an error, as references could not tell them apart.
+func (t *bicepTemplate) resource(kind bicepObjectKind, symbol, id string, existing bool, body string) error {
+	if declared, ok := t.declared[symbol]; ok {
+		if declared != id {
+			return fmt.Errorf("objects %s and %s both map to the Bicep symbol %s", declared, id, symbol)
+		}
+		return nil
+	}
+	t.declared[symbol] = id
+	keyword := "="
+	if existing {
+		keyword = "existing ="
+	}
+	fmt.Fprintf(t, "\nresource %s '%s' %s %s\n", symbol, kind.resourceType, keyword, body)
+	return nil
+}
+
+// existing declares a reference to the object with the given ID, which the
+// template does not manage, looked up by the given key. Unresolved
+// references are skipped; the policy keeps their ID.
+func (t *bicepTemplate) existing(kind bicepObjectKind, label, id, key, value string) error {
+	if value == "" {
+		return nil
+	}
+	return t.resource(kind, bicepSymbol(kind, label), id, true, fmt.Sprintf("{\n  %s: %s\n}", key, bicepString(value)))
+}
+
+// namedLocation declares the named location ref refers to with its current
+// definition from the directory.
+func (t *bicepTemplate) namedLocation(directory *directoryCache, ref principalRef) error {
+	if ref.Name == "" {
+		return nil
+	}
+	symbol := bicepSymbol(bicepNamedLocation, namedLocationDataName(ref.Name))
+	if declared, ok := t.declared[symbol]; ok && declared == ref.ID {
+		return nil
+	}
+	location, err := directory.namedLocation(ref.ID)
+	if err != nil {
+		return fmt.Errorf("error getting named location %q: %v", ref.Name, err)
+	}
+	body, err := graphObjectBody(location)
+	if err != nil {
+		return fmt.Errorf("error serializing named location %q: %v", ref.Name, err)
+	}
+	return t.resource(bicepNamedLocation, symbol, ref.ID, false, bicepValue(body, nil, "", 0))
+}
+
+func (t *bicepTemplate) text() string {
+	return "extension " + bicepExtension + "\n" + t.String()
+}
+
+// bicepValue renders value, found at the dotted JSON path, as a Bicep
+// expression indented by depth levels. IDs in names are replaced by the ID of
+// the symbol declared for the object, or annotated with the name when the
+// template declares no symbol for that kind. Null properties are left out and
+// object keys are written in sorted order.
+func bicepValue(value any, names map[string]string, path string, depth int) string {
+	indent := strings.Repeat("  ", depth+1)
+	switch v := value.(type) {
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			if v[key] != nil {
+				keys = append(keys, key)
+			}
+		}
+		if len(keys) == 0 {
+			return "{}"
+		}
+		sort.Strings(keys)
+		var b strings.Builder
+		b.WriteString("{\n")
+		for _, key := range keys {
+			childPath := key
+			if path != "" {
+				childPath = path + "." + key
+			}
+			name := key
+			if !bicepIdentifier.MatchString(key) {
+				name = bicepString(key)
+			}
+			fmt.Fprintf(&b, "%s%s: %s\n", indent, name, bicepValue(v[key], names, childPath, depth+1))
+		}
+		return b.String() + strings.Repeat("  ", depth) + "}"
+	case []any:
+		if len(v) == 0 {
+			return "[]"
+		}
+		var b strings.Builder
+		b.WriteString("[\n")
+		for _, element := range v {
+			b.WriteString(indent)
+			if id, ok := element.(string); ok && names[id] != "" {
+				if kind, ok := graphReferenceFields[path]; ok {
+					if objectKind, ok := bicepReferenceKinds[kind.dataType]; ok {
+						fmt.Fprintf(&b, "%s.id\n", bicepSymbol(objectKind, kind.label(names[id])))
+					} else {
+						fmt.Fprintf(&b, "%s // %s\n", bicepString(id), strings.Join(strings.Fields(names[id]), " "))
+					}
+					continue
+				}
+			}
+			b.WriteString(bicepValue(element, names, path, depth+1) + "\n")
+		}
+		return b.String() + strings.Repeat("  ", depth) + "]"
+	case string:
+		return bicepString(v)
+	case bool:
+		return fmt.Sprint(v)
+	case json.Number:
+		return v.String()
+	}
+	return "null"
+}
+
+// bicepString quotes value as a Bicep string literal.
+func bicepString(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `'`, `\'`, "\n", `\n`, "\r", `\r`, "\t", `\t`, "${", `\${`)
+	return "'" + replacer.Replace(value) + "'"
+}
@@ -0,0 +1,63 @@
+package converter
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+)
+
+func TestBicepRefersToGroupsByObjectID(t *testing.T) {
+	out := NewMemoryWriter()
+	if err := writeBicepFiles(out, io.Discard, "bicep", []models.ConditionalAccessPolicy{testPolicy("a1", "Require MFA")}, newDirectoryCache(context.Background(), testDirectory)); err != nil {
+		t.Fatal(err)
+	}
+	src, err := out.ReadFile("bicep/" + bicepTemplateFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := string(src)
+	if strings.Contains(template, "Microsoft.Graph/groups") || strings.Contains(template, "uniqueName") {
+		t.Errorf("group looked up by name:\n%s", template)
+	}
+	if !strings.Contains(template, "'"+testGroupID+"' // CA Pilot\n") {
+		t.Errorf("group not referred to by object ID:\n%s", template)
+	}
+	if !strings.Contains(template, "userPrincipalName: 'breakglass@contoso.com'") {
+		t.Errorf("user not looked up by UPN:\n%s", template)
+	}
+}
+
+func TestBicepRejectsSymbolCollision(t *testing.T) {
+	policies := []models.ConditionalAccessPolicy{testPolicy("a1", "Require MFA"), testPolicy("b2", "Require-MFA")}
+	err := writeBicepFiles(NewMemoryWriter(), io.Discard, "bicep", policies, newDirectoryCache(context.Background(), testDirectory))
+	if err == nil || !strings.Contains(err.Error(), "policy_") {
+		t.Errorf("err = %v, want a symbol collision", err)
+	}
+}
+
+func TestBicepDeclaresNamedLocationsFromDirectory(t *testing.T) {
+	const locationID = "55555555-5555-5555-5555-555555555555"
+	directory := testDirectory
+	directory.locations = map[string]string{locationID: "Head office"}
+	policy := testPolicy("a1", "Require MFA")
+	locations := models.NewConditionalAccessLocations()
+	locations.SetIncludeLocations([]string{"All"})
+	locations.SetExcludeLocations([]string{locationID})
+	policy.GetConditions().SetLocations(locations)
+
+	out := NewMemoryWriter()
+	if err := writeBicepFiles(out, io.Discard, "bicep", []models.ConditionalAccessPolicy{policy}, newDirectoryCache(context.Background(), directory)); err != nil {
+		t.Fatal(err)
+	}
+	src, _ := out.ReadFile("bicep/" + bicepTemplateFileName)
+	template := string(src)
+	if !strings.Contains(template, "resource location_Head_office 'Microsoft.Graph/namedLocations@beta' = {") {
+		t.Errorf("named location not declared:\n%s", template)
+	}
+	if !strings.Contains(template, "location_Head_office.id") {
+		t.Errorf("policy does not refer to the named location:\n%s", template)
+	}
+}
@@ -0,0 +1,306 @@
+package converter
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+)
+
+// caPolicy is the normalized form of a conditional access policy. Principal
+// references are resolved to names once, and every output is rendered from
+// this model rather than from the Graph SDK types.
+type caPolicy struct {
+	ID          string             `json:"id,omitempty"`
+	DisplayName string             `json:"display_name"`
+	State       string             `json:"state"`
+	Conditions  caConditions       `json:"conditions"`
+	Grant       *caGrantControls   `json:"grant_controls,omitempty"`
+	Session     *caSessionControls `json:"session_controls,omitempty"`
+}
+
+// principalRef is an object referenced by a policy. Name holds the resolved
+// user principal name or display name and is empty for keywords such as
+// "All" or when the object could not be resolved.
+type principalRef struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+}
+
+type caConditions struct {
+	ClientAppTypes             []string              `json:"client_app_types,omitempty"`
+	SignInRiskLevels           []string              `json:"sign_in_risk_levels,omitempty"`
+	UserRiskLevels             []string              `json:"user_risk_levels,omitempty"`
+	ServicePrincipalRiskLevels []string              `json:"service_principal_risk_levels,omitempty"`
+	InsiderRiskLevels          []string              `json:"insider_risk_levels,omitempty"`
+	Applications               *caApplications       `json:"applications,omitempty"`
+	ClientApplications         *caClientApplications `json:"client_applications,omitempty"`
+	Devices                    *caDevices            `json:"devices,omitempty"`
+	Locations                  *caLocations          `json:"locations,omitempty"`
+	Platforms                  *caPlatforms          `json:"platforms,omitempty"`
+	Users                      caUsers               `json:"users"`
+}
+
+type caApplications struct {
+	IncludeApplications          []string  `json:"included_applications,omitempty"`
+	ExcludeApplications          []string  `json:"excluded_applications,omitempty"`
+	IncludeUserActions           []string  `json:"included_user_actions,omitempty"`
+	IncludeAuthenticationContext []string  `json:"included_authentication_context_class_references,omitempty"`
+	Filter                       *caFilter `json:"filter,omitempty"`
+}
+
+type caClientApplications struct {
+	IncludeServicePrincipals []string  `json:"included_service_principals,omitempty"`
+	ExcludeServicePrincipals []string  `json:"excluded_service_principals,omitempty"`
+	Filter                   *caFilter `json:"filter,omitempty"`
+}
+
+type caFilter struct {
+	Mode string `json:"mode"`
+	Rule string `json:"rule"`
+}
+
+type caDevices struct {
+	Filter *caFilter `json:"filter,omitempty"`
+}
+
+type caLocations struct {
+	IncludeLocations []principalRef `json:"included_locations,omitempty"`
+	ExcludeLocations []principalRef `json:"excluded_locations,omitempty"`
+}
+
+type caPlatforms struct {
+	IncludePlatforms []string `json:"included_platforms,omitempty"`
+	ExcludePlatforms []string `json:"excluded_platforms,omitempty"`
+}
+
+type caUsers struct {
+	IncludeUsers  []principalRef           `json:"included_users,omitempty"`
+	ExcludeUsers  []principalRef           `json:"excluded_users,omitempty"`
+	IncludeGroups []principalRef           `json:"included_groups,omitempty"`
+	ExcludeGroups []principalRef           `json:"excluded_groups,omitempty"`
+	IncludeRoles  []string                 `json:"included_roles,omitempty"`
+	ExcludeRoles  []string                 `json:"excluded_roles,omitempty"`
+	IncludeGuests *caGuestsOrExternalUsers `json:"included_guests_or_external_users,omitempty"`
+	ExcludeGuests *caGuestsOrExternalUsers `json:"excluded_guests_or_external_users,omitempty"`
+}
+
+type caGuestsOrExternalUsers struct {
+	GuestOrExternalUserTypes []string           `json:"guest_or_external_user_types"`
+	ExternalTenants          *caExternalTenants `json:"external_tenants,omitempty"`
+}
+
+type caExternalTenants struct {
+	MembershipKind string   `json:"membership_kind"`
+	Members        []string `json:"members,omitempty"`
+}
+
+type caGrantControls struct {
+	Operator                       string   `json:"operator"`
+	BuiltInControls                []string `json:"built_in_controls,omitempty"`
+	CustomAuthenticationFactors    []string `json:"custom_authentication_factors,omitempty"`
+	TermsOfUse                     []string `json:"terms_of_use,omitempty"`
+	AuthenticationStrengthPolicyID string   `json:"authentication_strength_policy_id,omitempty"`
+}
+
+type caSessionControls struct {
+	ApplicationEnforcedRestrictionsEnabled *bool  `json:"application_enforced_restrictions_enabled,omitempty"`
+	DisableResilienceDefaults              *bool  `json:"disable_resilience_defaults,omitempty"`
+	SignInFrequency                        *int64 `json:"sign_in_frequency,omitempty"`
+	SignInFrequencyPeriod                  string `json:"sign_in_frequency_period,omitempty"`
+	SignInFrequencyAuthenticationType      string `json:"sign_in_frequency_authentication_type,omitempty"`
+	SignInFrequencyInterval                string `json:"sign_in_frequency_interval,omitempty"`
+	CloudAppSecurityPolicy                 string `json:"cloud_app_security_policy,omitempty"`
+	PersistentBrowserMode                  string `json:"persistent_browser_mode,omitempty"`
+}
+
+// isReferenceKeyword reports whether value is one of the special values Graph
+// accepts in user and location conditions instead of an object ID.
+func isReferenceKeyword(value string) bool {
+	switch value {
+	case "All", "None", "GuestsOrExternalUsers", "AllTrusted":
+		return true
+	}
+	return false
+}
+
+// newCAPolicy builds the normalized model for policy, resolving users, groups
+// and named locations through directory. Objects that cannot be resolved are
+// kept by ID so that nothing is silently dropped from the policy.
+func newCAPolicy(policy models.ConditionalAccessPolicy, directory *directoryCache) (*caPolicy, error) {
+	if policy.GetDisplayName() == nil {
+		return nil, fmt.Errorf("policy %s has no display name", stringValue(policy.GetId()))
+	}
+
+	p := &caPolicy{
+		ID:          stringValue(policy.GetId()),
+		DisplayName: *policy.GetDisplayName(),
+	}
+	if policy.GetState() != nil {
+		p.State = policy.GetState().String()
+	}
+
+	if conditions := policy.GetConditions(); conditions != nil {
+		p.Conditions = newCAConditions(conditions, directory)
+	}
+	if grantControls := policy.GetGrantControls(); grantControls != nil {
+		p.Grant = newCAGrantControls(grantControls)
+	}
+	if sessionControls := policy.GetSessionControls(); sessionControls != nil {
+		p.Session = newCASessionControls(sessionControls)
+	}
+
+	return p, nil
+}
+
+func newCAConditions(conditions models.ConditionalAccessConditionSetable, directory *directoryCache) caConditions {
+	c := caConditions{
+		ClientAppTypes:             enumStrings(conditions.GetClientAppTypes()),
+		SignInRiskLevels:           enumStrings(conditions.GetSignInRiskLevels()),
+		UserRiskLevels:             enumStrings(conditions.GetUserRiskLevels()),
+		ServicePrincipalRiskLevels: enumStrings(conditions.GetServicePrincipalRiskLevels()),
+	}
+	// insiderRiskLevels is newer than the SDK models, so it only shows up in
+	// the additional data of the condition set.
+	if levels, ok := conditions.GetAdditionalData()["insiderRiskLevels"].(*string); ok && levels != nil && *levels != "" {
+		c.InsiderRiskLevels = strings.Split(*levels, ",")
+	}
+
+	if applications := conditions.GetApplications(); applications != nil {
+		c.Applications = &caApplications{
+			IncludeApplications:          applications.GetIncludeApplications(),
+			ExcludeApplications:          applications.GetExcludeApplications(),
+			IncludeUserActions:           applications.GetIncludeUserActions(),
+			IncludeAuthenticationContext: applications.GetIncludeAuthenticationContextClassReferences(),
+			Filter:                       newCAFilter(applications.GetApplicationFilter()),
+		}
+	}
+
+	if clientApplications := conditions.GetClientApplications(); clientApplications != nil {
+		c.ClientApplications = &caClientApplications{
+			IncludeServicePrincipals: clientApplications.GetIncludeServicePrincipals(),
+			ExcludeServicePrincipals: clientApplications.GetExcludeServicePrincipals(),
+			Filter:                   newCAFilter(clientApplications.GetServicePrincipalFilter()),
+		}
+	}
+
+	if devices := conditions.GetDevices(); devices != nil {
+		c.Devices = &caDevices{Filter: newCAFilter(devices.GetDeviceFilter())}
+	}
+
+	if locations := conditions.GetLocations(); locations != nil {
+		c.Locations = &caLocations{
+			IncludeLocations: directory.resolveAll(locations.GetIncludeLocations(), directory.namedLocationName),
+			ExcludeLocations: directory.resolveAll(locations.GetExcludeLocations(), directory.namedLocationName),
+		}
+	}
+
+	if platforms := conditions.GetPlatforms(); platforms != nil {
+		c.Platforms = &caPlatforms{
+			IncludePlatforms: enumStrings(platforms.GetIncludePlatforms()),
+			ExcludePlatforms: enumStrings(platforms.GetExcludePlatforms()),
+		}
+	}
+
+	if users := conditions.GetUsers(); users != nil {
+		c.Users = caUsers{
+			IncludeUsers:  directory.resolveAll(users.GetIncludeUsers(), directory.userName),
+			ExcludeUsers:  directory.resolveAll(users.GetExcludeUsers(), directory.userName),
+			IncludeGroups: directory.resolveAll(users.GetIncludeGroups(), directory.groupName),
+			ExcludeGroups: directory.resolveAll(users.GetExcludeGroups(), directory.groupName),
+			IncludeRoles:  users.GetIncludeRoles(),
+			ExcludeRoles:  users.GetExcludeRoles(),
+			IncludeGuests: newCAGuestsOrExternalUsers(users.GetIncludeGuestsOrExternalUsers()),
+			ExcludeGuests: newCAGuestsOrExternalUsers(users.GetExcludeGuestsOrExternalUsers()),
+		}
+	}
+
+	return c
+}
+
+func newCAFilter(filter models.ConditionalAccessFilterable) *caFilter {
+	if filter == nil || filter.GetRule() == nil {
+		return nil
+	}
+	f := &caFilter{Rule: *filter.GetRule()}
+	if filter.GetMode() != nil {
+		f.Mode = filter.GetMode().String()
+	}
+	return f
+}
+
+func newCAGuestsOrExternalUsers(guests models.ConditionalAccessGuestsOrExternalUsersable) *caGuestsOrExternalUsers {
+	if guests == nil || guests.GetGuestOrExternalUserTypes() == nil {
+		return nil
+	}
+	g := &caGuestsOrExternalUsers{
+		GuestOrExternalUserTypes: strings.Split(guests.GetGuestOrExternalUserTypes().String(), ","),
+	}
+	if tenants := guests.GetExternalTenants(); tenants != nil && tenants.GetMembershipKind() != nil {
+		g.ExternalTenants = &caExternalTenants{MembershipKind: tenants.GetMembershipKind().String()}
+		if enumerated, ok := tenants.(models.ConditionalAccessEnumeratedExternalTenantsable); ok {
+			g.ExternalTenants.Members = enumerated.GetMembers()
+		}
+	}
+	return g
+}
+
+func newCAGrantControls(grantControls models.ConditionalAccessGrantControlsable) *caGrantControls {
+	g := &caGrantControls{
+		Operator:                    stringValue(grantControls.GetOperator()),
+		BuiltInControls:             enumStrings(grantControls.GetBuiltInControls()),
+		CustomAuthenticationFactors: grantControls.GetCustomAuthenticationFactors(),
+		TermsOfUse:                  grantControls.GetTermsOfUse(),
+	}
+	if strength := grantControls.GetAuthenticationStrength(); strength != nil {
+		g.AuthenticationStrengthPolicyID = stringValue(strength.GetId())
+	}
+	return g
+}
+
+func newCASessionControls(sessionControls models.ConditionalAccessSessionControlsable) *caSessionControls {
+	s := &caSessionControls{
+		DisableResilienceDefaults: sessionControls.GetDisableResilienceDefaults(),
+	}
+	if restrictions := sessionControls.GetApplicationEnforcedRestrictions(); restrictions != nil {
+		s.ApplicationEnforcedRestrictionsEnabled = restrictions.GetIsEnabled()
+	}
+	if frequency := sessionControls.GetSignInFrequency(); frequency != nil {
+		if frequency.GetValue() != nil {
+			value := int64(*frequency.GetValue())
+			s.SignInFrequency = &value
+		}
+		if frequency.GetTypeEscaped() != nil {
+			s.SignInFrequencyPeriod = frequency.GetTypeEscaped().String()
+		}
+		if frequency.GetAuthenticationType() != nil {
+			s.SignInFrequencyAuthenticationType = frequency.GetAuthenticationType().String()
+		}
+		if frequency.GetFrequencyInterval() != nil {
+			s.SignInFrequencyInterval = frequency.GetFrequencyInterval().String()
+		}
+	}
+	if cloudAppSecurity := sessionControls.GetCloudAppSecurity(); cloudAppSecurity != nil && cloudAppSecurity.GetCloudAppSecurityType() != nil {
+		s.CloudAppSecurityPolicy = cloudAppSecurity.GetCloudAppSecurityType().String()
+	}
+	if persistentBrowser := sessionControls.GetPersistentBrowser(); persistentBrowser != nil && persistentBrowser.GetMode() != nil {
+		s.PersistentBrowserMode = persistentBrowser.GetMode().String()
+	}
+	return s
+}
+
+// enumStrings converts a slice of Graph SDK enum values to their string form.
+func enumStrings[T fmt.Stringer](values []T) []string {
+	var result []string
+	for _, value := range values {
+		result = append(result, value.String())
+	}
+	return result
+}
+
+func stringValue(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
@@ -0,0 +1,45 @@
+package converter
+
+import (
+	"flag"
+)
+
+// commonFlags are the flags shared by every command that reads the config.
+type commonFlags struct {
+	configPath      *string
+	providerTarget  *string
+	providerVersion *string
+	graphEndpoint   *string
+}
+
+func addCommonFlags(fs *flag.FlagSet) *commonFlags {
+	return &commonFlags{
+		configPath:      fs.String("config", "", "path to a JSON config file"),
+		providerTarget:  fs.String("provider-target", "", "azuread provider major version to generate for, v2 or v3 (default \""+defaultProviderTarget+"\")"),
+		providerVersion: fs.String("provider-version", "", "version constraint for the hashicorp/azuread provider (default depends on -provider-target)"),
+		graphEndpoint:   fs.String("graph-endpoint", "", "send Graph requests to this base URL without authentication, e.g. a local fake Graph server"),
+	}
+}
+
+// load reads the config file and applies the flag overrides.
+func (c *commonFlags) load() (*Config, *providerSchema, error) {
+	cfg, err := LoadConfig(*c.configPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	if *c.providerTarget != "" {
+		cfg.ProviderTarget = *c.providerTarget
+	}
+	if *c.providerVersion != "" {
+		cfg.ProviderVersion = *c.providerVersion
+	}
+	if *c.graphEndpoint != "" {
+		cfg.GraphEndpoint = *c.graphEndpoint
+	}
+	schema, err := lookupProviderSchema(cfg.ProviderTarget, cfg.ProviderVersion)
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg.ProviderVersion = schema.version
+	return cfg, schema, nil
+}
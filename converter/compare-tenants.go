@@ -0,0 +1,348 @@
+package converter
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// tenantSourcePrefix selects a tenant as a side of compare; "tenant" alone is
+// the tenant the Azure CLI is signed in to.
+const tenantSourcePrefix = "tenant"
+
+// comparisonReport lists the differences between the policies of two
+// tenants or snapshots.
+type comparisonReport struct {
+	Left  string `json:"left"`
+	Right string `json:"right"`
+	// OnlyLeft and OnlyRight are the policies missing from the other side.
+	OnlyLeft  []string       `json:"only_left"`
+	OnlyRight []string       `json:"only_right"`
+	Changed   []policyChange `json:"changed"`
+}
+
+// policyChange is a policy present on both sides with differing fields,
+// named as on the left side.
+type policyChange struct {
+	Policy      string      `json:"policy"`
+	Differences []fieldDiff `json:"differences"`
+}
+
+type fieldDiff struct {
+	Path  string `json:"path"`
+	Left  string `json:"left"`
+	Right string `json:"right"`
+}
+
+func (r *comparisonReport) hasDifferences() bool {
+	return len(r.OnlyLeft) > 0 || len(r.OnlyRight) > 0 || len(r.Changed) > 0
+}
+
+// comparedPolicy is a normalized policy with the template it was created
+// from.
+type comparedPolicy struct {
+	*caPolicy
+	templateID string
+}
+
+// RunCompare compares the policies of two tenants or snapshots and returns
+// the process exit code: 0 when they match, 2 when they differ and 1 on
+// errors.
+func RunCompare(args []string) int {
+	ctx := context.Background()
+
+	fs := flag.NewFlagSet("compare", flag.ExitOnError)
+	common := addCommonFlags(fs)
+	format := fs.String("format", "text", "output format: text or json")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: compare [flags] LEFT RIGHT")
+		fmt.Fprintln(fs.Output(), "LEFT and RIGHT are a backup directory or file, \"tenant\" for the signed-in tenant, or \"tenant:<tenant ID>\".")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 1
+	}
+
+	cfg, _, err := common.load()
+	if err != nil {
+		log.Printf("error loading config: %v", err)
+		return 1
+	}
+	var sides [2][]comparedPolicy
+	for i, source := range fs.Args() {
+		if sides[i], err = loadComparedPolicies(ctx, cfg, source); err != nil {
+			log.Printf("error getting policies from %s: %v", source, err)
+			return 1
+		}
+	}
+	report, err := comparePolicies(sides[0], sides[1])
+	if err != nil {
+		log.Printf("error comparing policies: %v", err)
+		return 1
+	}
+	report.Left, report.Right = fs.Arg(0), fs.Arg(1)
+
+	switch *format {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(report)
+	case "text":
+		writeComparisonText(os.Stdout, report)
+	default:
+		log.Printf("unknown format %q, expected text or json", *format)
+		return 1
+	}
+	if err != nil {
+		log.Printf("error writing report: %v", err)
+		return 1
+	}
+	if report.hasDifferences() {
+		return 2
+	}
+	return 0
+}
+
+// loadComparedPolicies reads the policies of a tenant, or of backups when
+// source is a path, and resolves their references.
+func loadComparedPolicies(ctx context.Context, cfg *Config, source string) ([]comparedPolicy, error) {
+	var loaded policyDirectory
+	if tenantID, ok := strings.CutPrefix(source, tenantSourcePrefix); ok && (tenantID == "" || strings.HasPrefix(tenantID, ":")) {
+		client, err := NewTenantGraphClient(ctx, cfg.GraphEndpoint, strings.TrimPrefix(tenantID, ":"))
+		if err != nil {
+			return nil, err
+		}
+		loaded = &GraphSource{Client: client}
+	} else {
+		paths := []string{source}
+		if info, err := os.Stat(source); err != nil {
+			return nil, err
+		} else if info.IsDir() {
+			if paths, err = backupFiles(source); err != nil {
+				return nil, err
+			}
+		}
+		backups, err := NewBackupSource(paths)
+		if err != nil {
+			return nil, err
+		}
+		loaded = backups
+	}
+	policies, directory, err := loadPolicies(ctx, loaded)
+	if err != nil {
+		return nil, err
+	}
+
+	var compared []comparedPolicy
+	for _, policy := range policies {
+		p, err := newCAPolicy(policy, directory)
+		if err != nil {
+			return nil, err
+		}
+		c := comparedPolicy{caPolicy: p}
+		if templateID := policy.GetTemplateId(); templateID != nil {
+			c.templateID = *templateID
+		}
+		compared = append(compared, c)
+	}
+	return compared, nil
+}
+
+// comparePolicies matches policies by display name, then by template ID
+// when only one policy on each side comes from the template, and compares
+// the fields of each matched pair. References are compared by resolved
+// name, as IDs differ between tenants.
+func comparePolicies(left, right []comparedPolicy) (*comparisonReport, error) {
+	report := &comparisonReport{OnlyLeft: []string{}, OnlyRight: []string{}, Changed: []policyChange{}}
+
+	byName := map[string][]int{}
+	for i, p := range right {
+		byName[p.DisplayName] = append(byName[p.DisplayName], i)
+	}
+	pairs := make([]int, len(left))
+	matched := map[int]bool{}
+	for j, l := range left {
+		pairs[j] = -1
+		if indices := byName[l.DisplayName]; len(indices) > 0 {
+			pairs[j] = indices[0]
+			byName[l.DisplayName] = indices[1:]
+			matched[indices[0]] = true
+		}
+	}
+
+	leftTemplates, rightTemplates := map[string]int{}, map[string]int{}
+	byTemplate := map[string]int{}
+	for _, p := range left {
+		leftTemplates[p.templateID]++
+	}
+	for i, p := range right {
+		rightTemplates[p.templateID]++
+		byTemplate[p.templateID] = i
+	}
+	for j, l := range left {
+		if pairs[j] >= 0 || l.templateID == "" || leftTemplates[l.templateID] != 1 || rightTemplates[l.templateID] != 1 {
+			continue
+		}
+		if i := byTemplate[l.templateID]; !matched[i] {
+			pairs[j] = i
+			matched[i] = true
+		}
+	}
+
+	for j, l := range left {
+		if pairs[j] < 0 {
+			report.OnlyLeft = append(report.OnlyLeft, l.DisplayName)
+			continue
+		}
+		r := right[pairs[j]]
+
+		leftFields, err := comparableFields(l.caPolicy)
+		if err != nil {
+			return nil, err
+		}
+		rightFields, err := comparableFields(r.caPolicy)
+		if err != nil {
+			return nil, err
+		}
+		change := policyChange{Policy: l.DisplayName}
+		paths := map[string]bool{}
+		for path := range leftFields {
+			paths[path] = true
+		}
+		for path := range rightFields {
+			paths[path] = true
+		}
+		for path := range paths {
+			leftValue, rightValue := unsetValue, unsetValue
+			if value, ok := leftFields[path]; ok {
+				leftValue = value
+			}
+			if value, ok := rightFields[path]; ok {
+				rightValue = value
+			}
+			if leftValue != rightValue {
+				change.Differences = append(change.Differences, fieldDiff{path, leftValue, rightValue})
+			}
+		}
+		if len(change.Differences) > 0 {
+			sort.Slice(change.Differences, func(i, j int) bool { return change.Differences[i].Path < change.Differences[j].Path })
+			report.Changed = append(report.Changed, change)
+		}
+	}
+	for i, r := range right {
+		if !matched[i] {
+			report.OnlyRight = append(report.OnlyRight, r.DisplayName)
+		}
+	}
+
+	sort.Strings(report.OnlyLeft)
+	sort.Strings(report.OnlyRight)
+	sort.Slice(report.Changed, func(i, j int) bool { return report.Changed[i].Policy < report.Changed[j].Policy })
+	return report, nil
+}
+
+// comparableFields flattens the normalized policy into its fields by dotted
+// JSON path. The ID is left out, references become their name and lists are
+// sorted, since their order carries no meaning.
+func comparableFields(p *caPolicy) (map[string]string, error) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	var value map[string]any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	delete(value, "id")
+
+	fields := map[string]string{}
+	var flatten func(path string, value any)
+	flatten = func(path string, value any) {
+		switch v := value.(type) {
+		case map[string]any:
+			if ref, ok := comparableRef(v); ok {
+				fields[path] = ref
+				return
+			}
+			for key, child := range v {
+				childPath := key
+				if path != "" {
+					childPath = path + "." + key
+				}
+				flatten(childPath, child)
+			}
+		case []any:
+			elements := make([]string, len(v))
+			for i, element := range v {
+				if ref, ok := element.(map[string]any); ok {
+					if name, ok := comparableRef(ref); ok {
+						elements[i] = name
+						continue
+					}
+				}
+				data, _ := json.Marshal(element)
+				elements[i] = strings.Trim(string(data), `"`)
+			}
+			sort.Strings(elements)
+			fields[path] = strings.Join(elements, ", ")
+		case nil:
+		default:
+			data, _ := json.Marshal(v)
+			fields[path] = strings.Trim(string(data), `"`)
+		}
+	}
+	flatten("", value)
+	return fields, nil
+}
+
+// comparableRef returns the name of a principalRef decoded from JSON, or its
+// ID when it was not resolved.
+func comparableRef(value map[string]any) (string, bool) {
+	id, ok := value["id"].(string)
+	if !ok || len(value) > 2 {
+		return "", false
+	}
+	if name, ok := value["name"].(string); ok {
+		return name, true
+	}
+	return id, len(value) == 1
+}
+
+func writeComparisonText(w io.Writer, report *comparisonReport) {
+	if !report.hasDifferences() {
+		fmt.Fprintf(w, "No differences between %s and %s.\n", report.Left, report.Right)
+		return
+	}
+	for _, side := range []struct {
+		name     string
+		policies []string
+	}{
+		{report.Left, report.OnlyLeft},
+		{report.Right, report.OnlyRight},
+	} {
+		if len(side.policies) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "Only in %s:\n", side.name)
+		for _, name := range side.policies {
+			fmt.Fprintf(w, "  - %s\n", name)
+		}
+	}
+	if len(report.Changed) > 0 {
+		fmt.Fprintln(w, "Changed:")
+	}
+	for _, change := range report.Changed {
+		fmt.Fprintf(w, "  %s\n", change.Policy)
+		for _, diff := range change.Differences {
+			fmt.Fprintf(w, "    %s: %s: %s, %s: %s\n", diff.Path, report.Left, diff.Left, report.Right, diff.Right)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package converter
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestComparePoliciesMatching(t *testing.T) {
+	policy := func(name, templateID string) comparedPolicy {
+		return comparedPolicy{caPolicy: testCAPolicy("", name), templateID: templateID}
+	}
+	for _, tc := range []struct {
+		name                         string
+		left, right                  []comparedPolicy
+		changed, onlyLeft, onlyRight []string
+	}{
+		{
+			name:  "by display name",
+			left:  []comparedPolicy{policy("Require MFA", ""), policy("Block legacy", "")},
+			right: []comparedPolicy{policy("Block legacy", ""), policy("Require MFA", "")},
+		},
+		{
+			name:    "renamed policy by template",
+			left:    []comparedPolicy{policy("Require MFA", "t1")},
+			right:   []comparedPolicy{policy("Require MFA for admins", "t1")},
+			changed: []string{"Require MFA"},
+		},
+		{
+			name:  "display name before template",
+			left:  []comparedPolicy{policy("Require MFA", "t1"), policy("Require MFA for admins", "t2")},
+			right: []comparedPolicy{policy("Require MFA for admins", "t1"), policy("Require MFA", "t2")},
+		},
+		{
+			name:      "two policies from the same template",
+			left:      []comparedPolicy{policy("Require MFA", "t1"), policy("Require MFA for admins", "t1")},
+			right:     []comparedPolicy{policy("Require MFA", "t1"), policy("Require MFA for guests", "t1")},
+			onlyLeft:  []string{"Require MFA for admins"},
+			onlyRight: []string{"Require MFA for guests"},
+		},
+		{
+			name:      "template used twice on one side",
+			left:      []comparedPolicy{policy("Require MFA", "t1")},
+			right:     []comparedPolicy{policy("Require MFA for admins", "t1"), policy("Require MFA for guests", "t1")},
+			onlyLeft:  []string{"Require MFA"},
+			onlyRight: []string{"Require MFA for admins", "Require MFA for guests"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			report, err := comparePolicies(tc.left, tc.right)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var changed []string
+			for _, change := range report.Changed {
+				changed = append(changed, change.Policy)
+			}
+			if !reflect.DeepEqual(changed, tc.changed) {
+				t.Errorf("changed = %v, want %v", changed, tc.changed)
+			}
+			if want := append([]string{}, tc.onlyLeft...); !reflect.DeepEqual(report.OnlyLeft, want) {
+				t.Errorf("only left = %v, want %v", report.OnlyLeft, want)
+			}
+			if want := append([]string{}, tc.onlyRight...); !reflect.DeepEqual(report.OnlyRight, want) {
+				t.Errorf("only right = %v, want %v", report.OnlyRight, want)
+			}
+		})
+	}
+}
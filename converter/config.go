@@ -0,0 +1,179 @@
+package converter
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+const defaultProviderTarget = "v2"
+
+// Config holds the settings for a run. Values are read from an optional JSON
+// config file and may be overridden by command line flags.
+type Config struct {
+	// OutputDir is the directory the configuration is generated in. It is
+	// created if it does not exist.
+	OutputDir string `json:"output_dir"`
+
+	// Mode selects how policies are written: "resources" for one resource
+	// per policy, "module" for a map of policies passed to a local module
+	// with for_each, or "yaml" for one YAML file per policy loaded into that
+	// module. Layout only applies to the resources mode.
+	Mode string `json:"mode"`
+
+	// Layout decides which file each policy goes to: "per-policy",
+	// "single", "by-state" or "by-prefix".
+	Layout string `json:"layout"`
+
+	// LayoutPrefixPattern is the regular expression whose first capture
+	// group is the group name for the by-prefix layout.
+	LayoutPrefixPattern string `json:"layout_prefix_pattern,omitempty"`
+
+	// SplitData writes data sources to data_users.tf, data_groups.tf and
+	// data_named_locations.tf instead of data.tf.
+	SplitData bool `json:"split_data"`
+
+	// Docs writes a Markdown document per policy and an index of all
+	// policies to the docs directory in OutputDir.
+	Docs bool `json:"docs"`
+
+	// Matrix writes a table with one row per policy to OutputDir, as
+	// policies.csv for "csv" or policies.tsv for "tsv".
+	Matrix string `json:"matrix,omitempty"`
+
+	// Graph writes a dependency graph linking policies to the users,
+	// groups, roles, applications and named locations they include or
+	// exclude, as policies.dot and policies.mmd in OutputDir.
+	Graph bool `json:"graph"`
+
+	// Backup writes a Graph JSON backup of every policy, with a sidecar
+	// naming the objects it refers to, to the backup directory in OutputDir.
+	Backup bool `json:"backup"`
+
+	// Bicep writes a template for the Microsoft Graph Bicep extension
+	// declaring every policy and the named locations they use, with
+	// references to their users and the object IDs of their groups, to the
+	// bicep directory in OutputDir.
+	Bicep bool `json:"bicep"`
+
+	// ProviderTarget selects the azuread provider major version, "v2" or
+	// "v3", whose schema the generated resources follow.
+	ProviderTarget string `json:"provider_target"`
+
+	// ProviderVersion is the version constraint written to required_providers
+	// for hashicorp/azuread. It defaults to the constraint of ProviderTarget.
+	// Fields added after the lowest release the constraint allows are
+	// rejected like fields ProviderTarget does not have.
+	ProviderVersion string `json:"provider_version"`
+
+	// PolicyResource selects the resource type policies are written as in
+	// the resources mode: "azuread" for azuread_conditional_access_policy,
+	// "msgraph" for msgraph_resource with the policy's Graph JSON as body, or
+	// "auto" to use msgraph_resource only for policies the azuread schema of
+	// ProviderTarget cannot express. Drift detection and pruning only cover
+	// azuread resources.
+	PolicyResource string `json:"policy_resource"`
+
+	// MSGraphProviderVersion is the version constraint written to
+	// required_providers for microsoft/msgraph when PolicyResource is
+	// "msgraph" or "auto".
+	MSGraphProviderVersion string `json:"msgraph_provider_version,omitempty"`
+
+	// Binary selects the CLI used for the import and verify workflows:
+	// "terraform", "tofu" or "auto" to use whichever is installed.
+	Binary string `json:"binary"`
+
+	// Prune selects what happens to resources for policies deleted from the
+	// tenant: "" to only report them, "delete", "archive" or "removed". The
+	// module mode supports only "delete", the yaml mode not "removed".
+	Prune string `json:"prune,omitempty"`
+
+	// Mapping is the path of a JSON file mapping users, groups and named
+	// locations onto their names in the tenant the configuration is
+	// promoted to, as {"users": {...}, "groups": {...}, "named_locations":
+	// {...}} keyed by source name or object ID, with the target "tenant_id"
+	// for the providers. "terms_of_use", "authentication_strengths",
+	// "service_principals" and "external_tenants" map source IDs onto
+	// target IDs. The Terraform configuration then refers to the
+	// target objects and has no import blocks, and generate fails after
+	// writing it when a reference has no mapping. Backups and Bicep output
+	// still describe the source tenant.
+	Mapping string `json:"mapping,omitempty"`
+
+	// GraphEndpoint, when set, sends Graph requests to this base URL without
+	// credentials instead of to Microsoft Graph, e.g. for a fake Graph
+	// server in tests.
+	GraphEndpoint string `json:"graph_endpoint,omitempty"`
+
+	// Lint configures the rules of the lint command.
+	Lint LintConfig `json:"lint"`
+
+	// Backend, when set, adds a backend block to versions.tf.
+	Backend *BackendConfig `json:"backend,omitempty"`
+}
+
+// BackendConfig describes the Terraform backend to generate. Type selects one
+// of the built-in templates and Settings fills in or extends its attributes.
+type BackendConfig struct {
+	Type     string            `json:"type"`
+	Settings map[string]string `json:"settings,omitempty"`
+}
+
+// DefaultConfig returns the settings used when no config file is given.
+func DefaultConfig() *Config {
+	return &Config{
+		OutputDir:      defaultOutputDir,
+		Mode:           modeResources,
+		Layout:         layoutPerPolicy,
+		ProviderTarget: defaultProviderTarget,
+		PolicyResource: policyResourceAzureAD,
+		Binary:         "auto",
+	}
+}
+
+// LoadConfig reads the JSON config file at path on top of the defaults. An
+// empty path returns the defaults unchanged.
+func LoadConfig(path string) (*Config, error) {
+	cfg := DefaultConfig()
+	if path == "" {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file: %v", err)
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("error parsing config file %s: %v", path, err)
+	}
+
+	return cfg, nil
+}
+
+// validate reports settings with unknown values or that do not go together.
+func (c *Config) validate() error {
+	switch c.Mode {
+	case modeResources, modeModule, modeYAML:
+	default:
+		return fmt.Errorf("unknown mode %q, expected %s, %s or %s", c.Mode, modeResources, modeModule, modeYAML)
+	}
+	switch c.PolicyResource {
+	case policyResourceAzureAD:
+	case policyResourceMSGraph, policyResourceAuto:
+		if c.Mode != modeResources {
+			return fmt.Errorf("policy resource %q is only supported in the %s mode", c.PolicyResource, modeResources)
+		}
+	default:
+		return fmt.Errorf("unknown policy resource %q, expected %s, %s or %s", c.PolicyResource, policyResourceAzureAD, policyResourceMSGraph, policyResourceAuto)
+	}
+	switch {
+	case c.Mode == modeModule && (c.Prune == pruneArchive || c.Prune == pruneRemoved):
+		return fmt.Errorf("prune mode %q is not supported in the %s mode, use %s", c.Prune, c.Mode, pruneDelete)
+	case c.Mode == modeYAML && c.Prune == pruneRemoved:
+		return fmt.Errorf("prune mode %q is not supported in the %s mode, use %s or %s", c.Prune, c.Mode, pruneDelete, pruneArchive)
+	}
+	if c.Matrix != "" && c.Matrix != matrixCSV && c.Matrix != matrixTSV {
+		return fmt.Errorf("unknown matrix format %q, expected %s or %s", c.Matrix, matrixCSV, matrixTSV)
+	}
+	return nil
+}
@@ -0,0 +1,539 @@
+package converter
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// dataSourceKind describes the data source used to look up one kind of
+// object referenced by policies.
+type dataSourceKind struct {
+	dataType  string
+	attribute string
+	label     func(name string) string
+}
+
+var (
+	userDataSource          = dataSourceKind{"azuread_user", "user_principal_name", userDataName}
+	groupDataSource         = dataSourceKind{"azuread_group", "display_name", groupDataName}
+	namedLocationDataSource = dataSourceKind{"azuread_named_location", "display_name", namedLocationDataName}
+
+	dataSourceKinds = []dataSourceKind{userDataSource, groupDataSource, namedLocationDataSource}
+)
+
+// dataSourceRef is a data source a rendered policy refers to.
+type dataSourceRef struct {
+	kind dataSourceKind
+	name string
+}
+
+func policyResourceName(displayName string) string {
+	return strings.ToLower(strings.ReplaceAll(displayName, " ", "_"))
+}
+
+// policyAddress returns the resource address of the policy resource with the
+// given name.
+func policyAddress(resourceName string) hcl.Traversal {
+	return resourceAddress(azureADPolicyResourceType, resourceName)
+}
+
+func userDataName(upn string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(upn, "@", "_"), ".", "_")
+}
+
+func groupDataName(group string) string {
+	return strings.ReplaceAll(group, " ", "_")
+}
+
+func namedLocationDataName(location string) string {
+	return strings.ReplaceAll(location, " ", "_")
+}
+
+// dataSourceSet collects the data sources referenced by all generated
+// policies, in the order they were first referenced.
+type dataSourceSet struct {
+	refs []dataSourceRef
+	seen map[string]bool
+}
+
+func newDataSourceSet() *dataSourceSet {
+	return &dataSourceSet{seen: map[string]bool{}}
+}
+
+func (d *dataSourceSet) add(refs ...dataSourceRef) {
+	for _, ref := range refs {
+		key := ref.kind.dataType + "." + ref.kind.label(ref.name)
+		if !d.seen[key] {
+			d.seen[key] = true
+			d.refs = append(d.refs, ref)
+		}
+	}
+}
+
+// writeDataFiles merges the collected data sources into data.tf in dir, or
+// into one file per kind of object when split is set. Data sources that are
+// no longer referenced are left in place, as they may be used by
+// hand-written configuration.
+func (d *dataSourceSet) writeDataFiles(out OutputWriter, log io.Writer, dir string, split bool, values *attributeValues) error {
+	files := map[string]*hclwrite.File{}
+	var names []string
+	for _, ref := range d.refs {
+		name := dataFileName(ref.kind, split)
+		f, ok := files[name]
+		if !ok {
+			f = hclwrite.NewEmptyFile()
+			files[name] = f
+			names = append(names, name)
+		} else {
+			f.Body().AppendNewline()
+		}
+		dataBlock := f.Body().AppendNewBlock("data", []string{ref.kind.dataType, ref.kind.label(ref.name)})
+		dataBlock.Body().SetAttributeValue(ref.kind.attribute, cty.StringVal(ref.name))
+	}
+
+	for _, name := range names {
+		// data sources move between data.tf and the split files when the
+		// split setting changes
+		for _, other := range allDataFileNames() {
+			if other == name {
+				continue
+			}
+			if err := removeMatchingBlocks(out, filepath.Join(dir, other), files[name]); err != nil {
+				return err
+			}
+		}
+
+		path := filepath.Join(dir, name)
+		change, err := writeMergedFile(out, path, files[name], isDataSourceAttribute, values)
+		if err != nil {
+			return err
+		}
+		if change != fileUnchanged {
+			fmt.Fprintf(log, "%s data file: %s\n", change, path)
+		}
+	}
+	return nil
+}
+
+// allDataFileNames returns every file data sources may be written to.
+func allDataFileNames() []string {
+	names := []string{dataFileName(userDataSource, false)}
+	for _, kind := range dataSourceKinds {
+		names = append(names, dataFileName(kind, true))
+	}
+	return names
+}
+
+// isDataSourceAttribute reports whether path is an attribute the generator
+// writes in a data block.
+func isDataSourceAttribute(path string) bool {
+	for _, kind := range dataSourceKinds {
+		if path == kind.attribute {
+			return true
+		}
+	}
+	return false
+}
+
+// policyGenerator holds the settings and state shared by the policies
+// generated in one run.
+type policyGenerator struct {
+	mode      string
+	out       OutputWriter
+	log       io.Writer
+	outputDir string
+	layout    *outputLayout
+	schema    *providerSchema
+	directory *directoryCache
+	data      *dataSourceSet
+	manifest  *policyManifest
+	values    *attributeValues
+
+	// policyResource selects the resource type policies are written as in
+	// the resources mode: azuread, msgraph or auto.
+	policyResource string
+
+	// mapping, when set, promotes the policies to another tenant.
+	mapping *principalMapping
+
+	// labels are the resource labels assigned to the policies by ID.
+	labels map[string]string
+
+	// policies are the policies generated so far, for the outputs written
+	// once all policies are known such as the documentation.
+	policies []*caPolicy
+
+	// modulePolicies collects the policies rendered for the module and yaml
+	// output modes until they are written together.
+	modulePolicies []modulePolicy
+}
+
+// label returns the resource label assigned to p, or the one derived from its
+// display name for a policy without an ID.
+func (g *policyGenerator) label(p *caPolicy) string {
+	if label, ok := g.labels[p.ID]; ok {
+		return label
+	}
+	return policyResourceName(p.DisplayName)
+}
+
+// create_azurecapolicy generates the configuration for policy, merging it
+// into any existing file, and records the data sources it refers to. When the
+// manifest shows the policy was generated under another label or in another
+// file, the old configuration is carried over first and a moved block is
+// added for a new label. When it was generated as another resource type, the
+// old resource is dropped and a removed block keeps Terraform from destroying
+// the policy while the import block adopts it into the new resource.
+func create_azurecapolicy(policy models.ConditionalAccessPolicy, g *policyGenerator) error {
+	p, err := newCAPolicy(policy, g.directory)
+	if err != nil {
+		return err
+	}
+	rendered := g.promotePolicy(p)
+
+	label := g.label(p)
+	resourceType := azureADPolicyResourceType
+	var f *hclwrite.File
+	var dataSources []dataSourceRef
+	if g.policyResource != policyResourceMSGraph {
+		f, dataSources, err = renderPolicyFile(rendered, label, g.schema)
+	}
+	var unsupported *unsupportedFeatureError
+	if g.policyResource == policyResourceMSGraph || g.policyResource == policyResourceAuto && errors.As(err, &unsupported) {
+		if unsupported != nil {
+			fmt.Fprintf(g.log, "Writing policy %q as %s: %v\n", p.DisplayName, msgraphResourceType, unsupported)
+		}
+		resourceType = msgraphResourceType
+		f, dataSources, err = renderMSGraphPolicyFile(rendered, label, &policy)
+	}
+	if err != nil {
+		return err
+	}
+	g.data.add(dataSources...)
+
+	fileName := g.layout.fileName(p, label)
+	path := filepath.Join(g.outputDir, fileName)
+	if previous, ok := g.manifest.Policies[p.ID]; ok && previous.resourceType() != resourceType {
+		if err := removePolicyResource(g.out, filepath.Join(g.outputDir, previous.File), previous.resourceType(), previous.Label); err != nil {
+			return fmt.Errorf("error replacing policy %q: %v", p.DisplayName, err)
+		}
+		if err := removeRemovedBlock(g.out, path, resourceAddress(resourceType, label)); err != nil {
+			return fmt.Errorf("error replacing policy %q: %v", p.DisplayName, err)
+		}
+		f.Body().AppendNewline()
+		appendRemovedBlock(f.Body(), previous.resourceType(), previous.Label)
+		fmt.Fprintf(g.log, "Replaced policy %s.%s with %s.%s\n", previous.resourceType(), previous.Label, resourceType, label)
+	} else if ok && (previous.Label != label || previous.File != fileName) {
+		if err := movePolicyResource(g.out, filepath.Join(g.outputDir, previous.File), path, resourceType, previous.Label, label); err != nil {
+			return fmt.Errorf("error moving policy %q: %v", p.DisplayName, err)
+		}
+		if previous.Label != label {
+			appendMovedBlock(f.Body(), resourceType, previous.Label, label)
+			fmt.Fprintf(g.log, "Moved policy %s -> %s\n", previous.Label, label)
+		}
+	}
+
+	merger := &bodyMerger{known: func(path string) bool {
+		return g.schema.knows(path) || isMSGraphResourcePath(path)
+	}}
+	change, err := merger.writeFile(g.out, path, f, g.values)
+	if err != nil {
+		return err
+	}
+	g.warnSkipped(path, merger.skipped)
+	if p.ID != "" {
+		entry := manifestEntry{DisplayName: p.DisplayName, Label: label, File: fileName}
+		if resourceType != azureADPolicyResourceType {
+			entry.ResourceType = resourceType
+		}
+		g.manifest.Policies[p.ID] = entry
+	}
+	g.policies = append(g.policies, p)
+
+	if change != fileUnchanged {
+		fmt.Fprintf(g.log, "%s terraform file for policy: %s \n", change, p.DisplayName)
+	}
+	return nil
+}
+
+// warnSkipped logs the hand edits in the file at path that were kept although
+// the policy changed in the tenant, as the next apply reverts that change.
+func (g *policyGenerator) warnSkipped(path string, skipped []string) {
+	for _, key := range skipped {
+		fmt.Fprintf(g.log, "Warning: keeping the hand edit of %s in %s, which hides a change in the tenant\n", key, path)
+	}
+}
+
+// renderPolicyFile renders p as an azuread_conditional_access_policy resource
+// with the given label for the given provider schema. It returns the data sources the resource
+// refers to, or an *unsupportedFeatureError if the policy uses fields the
+// schema cannot express.
+func renderPolicyFile(p *caPolicy, resourceName string, schema *providerSchema) (*hclwrite.File, []dataSourceRef, error) {
+	r := &policyRenderer{schema: schema}
+
+	// create new empty hcl file object
+	f := hclwrite.NewEmptyFile()
+	rootBody := f.Body()
+
+	// Create Azure AD Conditional Access Policy resource block
+	azureADPolicy := rootBody.AppendNewBlock("resource", []string{azureADPolicyResourceType, resourceName})
+	r.renderPolicy(azureADPolicy.Body(), p)
+
+	// Record the policy ID with an import block, which also lets a plan
+	// adopt the existing policy without a separate import step
+	if p.ID != "" {
+		rootBody.AppendNewline()
+		importBlock := rootBody.AppendNewBlock("import", nil)
+		importBlock.Body().SetAttributeTraversal("to", policyAddress(resourceName))
+		importBlock.Body().SetAttributeValue("id", cty.StringVal(p.ID))
+	}
+
+	if len(r.unsupported) > 0 {
+		return nil, nil, &unsupportedFeatureError{policy: p.DisplayName, target: schema.target, version: schema.version, paths: r.unsupported}
+	}
+	return f, r.dataSources, nil
+}
+
+// policyRenderer writes the normalized model using the attribute names of a
+// provider schema. Fields the schema does not know are collected in
+// unsupported instead of being written.
+type policyRenderer struct {
+	schema      *providerSchema
+	unsupported []string
+	dataSources []dataSourceRef
+}
+
+func (r *policyRenderer) renderPolicy(body *hclwrite.Body, p *caPolicy) {
+	// Set attributes for Azure AD Conditional Access Policy
+	r.setValue(body, "display_name", cty.StringVal(p.DisplayName))
+	r.setValue(body, "state", cty.StringVal(p.State))
+	body.AppendNewline()
+
+	r.renderConditions(r.block(body, "conditions"), &p.Conditions)
+	body.AppendNewline()
+
+	// Add grant_controls block
+	if grant := p.Grant; grant != nil {
+		grantBody := r.block(body, "grant_controls")
+		r.setValue(grantBody, "grant_controls.operator", cty.StringVal(grant.Operator))
+		r.setList(grantBody, "grant_controls.built_in_controls", grant.BuiltInControls)
+		r.setList(grantBody, "grant_controls.custom_authentication_factors", grant.CustomAuthenticationFactors)
+		r.setList(grantBody, "grant_controls.terms_of_use", grant.TermsOfUse)
+		if grant.AuthenticationStrengthPolicyID != "" {
+			r.setValue(grantBody, "grant_controls.authentication_strength_policy_id", cty.StringVal(grant.AuthenticationStrengthPolicyID))
+		}
+		body.AppendNewline()
+	}
+
+	// Add session_controls block
+	if session := p.Session; session != nil {
+		sessionBody := r.block(body, "session_controls")
+		if session.ApplicationEnforcedRestrictionsEnabled != nil {
+			r.setValue(sessionBody, "session_controls.application_enforced_restrictions_enabled", cty.BoolVal(*session.ApplicationEnforcedRestrictionsEnabled))
+		}
+		if session.DisableResilienceDefaults != nil {
+			r.setValue(sessionBody, "session_controls.disable_resilience_defaults", cty.BoolVal(*session.DisableResilienceDefaults))
+		}
+		if session.SignInFrequency != nil {
+			r.setValue(sessionBody, "session_controls.sign_in_frequency", cty.NumberIntVal(*session.SignInFrequency))
+		}
+		r.setString(sessionBody, "session_controls.sign_in_frequency_period", session.SignInFrequencyPeriod)
+		r.setString(sessionBody, "session_controls.sign_in_frequency_authentication_type", session.SignInFrequencyAuthenticationType)
+		r.setString(sessionBody, "session_controls.sign_in_frequency_interval", session.SignInFrequencyInterval)
+		r.setString(sessionBody, "session_controls.cloud_app_security_policy", session.CloudAppSecurityPolicy)
+		r.setString(sessionBody, "session_controls.persistent_browser_mode", session.PersistentBrowserMode)
+	}
+}
+
+func (r *policyRenderer) renderConditions(body *hclwrite.Body, c *caConditions) {
+	// Set conditions attributes
+	r.setList(body, "conditions.client_app_types", c.ClientAppTypes)
+	r.setList(body, "conditions.sign_in_risk_levels", c.SignInRiskLevels)
+	r.setList(body, "conditions.user_risk_levels", c.UserRiskLevels)
+	r.setList(body, "conditions.service_principal_risk_levels", c.ServicePrincipalRiskLevels)
+	r.setList(body, "conditions.insider_risk_levels", c.InsiderRiskLevels)
+	body.AppendNewline()
+
+	// Add applications block
+	if apps := c.Applications; apps != nil {
+		appsBody := r.block(body, "conditions.applications")
+		r.setList(appsBody, "conditions.applications.included_applications", apps.IncludeApplications)
+		r.setList(appsBody, "conditions.applications.excluded_applications", apps.ExcludeApplications)
+		r.setList(appsBody, "conditions.applications.included_user_actions", apps.IncludeUserActions)
+		r.setList(appsBody, "conditions.applications.included_authentication_context_class_references", apps.IncludeAuthenticationContext)
+		r.renderFilter(appsBody, "conditions.applications.filter", apps.Filter)
+		body.AppendNewline()
+	}
+
+	// Add client_applications block
+	if clientApps := c.ClientApplications; clientApps != nil {
+		clientAppsBody := r.block(body, "conditions.client_applications")
+		r.setList(clientAppsBody, "conditions.client_applications.included_service_principals", clientApps.IncludeServicePrincipals)
+		r.setList(clientAppsBody, "conditions.client_applications.excluded_service_principals", clientApps.ExcludeServicePrincipals)
+		r.renderFilter(clientAppsBody, "conditions.client_applications.filter", clientApps.Filter)
+		body.AppendNewline()
+	}
+
+	// Add devices block
+	if devices := c.Devices; devices != nil && devices.Filter != nil {
+		devicesBody := r.block(body, "conditions.devices")
+		r.renderFilter(devicesBody, "conditions.devices.filter", devices.Filter)
+		body.AppendNewline()
+	}
+
+	// Add locations block
+	if locations := c.Locations; locations != nil {
+		locationsBody := r.block(body, "conditions.locations")
+		r.setReferences(locationsBody, "conditions.locations.included_locations", namedLocationDataSource, locations.IncludeLocations)
+		r.setReferences(locationsBody, "conditions.locations.excluded_locations", namedLocationDataSource, locations.ExcludeLocations)
+		body.AppendNewline()
+	}
+
+	// Add platforms block
+	if platforms := c.Platforms; platforms != nil {
+		platformsBody := r.block(body, "conditions.platforms")
+		r.setList(platformsBody, "conditions.platforms.included_platforms", platforms.IncludePlatforms)
+		r.setList(platformsBody, "conditions.platforms.excluded_platforms", platforms.ExcludePlatforms)
+		body.AppendNewline()
+	}
+
+	// Add users block
+	users := &c.Users
+	usersBody := r.block(body, "conditions.users")
+	r.setReferences(usersBody, "conditions.users.included_users", userDataSource, users.IncludeUsers)
+	r.setReferences(usersBody, "conditions.users.excluded_users", userDataSource, users.ExcludeUsers)
+	r.setReferences(usersBody, "conditions.users.included_groups", groupDataSource, users.IncludeGroups)
+	r.setReferences(usersBody, "conditions.users.excluded_groups", groupDataSource, users.ExcludeGroups)
+	r.setList(usersBody, "conditions.users.included_roles", users.IncludeRoles)
+	r.setList(usersBody, "conditions.users.excluded_roles", users.ExcludeRoles)
+	r.renderGuests(usersBody, "conditions.users.included_guests_or_external_users", users.IncludeGuests)
+	r.renderGuests(usersBody, "conditions.users.excluded_guests_or_external_users", users.ExcludeGuests)
+}
+
+func (r *policyRenderer) renderFilter(body *hclwrite.Body, path string, filter *caFilter) {
+	if filter == nil {
+		return
+	}
+	filterBody := r.block(body, path)
+	r.setValue(filterBody, path+".mode", cty.StringVal(filter.Mode))
+	r.setValue(filterBody, path+".rule", cty.StringVal(filter.Rule))
+}
+
+func (r *policyRenderer) renderGuests(body *hclwrite.Body, path string, guests *caGuestsOrExternalUsers) {
+	if guests == nil {
+		return
+	}
+	guestsBody := r.block(body, path)
+	r.setList(guestsBody, path+".guest_or_external_user_types", guests.GuestOrExternalUserTypes)
+	if tenants := guests.ExternalTenants; tenants != nil {
+		tenantsBody := r.block(guestsBody, path+".external_tenants")
+		r.setValue(tenantsBody, path+".external_tenants.membership_kind", cty.StringVal(tenants.MembershipKind))
+		r.setList(tenantsBody, path+".external_tenants.members", tenants.Members)
+	}
+}
+
+// block appends the nested block for path. When the schema does not support
+// the block a detached body is returned so rendering can carry on and report
+// every unsupported field at once.
+func (r *policyRenderer) block(body *hclwrite.Body, path string) *hclwrite.Body {
+	name, ok := r.supported(path)
+	if !ok {
+		return hclwrite.NewEmptyFile().Body()
+	}
+	return body.AppendNewBlock(name, nil).Body()
+}
+
+func (r *policyRenderer) setValue(body *hclwrite.Body, path string, value cty.Value) {
+	if name, ok := r.supported(path); ok {
+		body.SetAttributeValue(name, value)
+	}
+}
+
+func (r *policyRenderer) setString(body *hclwrite.Body, path, value string) {
+	if value != "" {
+		r.setValue(body, path, cty.StringVal(value))
+	}
+}
+
+func (r *policyRenderer) setList(body *hclwrite.Body, path string, values []string) {
+	if len(values) == 0 {
+		return
+	}
+	if name, ok := r.supported(path); ok {
+		setIfNotEmpty(body, name, values)
+	}
+}
+
+// setReferences sets path to a list that refers to each resolved object
+// through a data source of the given kind. Keywords and objects that could
+// not be resolved are written as string literals.
+func (r *policyRenderer) setReferences(body *hclwrite.Body, path string, kind dataSourceKind, refs []principalRef) {
+	if len(refs) == 0 {
+		return
+	}
+	name, ok := r.supported(path)
+	if !ok {
+		return
+	}
+
+	var elements []hclwrite.Tokens
+	for _, ref := range refs {
+		if ref.Name == "" {
+			elements = append(elements, hclwrite.TokensForValue(cty.StringVal(ref.ID)))
+			continue
+		}
+		r.dataSources = append(r.dataSources, dataSourceRef{kind: kind, name: ref.Name})
+		elements = append(elements, hclwrite.Tokens{
+			{Type: hclsyntax.TokenIdent, Bytes: []byte(fmt.Sprintf("data.%s.%s.id", kind.dataType, kind.label(ref.Name)))},
+		})
+	}
+	body.SetAttributeRaw(name, listTokens(elements))
+}
+
+// supported returns the schema name for path, recording the path as
+// unsupported if the schema has no name for it. Fields below a block that was
+// already reported are not reported again.
+func (r *policyRenderer) supported(path string) (string, bool) {
+	name, ok := r.schema.name(path)
+	if !ok {
+		for _, reported := range r.unsupported {
+			if strings.HasPrefix(path, reported+".") {
+				return "", false
+			}
+		}
+		r.unsupported = append(r.unsupported, path)
+	}
+	return name, ok
+}
+
+// listTokens joins elements into a tuple expression.
+func listTokens(elements []hclwrite.Tokens) hclwrite.Tokens {
+	tokens := hclwrite.Tokens{{Type: hclsyntax.TokenOBrack, Bytes: []byte{'['}}}
+	for i, element := range elements {
+		tokens = append(tokens, element...)
+		// if not the last element, add a comma
+		if i < len(elements)-1 {
+			tokens = append(tokens, &hclwrite.Token{Type: hclsyntax.TokenComma, Bytes: []byte{','}})
+		}
+	}
+	return append(tokens, &hclwrite.Token{Type: hclsyntax.TokenCBrack, Bytes: []byte{']'}})
+}
+
+func setIfNotEmpty(body *hclwrite.Body, attributeName string, values []string) {
+	if len(values) > 0 {
+		attrList := make([]cty.Value, len(values))
+		for i, v := range values {
+			attrList[i] = cty.StringVal(v)
+		}
+		body.SetAttributeValue(attributeName, cty.ListVal(attrList))
+	}
+}
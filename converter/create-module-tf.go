@@ -0,0 +1,351 @@
+package converter
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// Output modes selecting how policies are written.
+const (
+	// modeResources writes one azuread_conditional_access_policy resource
+	// per policy, placed in files according to the layout.
+	modeResources = "resources"
+	// modeModule writes every policy as an entry of a locals map that a
+	// local module instantiates with for_each.
+	modeModule = "module"
+	// modeYAML writes every policy to a YAML file that the generated
+	// configuration reads with yamldecode and passes to the same module.
+	modeYAML = "yaml"
+)
+
+const (
+	policyModuleDir      = "modules/ca_policy"
+	policyModuleName     = "ca_policies"
+	policyModuleFileName = "ca_policies.tf"
+	policyModuleLocal    = "ca_policies"
+)
+
+// modulePolicy is a policy rendered as an entry of the ca_policies map.
+type modulePolicy struct {
+	key         string
+	id          string
+	displayName string
+	object      hclwrite.Tokens
+	// document is the YAML form of the policy in the yaml mode.
+	document []byte
+}
+
+// moduleInstanceAddress returns the address of the policy resource inside the
+// module instance for key.
+func moduleInstanceAddress(key string) string {
+	return fmt.Sprintf("module.%s[%q].azuread_conditional_access_policy.this", policyModuleName, key)
+}
+
+// add_module_policy renders policy as an entry of the ca_policies map and
+// records the data sources it refers to. The map is written by
+// writeModulePolicies once every policy has been added. Locals are used
+// rather than a tfvars file because entries refer to data sources.
+func add_module_policy(policy models.ConditionalAccessPolicy, g *policyGenerator) error {
+	p, err := newCAPolicy(policy, g.directory)
+	if err != nil {
+		return err
+	}
+
+	rendered := g.promotePolicy(p)
+	f, dataSources, err := renderPolicyFile(rendered, g.label(p), g.schema)
+	if err != nil {
+		return err
+	}
+
+	policyEntry := modulePolicy{
+		key:         g.label(p),
+		id:          rendered.ID,
+		displayName: p.DisplayName,
+	}
+	if g.mode == modeYAML {
+		// references are resolved by name in the generated configuration,
+		// so no data sources are needed
+		if policyEntry.document, err = yamlPolicyDocument(rendered); err != nil {
+			return fmt.Errorf("error converting policy %q to YAML: %v", p.DisplayName, err)
+		}
+	} else {
+		g.data.add(dataSources...)
+		policyEntry.object = bodyObjectTokens(f.Body().Blocks()[0].Body())
+	}
+	g.modulePolicies = append(g.modulePolicies, policyEntry)
+	g.policies = append(g.policies, p)
+	return nil
+}
+
+// writeModulePolicies writes the ca_policy module and ca_policies.tf, which holds
+// the ca_policies map, the module block iterating over it and an import block
+// per policy keyed by its map key. In the yaml mode the map is loaded from the
+// YAML files written alongside instead. Policies renamed since the last run get a
+// moved block between map keys; policies previously generated as plain
+// resources are removed from their old file and moved into the module.
+func (g *policyGenerator) writeModulePolicies(binary string) error {
+	if err := createPolicyModule(g.out, filepath.Join(g.outputDir, policyModuleDir), g.schema, binary, g.values); err != nil {
+		return fmt.Errorf("error creating module: %v", err)
+	}
+
+	sort.Slice(g.modulePolicies, func(i, j int) bool { return g.modulePolicies[i].key < g.modulePolicies[j].key })
+
+	var f *hclwrite.File
+	if g.mode == modeYAML {
+		var err error
+		if f, err = g.writeYAMLPolicies(); err != nil {
+			return err
+		}
+	} else {
+		f = hclwrite.NewEmptyFile()
+		var entries []hclwrite.Tokens
+		for _, policy := range g.modulePolicies {
+			entry := hclwrite.TokensForValue(cty.StringVal(policy.key))
+			entry = append(entry, &hclwrite.Token{Type: hclsyntax.TokenEqual, Bytes: []byte{'='}})
+			entries = append(entries, append(entry, policy.object...))
+		}
+		localsBlock := f.Body().AppendNewBlock("locals", nil)
+		localsBlock.Body().SetAttributeRaw(policyModuleLocal, objectTokens(entries))
+	}
+	rootBody := f.Body()
+	rootBody.AppendNewline()
+
+	moduleBlock := rootBody.AppendNewBlock("module", []string{policyModuleName})
+	moduleBody := moduleBlock.Body()
+	moduleBody.SetAttributeValue("source", cty.StringVal("./"+policyModuleDir))
+	moduleBody.SetAttributeTraversal("for_each", hcl.Traversal{
+		hcl.TraverseRoot{Name: "local"},
+		hcl.TraverseAttr{Name: policyModuleLocal},
+	})
+	moduleBody.AppendNewline()
+	moduleBody.SetAttributeTraversal("policy", hcl.Traversal{
+		hcl.TraverseRoot{Name: "each"},
+		hcl.TraverseAttr{Name: "value"},
+	})
+
+	imports := map[string]bool{}
+	for _, policy := range g.modulePolicies {
+		if policy.id == "" {
+			continue
+		}
+		address := moduleInstanceAddress(policy.key)
+		imports[address] = true
+		rootBody.AppendNewline()
+		importBlock := rootBody.AppendNewBlock("import", nil)
+		importBlock.Body().SetAttributeRaw("to", rawTokens(address))
+		importBlock.Body().SetAttributeValue("id", cty.StringVal(policy.id))
+
+		if err := g.moveIntoModule(rootBody, policy); err != nil {
+			return err
+		}
+		g.manifest.Policies[policy.id] = manifestEntry{DisplayName: policy.displayName, Label: policy.key, File: policyModuleFileName}
+	}
+
+	path := filepath.Join(g.outputDir, policyModuleFileName)
+	if err := removeStaleModuleImports(g.out, path, imports); err != nil {
+		return err
+	}
+	// each policy in the map is merged on its own, so a hand edit to one
+	// entry does not hold back the tenant changes of the others
+	merger := &bodyMerger{
+		known:   func(path string) bool { return true },
+		entries: func(path string) bool { return path == policyModuleLocal },
+	}
+	change, err := merger.writeFile(g.out, path, f, g.values)
+	if err != nil {
+		return err
+	}
+	g.warnSkipped(path, merger.skipped)
+	if change != fileUnchanged {
+		fmt.Fprintf(g.log, "%s terraform file for %d policies: %s\n", change, len(g.modulePolicies), path)
+	}
+	return nil
+}
+
+// moveIntoModule adds the moved block for a policy whose map key changed
+// since the last run, or whose resource was generated outside the module.
+func (g *policyGenerator) moveIntoModule(body *hclwrite.Body, policy modulePolicy) error {
+	previous, ok := g.manifest.Policies[policy.id]
+	if !ok {
+		return nil
+	}
+
+	var from string
+	switch {
+	case previous.File != policyModuleFileName:
+		if err := removePolicyResource(g.out, filepath.Join(g.outputDir, previous.File), previous.resourceType(), previous.Label); err != nil {
+			return fmt.Errorf("error moving policy %q into the module: %v", policy.displayName, err)
+		}
+		if previous.resourceType() != azureADPolicyResourceType {
+			// the import block adopts the policy into the module
+			body.AppendNewline()
+			appendRemovedBlock(body, previous.resourceType(), previous.Label)
+			return nil
+		}
+		from = azureADPolicyResourceType + "." + previous.Label
+	case previous.Label != policy.key:
+		from = moduleInstanceAddress(previous.Label)
+	default:
+		return nil
+	}
+
+	body.AppendNewline()
+	movedBlock := body.AppendNewBlock("moved", nil)
+	movedBlock.Body().SetAttributeRaw("from", rawTokens(from))
+	movedBlock.Body().SetAttributeRaw("to", rawTokens(moduleInstanceAddress(policy.key)))
+	fmt.Fprintf(g.log, "Moved policy %s -> %s\n", from, moduleInstanceAddress(policy.key))
+	return nil
+}
+
+// removeStaleModuleImports drops import blocks into the module for map keys
+// that no longer exist, which Terraform would otherwise reject.
+func removeStaleModuleImports(out OutputWriter, path string, imports map[string]bool) error {
+	src, err := out.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	f, diags := hclwrite.ParseConfig(src, path, hcl.InitialPos)
+	if diags.HasErrors() {
+		return fmt.Errorf("error parsing %s: %s", path, diags.Error())
+	}
+
+	prefix := fmt.Sprintf("module.%s[", policyModuleName)
+	removed := false
+	for _, block := range f.Body().Blocks() {
+		to := expressionText(block.Body().GetAttribute("to"))
+		if block.Type() == "import" && strings.HasPrefix(to, prefix) && !imports[to] {
+			f.Body().RemoveBlock(block)
+			removed = true
+		}
+	}
+	if !removed {
+		return nil
+	}
+	return out.WriteFile(path, formatHCL(f.Bytes()))
+}
+
+// createPolicyModule writes the ca_policy module to dir. The resource is
+// generated from the provider schema, so it accepts every field the target
+// provider version supports: blocks become dynamic blocks that are only
+// present when the policy object has them and is not null, and attributes
+// default to null.
+func createPolicyModule(out OutputWriter, dir string, schema *providerSchema, binary string, values *attributeValues) error {
+	f := hclwrite.NewEmptyFile()
+	rootBody := f.Body()
+
+	variableBlock := rootBody.AppendNewBlock("variable", []string{"policy"})
+	variableBody := variableBlock.Body()
+	variableBody.SetAttributeValue("description", cty.StringVal("Conditional access policy, using the attribute and block names of azuread_conditional_access_policy."))
+	variableBody.SetAttributeRaw("type", rawTokens("any"))
+	rootBody.AppendNewline()
+
+	resourceBlock := rootBody.AppendNewBlock("resource", []string{"azuread_conditional_access_policy", "this"})
+	renderModuleBody(resourceBlock.Body(), schema, "", "var.policy")
+	rootBody.AppendNewline()
+
+	outputBlock := rootBody.AppendNewBlock("output", []string{"id"})
+	outputBlock.Body().SetAttributeRaw("value", rawTokens("azuread_conditional_access_policy.this.id"))
+
+	owned := func(path string) bool { return true }
+	if _, err := writeMergedFile(out, filepath.Join(dir, "main.tf"), f, owned, values); err != nil {
+		return err
+	}
+
+	versions := hclwrite.NewEmptyFile()
+	terraformBlock := versions.Body().AppendNewBlock("terraform", nil)
+	requiredProviders := terraformBlock.Body().AppendNewBlock("required_providers", nil)
+	requiredProviders.Body().SetAttributeValue("azuread", cty.ObjectVal(map[string]cty.Value{
+		"source": cty.StringVal(providerSource(binary, "hashicorp/azuread")),
+	}))
+	_, err := writeMergedFile(out, filepath.Join(dir, versionsFileName), versions, owned, values)
+	return err
+}
+
+// renderModuleBody writes the attributes and blocks the schema has directly
+// below prefix, reading their values from source.
+func renderModuleBody(body *hclwrite.Body, schema *providerSchema, prefix, source string) {
+	var attributes, blocks []string
+	for path := range schema.names {
+		if !strings.HasPrefix(path, prefix) || strings.Contains(strings.TrimPrefix(path, prefix), ".") {
+			continue
+		}
+		if schema.hasChildren(path) {
+			blocks = append(blocks, path)
+		} else {
+			attributes = append(attributes, path)
+		}
+	}
+	sort.Strings(attributes)
+	sort.Strings(blocks)
+
+	for _, path := range attributes {
+		name, _ := schema.name(path)
+		body.SetAttributeRaw(name, rawTokens(fmt.Sprintf("try(%s.%s, null)", source, name)))
+	}
+	for i, path := range blocks {
+		name, _ := schema.name(path)
+		if i > 0 || len(attributes) > 0 {
+			body.AppendNewline()
+		}
+		dynamicBlock := body.AppendNewBlock("dynamic", []string{name})
+		dynamicBlock.Body().SetAttributeRaw("for_each", rawTokens(fmt.Sprintf("[for value in [try(%s.%s, null)] : value if value != null]", source, name)))
+		dynamicBlock.Body().AppendNewline()
+		contentBlock := dynamicBlock.Body().AppendNewBlock("content", nil)
+		renderModuleBody(contentBlock.Body(), schema, path+".", name+".value")
+	}
+}
+
+// bodyObjectTokens converts a rendered resource body into an object
+// expression, with nested blocks as nested objects.
+func bodyObjectTokens(body *hclwrite.Body) hclwrite.Tokens {
+	var entries []hclwrite.Tokens
+	attributes := body.Attributes()
+	for _, name := range attributeOrder(body) {
+		entry := hclwrite.Tokens{
+			{Type: hclsyntax.TokenIdent, Bytes: []byte(name)},
+			{Type: hclsyntax.TokenEqual, Bytes: []byte{'='}},
+		}
+		entries = append(entries, append(entry, attributes[name].Expr().BuildTokens(nil)...))
+	}
+	for _, block := range body.Blocks() {
+		entry := hclwrite.Tokens{
+			{Type: hclsyntax.TokenIdent, Bytes: []byte(block.Type())},
+			{Type: hclsyntax.TokenEqual, Bytes: []byte{'='}},
+		}
+		entries = append(entries, append(entry, bodyObjectTokens(block.Body())...))
+	}
+	return objectTokens(entries)
+}
+
+// objectTokens joins "key = value" entries into an object expression with one
+// entry per line.
+func objectTokens(entries []hclwrite.Tokens) hclwrite.Tokens {
+	tokens := hclwrite.Tokens{
+		{Type: hclsyntax.TokenOBrace, Bytes: []byte{'{'}},
+		{Type: hclsyntax.TokenNewline, Bytes: []byte{'\n'}},
+	}
+	for _, entry := range entries {
+		tokens = append(tokens, entry...)
+		tokens = append(tokens, &hclwrite.Token{Type: hclsyntax.TokenNewline, Bytes: []byte{'\n'}})
+	}
+	return append(tokens, &hclwrite.Token{Type: hclsyntax.TokenCBrace, Bytes: []byte{'}'}})
+}
+
+// rawTokens returns expr as a single token, for expressions hclwrite has no
+// builder for.
+func rawTokens(expr string) hclwrite.Tokens {
+	return hclwrite.Tokens{{Type: hclsyntax.TokenIdent, Bytes: []byte(expr)}}
+}
@@ -0,0 +1,156 @@
+package converter
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	msgraphsdk "github.com/microsoftgraph/msgraph-sdk-go"
+	"github.com/zclconf/go-cty/cty"
+)
+
+const (
+	versionsFileName = "versions.tf"
+	providerFileName = "provider.tf"
+)
+
+// backendSetting is a single attribute of a backend template. Settings
+// without a default must be supplied in the config.
+type backendSetting struct {
+	name         string
+	defaultValue string
+}
+
+// backendTemplates lists the attributes written for each supported backend
+// type, in the order they appear in the generated block.
+var backendTemplates = map[string][]backendSetting{
+	"azurerm": {
+		{name: "resource_group_name"},
+		{name: "storage_account_name"},
+		{name: "container_name"},
+		{name: "key", defaultValue: "conditional-access.tfstate"},
+	},
+	"local": {
+		{name: "path", defaultValue: "terraform.tfstate"},
+	},
+	"s3": {
+		{name: "bucket"},
+		{name: "key", defaultValue: "conditional-access.tfstate"},
+		{name: "region"},
+	},
+}
+
+// get_tenant_id returns the ID of the tenant the client is signed in to.
+func get_tenant_id(ctx context.Context, client *msgraphsdk.GraphServiceClient) (string, error) {
+	result, err := client.Organization().Get(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("error getting organization: %v", err)
+	}
+
+	organizations := result.GetValue()
+	if len(organizations) == 0 || organizations[0].GetId() == nil {
+		return "", fmt.Errorf("no organization returned for the signed in tenant")
+	}
+
+	return *organizations[0].GetId(), nil
+}
+
+// createVersionsFile writes versions.tf with the required providers for the
+// given CLI and the optional backend block.
+func createVersionsFile(out OutputWriter, cfg *Config, binary string, values *attributeValues) error {
+	f := hclwrite.NewEmptyFile()
+	rootBody := f.Body()
+
+	terraformBlock := rootBody.AppendNewBlock("terraform", nil)
+	terraformBody := terraformBlock.Body()
+
+	requiredProviders := terraformBody.AppendNewBlock("required_providers", nil)
+	requiredProviders.Body().SetAttributeValue("azuread", cty.ObjectVal(map[string]cty.Value{
+		"source":  cty.StringVal(providerSource(binary, "hashicorp/azuread")),
+		"version": cty.StringVal(cfg.ProviderVersion),
+	}))
+	if cfg.PolicyResource != policyResourceAzureAD {
+		requiredProviders.Body().SetAttributeValue("msgraph", cty.ObjectVal(map[string]cty.Value{
+			"source":  cty.StringVal(providerSource(binary, "microsoft/msgraph")),
+			"version": cty.StringVal(cfg.MSGraphProviderVersion),
+		}))
+	}
+
+	if cfg.Backend != nil {
+		terraformBody.AppendNewline()
+		if err := appendBackendBlock(terraformBody, cfg.Backend); err != nil {
+			return err
+		}
+	}
+
+	_, err := writeMergedFile(out, filepath.Join(cfg.OutputDir, versionsFileName), f, isVersionsPath, values)
+	return err
+}
+
+// isVersionsPath reports whether path is a block or attribute inside the
+// terraform block that createVersionsFile manages.
+func isVersionsPath(path string) bool {
+	return path == "required_providers.azuread" || path == "required_providers.msgraph" || path == "backend"
+}
+
+// appendBackendBlock renders the backend template selected by backend.Type,
+// filling in values from backend.Settings. Settings not in the template are
+// passed through in alphabetical order.
+func appendBackendBlock(body *hclwrite.Body, backend *BackendConfig) error {
+	template, ok := backendTemplates[backend.Type]
+	if !ok {
+		return fmt.Errorf("unsupported backend type %q", backend.Type)
+	}
+
+	backendBlock := body.AppendNewBlock("backend", []string{backend.Type})
+	backendBody := backendBlock.Body()
+
+	known := make(map[string]bool, len(template))
+	for _, setting := range template {
+		known[setting.name] = true
+		value, ok := backend.Settings[setting.name]
+		if !ok {
+			value = setting.defaultValue
+		}
+		if value == "" {
+			return fmt.Errorf("backend %q requires setting %q", backend.Type, setting.name)
+		}
+		backendBody.SetAttributeValue(setting.name, cty.StringVal(value))
+	}
+
+	var extra []string
+	for name := range backend.Settings {
+		if !known[name] {
+			extra = append(extra, name)
+		}
+	}
+	sort.Strings(extra)
+	for _, name := range extra {
+		backendBody.SetAttributeValue(name, cty.StringVal(backend.Settings[name]))
+	}
+
+	return nil
+}
+
+// createProviderFile writes provider.tf in the output directory, configuring
+// the azuread provider, and the msgraph provider when policies may be written
+// as msgraph_resource, for the given tenant.
+func createProviderFile(out OutputWriter, cfg *Config, tenantID string, values *attributeValues) error {
+	f := hclwrite.NewEmptyFile()
+	rootBody := f.Body()
+
+	providerBlock := rootBody.AppendNewBlock("provider", []string{"azuread"})
+	providerBlock.Body().SetAttributeValue("tenant_id", cty.StringVal(tenantID))
+	if cfg.PolicyResource != policyResourceAzureAD {
+		rootBody.AppendNewline()
+		msgraphBlock := rootBody.AppendNewBlock("provider", []string{"msgraph"})
+		msgraphBlock.Body().SetAttributeValue("tenant_id", cty.StringVal(tenantID))
+	}
+
+	_, err := writeMergedFile(out, filepath.Join(cfg.OutputDir, providerFileName), f, func(path string) bool {
+		return path == "tenant_id"
+	}, values)
+	return err
+}
@@ -0,0 +1,306 @@
+package converter
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+)
+
+// driftReport lists the differences between the checked-in configuration and
+// the live tenant.
+type driftReport struct {
+	// Added are policies in the tenant without a resource in the code.
+	Added []string `json:"added"`
+	// Deleted are resources in the code whose policy is gone from the tenant.
+	Deleted []string `json:"deleted"`
+	// Changed are policies present in both with differing attributes.
+	Changed []policyDrift `json:"changed"`
+	// Errors are policies that could not be compared.
+	Errors []string `json:"errors,omitempty"`
+}
+
+type policyDrift struct {
+	Policy      string          `json:"policy"`
+	Address     string          `json:"address"`
+	Differences []attributeDiff `json:"differences"`
+}
+
+type attributeDiff struct {
+	Path   string `json:"path"`
+	Code   string `json:"code"`
+	Tenant string `json:"tenant"`
+}
+
+func (r *driftReport) hasDrift() bool {
+	return len(r.Added) > 0 || len(r.Deleted) > 0 || len(r.Changed) > 0
+}
+
+// RunDrift compares the configuration in the output directory with the
+// tenant and returns the process exit code: 0 without drift, 2 when drift was
+// found and 1 on errors.
+func RunDrift(args []string) int {
+	ctx := context.Background()
+
+	fs := flag.NewFlagSet("drift", flag.ExitOnError)
+	common := addCommonFlags(fs)
+	dir := fs.String("dir", "", "directory containing the Terraform configuration (default: the configured output directory)")
+	format := fs.String("format", "text", "output format: text or json")
+	fs.Parse(args)
+
+	cfg, schema, err := common.load()
+	if err != nil {
+		log.Printf("error loading config: %v", err)
+		return 1
+	}
+	if *dir == "" {
+		*dir = cfg.OutputDir
+	}
+
+	existing, err := readExistingConfig(DiskWriter{}, *dir)
+	if err != nil {
+		log.Printf("error reading configuration: %v", err)
+		return 1
+	}
+
+	graphClient, err := NewGraphClient(ctx, cfg.GraphEndpoint)
+	if err != nil {
+		log.Printf("error configuring Graph client: %v", err)
+		return 1
+	}
+	policies, err := getExistingPolicies(ctx, graphClient)
+	if err != nil {
+		log.Printf("error getting existing policies: %v", err)
+		return 1
+	}
+
+	report := detectDrift(existing, policies, newDirectoryCache(ctx, &GraphSource{Client: graphClient}), schema)
+
+	switch *format {
+	case "json":
+		err = writeDriftJSON(os.Stdout, report)
+	case "text":
+		writeDriftText(os.Stdout, report)
+	default:
+		log.Printf("unknown format %q, expected text or json", *format)
+		return 1
+	}
+	if err != nil {
+		log.Printf("error writing report: %v", err)
+		return 1
+	}
+
+	if len(report.Errors) > 0 {
+		return 1
+	}
+	if report.hasDrift() {
+		return 2
+	}
+	return 0
+}
+
+// detectDrift matches resources to live policies by the ID in their import
+// block, falling back to the display name, and
+// compares every attribute the generator manages. The live side is rendered
+// with the same generator, so both sides use the same names and structure.
+func detectDrift(existing *existingConfig, policies []models.ConditionalAccessPolicy, directory *directoryCache, schema *providerSchema) *driftReport {
+	report := &driftReport{Added: []string{}, Deleted: []string{}, Changed: []policyDrift{}}
+
+	byID, byName := map[string]existingResource{}, map[string]existingResource{}
+	for _, resource := range existing.resources {
+		if resource.id != "" {
+			byID[resource.id] = resource
+		}
+		byName[resource.displayName] = resource
+	}
+
+	matched := map[string]bool{}
+	for _, policy := range policies {
+		p, err := newCAPolicy(policy, directory)
+		if err != nil {
+			report.Errors = append(report.Errors, err.Error())
+			continue
+		}
+
+		resource, ok := byID[p.ID]
+		if !ok {
+			resource, ok = byName[p.DisplayName]
+		}
+		if !ok {
+			report.Added = append(report.Added, p.DisplayName)
+			continue
+		}
+		matched[resource.address()] = true
+
+		live, liveDataSources, err := livePolicyBody(p, &policy, resource, schema)
+		if err != nil {
+			report.Errors = append(report.Errors, err.Error())
+			continue
+		}
+
+		known := schema.knows
+		if resource.resourceType == msgraphResourceType {
+			known = isMSGraphResourcePath
+		}
+		differ := &bodyDiffer{known: known, codeData: existing.dataSources, liveData: liveDataSources}
+		differ.diff(resource.body, live, "")
+		if len(differ.diffs) > 0 {
+			report.Changed = append(report.Changed, policyDrift{
+				Policy:      p.DisplayName,
+				Address:     resource.address(),
+				Differences: differ.diffs,
+			})
+		}
+	}
+
+	for _, resource := range existing.resources {
+		if !matched[resource.address()] {
+			report.Deleted = append(report.Deleted, resource.displayName)
+		}
+	}
+
+	sort.Strings(report.Added)
+	sort.Strings(report.Deleted)
+	sort.Slice(report.Changed, func(i, j int) bool { return report.Changed[i].Policy < report.Changed[j].Policy })
+	return report
+}
+
+// livePolicyBody renders p the way resource holds it in the code: as an
+// azuread or msgraph_resource resource body, or as a YAML document. It also
+// returns the data sources the body refers to.
+func livePolicyBody(p *caPolicy, policy *models.ConditionalAccessPolicy, resource existingResource, schema *providerSchema) (*hclwrite.Body, map[string]string, error) {
+	if resource.isDocument() {
+		src, err := yamlPolicyDocument(p)
+		if err != nil {
+			return nil, nil, err
+		}
+		body, err := documentBody(src)
+		return body, nil, err
+	}
+
+	render := func() (*hclwrite.File, []dataSourceRef, error) {
+		return renderPolicyFile(p, policyResourceName(p.DisplayName), schema)
+	}
+	if resource.resourceType == msgraphResourceType {
+		render = func() (*hclwrite.File, []dataSourceRef, error) {
+			return renderMSGraphPolicyFile(p, policyResourceName(p.DisplayName), policy)
+		}
+	}
+	f, dataSources, err := render()
+	if err != nil {
+		return nil, nil, err
+	}
+	liveDataSources := map[string]string{}
+	for _, ref := range dataSources {
+		liveDataSources[ref.kind.dataType+"."+ref.kind.label(ref.name)] = ref.name
+	}
+	return f.Body().Blocks()[0].Body(), liveDataSources, nil
+}
+
+// bodyDiffer compares a resource body from the code with the body rendered
+// from the tenant, only looking at attributes and blocks the generator owns.
+type bodyDiffer struct {
+	known    func(path string) bool
+	codeData map[string]string
+	liveData map[string]string
+	diffs    []attributeDiff
+}
+
+const unsetValue = "(unset)"
+
+func (d *bodyDiffer) diff(code, live *hclwrite.Body, prefix string) {
+	codeAttributes, liveAttributes := code.Attributes(), live.Attributes()
+	for _, name := range unionNames(codeAttributes, liveAttributes) {
+		path := prefix + name
+		if !d.known(path) {
+			continue
+		}
+		codeValue, liveValue := unsetValue, unsetValue
+		if attribute, ok := codeAttributes[name]; ok {
+			codeValue = normalizedExpr(attribute.Expr().BuildTokens(nil), d.codeData)
+		}
+		if attribute, ok := liveAttributes[name]; ok {
+			liveValue = normalizedExpr(attribute.Expr().BuildTokens(nil), d.liveData)
+		}
+		if codeValue != liveValue {
+			d.diffs = append(d.diffs, attributeDiff{Path: path, Code: codeValue, Tenant: liveValue})
+		}
+	}
+
+	codeBlocks, liveBlocks := blocksByType(code), blocksByType(live)
+	for _, name := range unionNames(codeBlocks, liveBlocks) {
+		path := prefix + name
+		if !d.known(path) {
+			continue
+		}
+		codeBody, liveBody := hclwrite.NewEmptyFile().Body(), hclwrite.NewEmptyFile().Body()
+		if block, ok := codeBlocks[name]; ok {
+			codeBody = block.Body()
+		}
+		if block, ok := liveBlocks[name]; ok {
+			liveBody = block.Body()
+		}
+		d.diff(codeBody, liveBody, path+".")
+	}
+}
+
+func blocksByType(body *hclwrite.Body) map[string]*hclwrite.Block {
+	blocks := map[string]*hclwrite.Block{}
+	for _, block := range body.Blocks() {
+		if _, ok := blocks[block.Type()]; !ok {
+			blocks[block.Type()] = block
+		}
+	}
+	return blocks
+}
+
+// unionNames returns the sorted keys present in either map.
+func unionNames[V any](a, b map[string]V) []string {
+	seen := map[string]bool{}
+	var names []string
+	for _, m := range []map[string]V{a, b} {
+		for name := range m {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func writeDriftText(w io.Writer, report *driftReport) {
+	if !report.hasDrift() && len(report.Errors) == 0 {
+		fmt.Fprintln(w, "No drift detected.")
+		return
+	}
+	for _, name := range report.Added {
+		fmt.Fprintf(w, "+ %s (added in the tenant)\n", name)
+	}
+	for _, name := range report.Deleted {
+		fmt.Fprintf(w, "- %s (deleted from the tenant)\n", name)
+	}
+	for _, changed := range report.Changed {
+		fmt.Fprintf(w, "~ %s (%s)\n", changed.Policy, changed.Address)
+		for _, diff := range changed.Differences {
+			fmt.Fprintf(w, "    %s\n      - code:   %s\n      + tenant: %s\n", diff.Path, diff.Code, diff.Tenant)
+		}
+	}
+	for _, message := range report.Errors {
+		fmt.Fprintf(w, "! %s\n", message)
+	}
+}
+
+func writeDriftJSON(w io.Writer, report *driftReport) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
@@ -0,0 +1,224 @@
+package converter
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// deviceFilter is a parsed device filter rule, such as
+// `device.trustType -eq "AzureAD" -and device.isCompliant -eq True`. It
+// supports the comparison operators of the filter syntax combined with -and,
+// -or and parentheses.
+type deviceFilter interface {
+	matches(device map[string]string) bool
+}
+
+type filterAnd struct{ left, right deviceFilter }
+
+type filterOr struct{ left, right deviceFilter }
+
+// filterComparison compares a device property with one or more values.
+// Properties and values are compared without regard to case.
+type filterComparison struct {
+	property string
+	operator string
+	values   []string
+}
+
+func (f filterAnd) matches(device map[string]string) bool {
+	return f.left.matches(device) && f.right.matches(device)
+}
+
+func (f filterOr) matches(device map[string]string) bool {
+	return f.left.matches(device) || f.right.matches(device)
+}
+
+func (f filterComparison) matches(device map[string]string) bool {
+	var actual string
+	for property, value := range device {
+		if strings.EqualFold(property, f.property) {
+			actual = strings.ToLower(value)
+		}
+	}
+	expected := strings.ToLower(f.values[0])
+	switch f.operator {
+	case "-eq":
+		return actual == expected
+	case "-ne":
+		return actual != expected
+	case "-startswith":
+		return strings.HasPrefix(actual, expected)
+	case "-notstartswith":
+		return !strings.HasPrefix(actual, expected)
+	case "-endswith":
+		return strings.HasSuffix(actual, expected)
+	case "-notendswith":
+		return !strings.HasSuffix(actual, expected)
+	case "-contains":
+		return strings.Contains(actual, expected)
+	case "-notcontains":
+		return !strings.Contains(actual, expected)
+	case "-in", "-notin":
+		found := false
+		for _, value := range f.values {
+			found = found || actual == strings.ToLower(value)
+		}
+		return found == (f.operator == "-in")
+	}
+	return false
+}
+
+// filterOperators are the comparison operators parseDeviceFilter accepts, in
+// lower case.
+var filterOperators = map[string]bool{
+	"-eq": true, "-ne": true,
+	"-startswith": true, "-notstartswith": true,
+	"-endswith": true, "-notendswith": true,
+	"-contains": true, "-notcontains": true,
+	"-in": true, "-notin": true,
+}
+
+// parseDeviceFilter parses a device filter rule.
+func parseDeviceFilter(rule string) (deviceFilter, error) {
+	tokens, err := filterTokens(rule)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{tokens: tokens}
+	filter, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in device filter", p.tokens[p.pos])
+	}
+	return filter, nil
+}
+
+type filterParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *filterParser) next() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	token := p.tokens[p.pos]
+	p.pos++
+	return token
+}
+
+func (p *filterParser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) or() (deviceFilter, error) {
+	left, err := p.and()
+	for err == nil && strings.EqualFold(p.peek(), "-or") {
+		p.next()
+		var right deviceFilter
+		if right, err = p.and(); err == nil {
+			left = filterOr{left, right}
+		}
+	}
+	return left, err
+}
+
+func (p *filterParser) and() (deviceFilter, error) {
+	left, err := p.comparison()
+	for err == nil && strings.EqualFold(p.peek(), "-and") {
+		p.next()
+		var right deviceFilter
+		if right, err = p.comparison(); err == nil {
+			left = filterAnd{left, right}
+		}
+	}
+	return left, err
+}
+
+func (p *filterParser) comparison() (deviceFilter, error) {
+	if p.peek() == "(" {
+		p.next()
+		filter, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("missing ) in device filter")
+		}
+		return filter, nil
+	}
+
+	property := p.next()
+	if !strings.HasPrefix(strings.ToLower(property), "device.") {
+		return nil, fmt.Errorf("expected a device property in device filter, found %q", property)
+	}
+	operator := strings.ToLower(p.next())
+	if !filterOperators[operator] {
+		return nil, fmt.Errorf("unsupported operator %q in device filter", operator)
+	}
+
+	var values []string
+	if p.peek() == "[" {
+		p.next()
+		for p.peek() != "]" {
+			value := p.next()
+			if value == "" {
+				return nil, fmt.Errorf("missing ] in device filter")
+			}
+			if value != "," {
+				values = append(values, strings.Trim(value, `"`))
+			}
+		}
+		p.next()
+	} else {
+		value := p.next()
+		if value == "" {
+			return nil, fmt.Errorf("missing value for %s in device filter", property)
+		}
+		values = []string{strings.Trim(value, `"`)}
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("missing value for %s in device filter", property)
+	}
+	return filterComparison{property: strings.TrimPrefix(strings.ToLower(property), "device."), operator: operator, values: values}, nil
+}
+
+// filterTokens splits a rule into parentheses, brackets, commas, quoted
+// strings and words.
+func filterTokens(rule string) ([]string, error) {
+	var tokens []string
+	runes := []rune(rule)
+	for i := 0; i < len(runes); {
+		switch r := runes[i]; {
+		case unicode.IsSpace(r):
+			i++
+		case strings.ContainsRune("()[],", r):
+			tokens = append(tokens, string(r))
+			i++
+		case r == '"':
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			if end == len(runes) {
+				return nil, fmt.Errorf("unterminated string in device filter")
+			}
+			tokens = append(tokens, string(runes[i:end+1]))
+			i = end + 1
+		default:
+			end := i
+			for end < len(runes) && !unicode.IsSpace(runes[end]) && !strings.ContainsRune("()[],\"", runes[end]) {
+				end++
+			}
+			tokens = append(tokens, string(runes[i:end]))
+			i = end
+		}
+	}
+	return tokens, nil
+}
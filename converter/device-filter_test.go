@@ -0,0 +1,72 @@
+package converter
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseDeviceFilter(t *testing.T) {
+	compliant := map[string]string{"trustType": "AzureAD", "isCompliant": "True", "operatingSystem": "Windows"}
+	hybrid := map[string]string{"trustType": "ServerAD", "isCompliant": "False", "operatingSystem": "Windows"}
+	personal := map[string]string{"trustType": "Workplace", "isCompliant": "False", "operatingSystem": "iOS"}
+	for _, tc := range []struct {
+		rule string
+		// matches are the devices, by name, the filter matches
+		matches []string
+	}{
+		{`device.trustType -eq "AzureAD"`, []string{"compliant"}},
+		{`device.TRUSTTYPE -EQ "azuread"`, []string{"compliant"}},
+		{`device.trustType -ne "AzureAD"`, []string{"hybrid", "personal"}},
+		{`device.operatingSystem -startsWith "win"`, []string{"compliant", "hybrid"}},
+		{`device.operatingSystem -notEndsWith "OS"`, []string{"compliant", "hybrid"}},
+		{`device.trustType -contains "AD"`, []string{"compliant", "hybrid"}},
+		{`device.trustType -in ["AzureAD", "ServerAD"]`, []string{"compliant", "hybrid"}},
+		{`device.trustType -notIn ["AzureAD","ServerAD"]`, []string{"personal"}},
+		{`device.model -eq ""`, []string{"compliant", "hybrid", "personal"}},
+		// -and binds tighter than -or
+		{`device.trustType -eq "Workplace" -or device.trustType -eq "ServerAD" -and device.isCompliant -eq True`, []string{"personal"}},
+		{`device.isCompliant -eq True -and device.trustType -eq "AzureAD" -or device.operatingSystem -eq "iOS"`, []string{"compliant", "personal"}},
+		{`(device.trustType -eq "Workplace" -or device.trustType -eq "ServerAD") -and device.isCompliant -eq False`, []string{"hybrid", "personal"}},
+	} {
+		t.Run(tc.rule, func(t *testing.T) {
+			filter, err := parseDeviceFilter(tc.rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var matches []string
+			for _, device := range []struct {
+				name       string
+				properties map[string]string
+			}{{"compliant", compliant}, {"hybrid", hybrid}, {"personal", personal}} {
+				if filter.matches(device.properties) {
+					matches = append(matches, device.name)
+				}
+			}
+			if strings.Join(matches, ",") != strings.Join(tc.matches, ",") {
+				t.Errorf("matches %v, want %v", matches, tc.matches)
+			}
+		})
+	}
+}
+
+func TestParseDeviceFilterErrors(t *testing.T) {
+	for _, tc := range []struct{ rule, err string }{
+		{``, `expected a device property in device filter, found ""`},
+		{`trustType -eq "AzureAD"`, `expected a device property`},
+		{`device.trustType -like "AzureAD"`, `unsupported operator "-like"`},
+		{`device.trustType -eq`, `missing value for device.trustType`},
+		{`device.trustType -in []`, `missing value for device.trustType`},
+		{`device.trustType -in ["AzureAD"`, `missing ]`},
+		{`(device.trustType -eq "AzureAD"`, `missing )`},
+		{`device.trustType -eq "AzureAD`, `unterminated string`},
+		{`device.trustType -eq "AzureAD" device.isCompliant -eq True`, `unexpected "device.isCompliant"`},
+		{`device.trustType -eq "AzureAD" -and`, `expected a device property`},
+	} {
+		t.Run(tc.rule, func(t *testing.T) {
+			_, err := parseDeviceFilter(tc.rule)
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("error = %v, want %q", err, tc.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package converter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+)
+
+// DirectoryResolver resolves the object IDs policies refer to into the names
+// the generated configuration looks them up by. Each lookup is made with
+// the context of the command it is made for.
+type DirectoryResolver interface {
+	// UserPrincipalName returns the user principal name of the user with
+	// the given ID.
+	UserPrincipalName(ctx context.Context, id string) (string, error)
+	// GroupDisplayName returns the display name of the group with the given
+	// ID.
+	GroupDisplayName(ctx context.Context, id string) (string, error)
+	// NamedLocationDisplayName returns the display name of the named
+	// location with the given ID.
+	NamedLocationDisplayName(ctx context.Context, id string) (string, error)
+	// NamedLocation returns the definition of the named location with the
+	// given ID.
+	NamedLocation(ctx context.Context, id string) (models.NamedLocationable, error)
+	// DirectoryRoleNames returns the display names of the directory role
+	// templates by ID.
+	DirectoryRoleNames(ctx context.Context) (map[string]string, error)
+}
+
+// directoryCache resolves object IDs referenced by policies to names, caching
+// each lookup so an object shared by many policies is only fetched once.
+// It lives for one command, whose context it makes the lookups with.
+type directoryCache struct {
+	ctx       context.Context
+	resolver  DirectoryResolver
+	users     map[string]string
+	groups    map[string]string
+	locations map[string]string
+	roles     map[string]string
+
+	// log receives the warnings about objects that could not be resolved,
+	// standard error unless the caller sets it.
+	log io.Writer
+}
+
+func newDirectoryCache(ctx context.Context, resolver DirectoryResolver) *directoryCache {
+	return &directoryCache{
+		ctx:       ctx,
+		resolver:  resolver,
+		users:     map[string]string{},
+		groups:    map[string]string{},
+		locations: map[string]string{},
+		log:       os.Stderr,
+	}
+}
+
+// userName returns the user principal name of the user with the given ID.
+func (d *directoryCache) userName(id string) (string, error) {
+	return d.cachedLookup(d.users, id, func(id string) (string, error) {
+		return d.resolver.UserPrincipalName(d.ctx, id)
+	})
+}
+
+// groupName returns the display name of the group with the given ID.
+func (d *directoryCache) groupName(id string) (string, error) {
+	return d.cachedLookup(d.groups, id, func(id string) (string, error) {
+		return d.resolver.GroupDisplayName(d.ctx, id)
+	})
+}
+
+// namedLocationName returns the display name of the named location with the
+// given ID.
+func (d *directoryCache) namedLocationName(id string) (string, error) {
+	return d.cachedLookup(d.locations, id, func(id string) (string, error) {
+		return d.resolver.NamedLocationDisplayName(d.ctx, id)
+	})
+}
+
+// directoryRoles returns the display names of the directory role templates
+// by ID, fetching them once.
+func (d *directoryCache) directoryRoles() (map[string]string, error) {
+	if d.roles == nil {
+		roles, err := d.resolver.DirectoryRoleNames(d.ctx)
+		if err != nil {
+			return nil, err
+		}
+		d.roles = roles
+	}
+	return d.roles, nil
+}
+
+// namedLocation returns the definition of the named location with the given
+// ID.
+func (d *directoryCache) namedLocation(id string) (models.NamedLocationable, error) {
+	return d.resolver.NamedLocation(d.ctx, id)
+}
+
+// roleRefs pairs each directory role template ID with its display name in
+// roles. Unknown roles are kept without a name.
+func roleRefs(ids []string, roles map[string]string) []principalRef {
+	var refs []principalRef
+	for _, id := range ids {
+		refs = append(refs, principalRef{ID: id, Name: roles[id]})
+	}
+	return refs
+}
+
+// resolveAll resolves each ID with lookup. Keywords such as "All" are kept as
+// they are, and IDs that fail to resolve are kept without a name.
+func (d *directoryCache) resolveAll(ids []string, lookup func(string) (string, error)) []principalRef {
+	var refs []principalRef
+	for _, id := range ids {
+		ref := principalRef{ID: id}
+		if !isReferenceKeyword(id) {
+			name, err := lookup(id)
+			if err != nil {
+				fmt.Fprintf(d.log, "Warning: keeping unresolved object %s as an ID: %v\n", id, err)
+			} else {
+				ref.Name = name
+			}
+		}
+		refs = append(refs, ref)
+	}
+	return refs
+}
+
+// cachedLookup returns the cached name for id, calling lookup on a miss.
+func (d *directoryCache) cachedLookup(cache map[string]string, id string, lookup func(string) (string, error)) (string, error) {
+	if name, ok := cache[id]; ok {
+		return name, nil
+	}
+	name, err := lookup(id)
+	if err != nil {
+		return "", err
+	}
+	cache[id] = name
+	return name, nil
+}
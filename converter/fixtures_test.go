@@ -0,0 +1,127 @@
+package converter
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+)
+
+// fakeSource is a PolicySource returning a fixed list of policies.
+type fakeSource []models.ConditionalAccessPolicy
+
+func (s fakeSource) Policies(ctx context.Context) ([]models.ConditionalAccessPolicy, error) {
+	return s, nil
+}
+
+// fakeDirectory is a DirectoryResolver backed by maps from object ID to name.
+// Named locations are IP ranges without any range.
+type fakeDirectory struct {
+	users     map[string]string
+	groups    map[string]string
+	locations map[string]string
+	roles     map[string]string
+}
+
+func lookup(names map[string]string, kind, id string) (string, error) {
+	if name, ok := names[id]; ok {
+		return name, nil
+	}
+	return "", fmt.Errorf("%s %s not found", kind, id)
+}
+
+func (d fakeDirectory) UserPrincipalName(ctx context.Context, id string) (string, error) {
+	return lookup(d.users, "user", id)
+}
+
+func (d fakeDirectory) GroupDisplayName(ctx context.Context, id string) (string, error) {
+	return lookup(d.groups, "group", id)
+}
+
+func (d fakeDirectory) NamedLocationDisplayName(ctx context.Context, id string) (string, error) {
+	return lookup(d.locations, "named location", id)
+}
+
+func (d fakeDirectory) NamedLocation(ctx context.Context, id string) (models.NamedLocationable, error) {
+	name, err := lookup(d.locations, "named location", id)
+	if err != nil {
+		return nil, err
+	}
+	location := models.NewIpNamedLocation()
+	location.SetId(&id)
+	location.SetDisplayName(&name)
+	return location, nil
+}
+
+func (d fakeDirectory) DirectoryRoleNames(ctx context.Context) (map[string]string, error) {
+	return d.roles, nil
+}
+
+const (
+	testUserID  = "11111111-1111-1111-1111-111111111111"
+	testGroupID = "22222222-2222-2222-2222-222222222222"
+)
+
+var testDirectory = fakeDirectory{
+	users:  map[string]string{testUserID: "breakglass@contoso.com"},
+	groups: map[string]string{testGroupID: "CA Pilot"},
+	roles:  privilegedRoles,
+}
+
+// testPolicy returns an enabled policy requiring MFA for the pilot group,
+// excluding the break glass user.
+func testPolicy(id, name string) models.ConditionalAccessPolicy {
+	users := models.NewConditionalAccessUsers()
+	users.SetIncludeGroups([]string{testGroupID})
+	users.SetExcludeUsers([]string{testUserID})
+	applications := models.NewConditionalAccessApplications()
+	applications.SetIncludeApplications([]string{"All"})
+	conditions := models.NewConditionalAccessConditionSet()
+	conditions.SetUsers(users)
+	conditions.SetApplications(applications)
+	conditions.SetClientAppTypes([]models.ConditionalAccessClientApp{models.ALL_CONDITIONALACCESSCLIENTAPP})
+
+	operator := "OR"
+	grant := models.NewConditionalAccessGrantControls()
+	grant.SetOperator(&operator)
+	grant.SetBuiltInControls([]models.ConditionalAccessGrantControl{models.MFA_CONDITIONALACCESSGRANTCONTROL})
+
+	state := models.ENABLED_CONDITIONALACCESSPOLICYSTATE
+	policy := models.NewConditionalAccessPolicy()
+	policy.SetId(&id)
+	policy.SetDisplayName(&name)
+	policy.SetState(&state)
+	policy.SetConditions(conditions)
+	policy.SetGrantControls(grant)
+	return *policy
+}
+
+// testConfig returns the default configuration for mode, writing to "gen".
+func testConfig(mode string) *Config {
+	cfg := DefaultConfig()
+	cfg.OutputDir = "gen"
+	cfg.Mode = mode
+	return cfg
+}
+
+// generate runs the generator for policies with cfg, writing to out.
+func generate(out OutputWriter, cfg *Config, policies ...models.ConditionalAccessPolicy) (*Result, error) {
+	g := &Generator{
+		Config:    cfg,
+		Source:    fakeSource(policies),
+		Directory: testDirectory,
+		Output:    out,
+		TenantID:  "contoso.onmicrosoft.com",
+	}
+	return g.Generate(context.Background())
+}
+
+// testCAPolicy returns testPolicy in the normalized model, for the analyses
+// working on it.
+func testCAPolicy(id, name string) *caPolicy {
+	p, err := newCAPolicy(testPolicy(id, name), newDirectoryCache(context.Background(), testDirectory))
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
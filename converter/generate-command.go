@@ -0,0 +1,168 @@
+package converter
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+// RunGenerate writes Terraform configuration for every policy in the tenant
+// and returns the process exit code.
+func RunGenerate(args []string) int {
+	ctx := context.Background()
+
+	fs := flag.NewFlagSet("generate", flag.ExitOnError)
+	common := addCommonFlags(fs)
+	binaryFlag := fs.String("binary", "", "CLI for import and verify: terraform, tofu or auto (default \"auto\")")
+	importPolicies := fs.Bool("import", false, "import the generated policies into state")
+	verify := fs.Bool("verify", false, "after importing, run a plan and fail if the configuration differs from the tenant")
+	prune := fs.String("prune", "", "handle resources for policies deleted from the tenant: delete, archive or removed (default: only report them)")
+	outputDir := fs.String("out", "", "output directory (default \""+defaultOutputDir+"\")")
+	policyResource := fs.String("policy-resource", "", "resource type for policies in the resources mode: azuread, msgraph or auto (default \""+policyResourceAzureAD+"\")")
+	mode := fs.String("mode", "", "output mode: resources, module or yaml (default \""+modeResources+"\")")
+	layout := fs.String("layout", "", "file layout: per-policy, single, by-state or by-prefix (default \""+layoutPerPolicy+"\")")
+	splitData := fs.Bool("split-data", false, "write data sources to one file per object type")
+	docs := fs.Bool("docs", false, "write Markdown documentation for every policy")
+	backup := fs.Bool("backup", false, "write a Graph JSON backup of every policy")
+	matrix := fs.String("matrix", "", "write a table of all policies: csv or tsv")
+	bicep := fs.Bool("bicep", false, "write a Bicep template for the Microsoft Graph extension")
+	graph := fs.Bool("graph", false, "write a Graphviz and Mermaid dependency graph of all policies")
+	mapping := fs.String("mapping", "", "JSON file mapping users, groups and named locations onto their names in the tenant to promote to")
+	fs.Parse(args)
+
+	cfg, _, err := common.load()
+	if err != nil {
+		log.Printf("error loading config: %v", err)
+		return 1
+	}
+	if *binaryFlag != "" {
+		cfg.Binary = *binaryFlag
+	}
+	if *prune != "" {
+		cfg.Prune = *prune
+	}
+	if *outputDir != "" {
+		cfg.OutputDir = *outputDir
+	}
+	if *mode != "" {
+		cfg.Mode = *mode
+	}
+	if *policyResource != "" {
+		cfg.PolicyResource = *policyResource
+	}
+	if *layout != "" {
+		cfg.Layout = *layout
+	}
+	if *splitData {
+		cfg.SplitData = true
+	}
+	if *docs {
+		cfg.Docs = true
+	}
+	if *backup {
+		cfg.Backup = true
+	}
+	if *matrix != "" {
+		cfg.Matrix = *matrix
+	}
+	if *graph {
+		cfg.Graph = true
+	}
+	if *bicep {
+		cfg.Bicep = true
+	}
+	if *mapping != "" {
+		cfg.Mapping = *mapping
+	}
+	if err := cfg.validate(); err != nil {
+		log.Printf("error loading config: %v", err)
+		return 1
+	}
+	if err := os.MkdirAll(cfg.OutputDir, 0755); err != nil {
+		log.Printf("error creating output directory: %v", err)
+		return 1
+	}
+	binary, execPath, binaryErr := detectBinary(cfg.Binary)
+	if binaryErr != nil && (*importPolicies || *verify) {
+		log.Printf("error finding CLI: %v", binaryErr)
+		return 1
+	}
+
+	graphClient, err := NewGraphClient(ctx, cfg.GraphEndpoint)
+	if err != nil {
+		log.Printf("error configuring Graph client: %v", err)
+		return 1
+	}
+	tenantID, err := get_tenant_id(ctx, graphClient)
+	if err != nil {
+		log.Printf("error getting tenant ID: %v", err)
+		return 1
+	}
+	generator := &Generator{
+		Config:   cfg,
+		Source:   &GraphSource{Client: graphClient},
+		Output:   DiskWriter{},
+		TenantID: tenantID,
+		Binary:   binary,
+		Log:      os.Stdout,
+	}
+	result, err := generator.Generate(ctx)
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+
+	if len(result.Unmapped) > 0 {
+		writeUnmappedReport(os.Stdout, result.Unmapped)
+		log.Printf("%d references have no mapping to the target tenant", len(result.Unmapped))
+		return 1
+	}
+	if len(result.Failed) > 0 {
+		for _, failure := range result.Failed {
+			log.Printf("error generating %v", failure)
+		}
+		log.Printf("%d of %d policies could not be generated", len(result.Failed), len(result.Policies))
+		return 1
+	}
+
+	if !*importPolicies && !*verify {
+		return 0
+	}
+	tf, err := newTerraform(cfg.OutputDir, execPath)
+	if err != nil {
+		log.Printf("error preparing %s: %v", binary, err)
+		return 1
+	}
+	if *importPolicies {
+		manifest, err := loadManifest(DiskWriter{}, cfg.OutputDir)
+		if err != nil {
+			log.Printf("error loading manifest: %v", err)
+			return 1
+		}
+		for _, value := range result.Generated {
+			entry, ok := manifest.Policies[stringValue(value.GetId())]
+			if !ok {
+				log.Printf("error importing policy %s: not in the manifest", *value.GetDisplayName())
+				continue
+			}
+			if err := import_policy_to_tfstate(tf, value, entry, cfg.Mode); err != nil {
+				log.Printf("error importing policy %s: %v", *value.GetDisplayName(), err)
+			}
+		}
+	}
+	if *verify {
+		inSync, err := verify_tfstate(tf)
+		if err != nil {
+			log.Printf("error verifying with %s: %v", binary, err)
+			return 1
+		}
+		if !inSync {
+			log.Printf("%s plan shows changes: generated configuration does not match the tenant", binary)
+			return 1
+		}
+		fmt.Fprintf(generator.Log, "Verified with %s: no changes\n", binary)
+	}
+	return 0
+}
@@ -0,0 +1,241 @@
+package converter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+)
+
+// Generator writes Terraform configuration, and the other outputs selected by
+// Config, for the policies of a source.
+type Generator struct {
+	// Config holds the settings of the run. Nil uses DefaultConfig.
+	Config *Config
+
+	// Source provides the policies.
+	Source PolicySource
+
+	// Directory resolves the objects the policies refer to. It defaults to
+	// Source when that is a DirectoryResolver too.
+	Directory DirectoryResolver
+
+	// Output stores the generated files below Config.OutputDir. It defaults
+	// to DiskWriter.
+	Output OutputWriter
+
+	// TenantID is written to the provider configuration, unless the mapping
+	// of Config.Mapping names a tenant to promote to.
+	TenantID string
+
+	// Binary is the CLI the configuration is for, "terraform" or "tofu",
+	// which decides the registry of the providers. It defaults to terraform.
+	Binary string
+
+	// Log receives progress messages, such as the files written and
+	// objects that could not be resolved. Nil discards them.
+	Log io.Writer
+}
+
+// Result describes the policies of a Generate run.
+type Result struct {
+	// Policies are all policies read from the source.
+	Policies []models.ConditionalAccessPolicy
+
+	// Generated are the policies configuration was written for.
+	Generated []models.ConditionalAccessPolicy
+
+	// Failed are the policies configuration could not be written for.
+	Failed []*PolicyError
+
+	// Unmapped lists the references that have no target in the mapping of
+	// Config.Mapping. The configuration refers to them by their source name.
+	Unmapped []*UnmappedReference
+}
+
+// PolicyError is the error generating the configuration of one policy.
+type PolicyError struct {
+	ID          string
+	DisplayName string
+	Err         error
+}
+
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("policy %q: %v", e.DisplayName, e.Err)
+}
+
+func (e *PolicyError) Unwrap() error {
+	return e.Err
+}
+
+// Generate reads the policies from the source and writes their configuration,
+// merging it into files written before.
+func (g *Generator) Generate(ctx context.Context) (*Result, error) {
+	cfg := DefaultConfig()
+	if g.Config != nil {
+		copied := *g.Config
+		cfg = &copied
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("error loading config: %v", err)
+	}
+	schema, err := lookupProviderSchema(cfg.ProviderTarget, cfg.ProviderVersion)
+	if err != nil {
+		return nil, fmt.Errorf("error loading config: %v", err)
+	}
+	cfg.ProviderVersion = schema.version
+	if cfg.PolicyResource != policyResourceAzureAD && cfg.MSGraphProviderVersion == "" {
+		cfg.MSGraphProviderVersion = defaultMSGraphProviderVersion
+	}
+	outputLayout, err := newOutputLayout(cfg.Layout, cfg.LayoutPrefixPattern)
+	if err != nil {
+		return nil, fmt.Errorf("error loading config: %v", err)
+	}
+	var mapping *principalMapping
+	if cfg.Mapping != "" {
+		if mapping, err = loadPrincipalMapping(cfg.Mapping); err != nil {
+			return nil, fmt.Errorf("error loading config: %v", err)
+		}
+	}
+
+	if g.Source == nil {
+		return nil, fmt.Errorf("no policy source")
+	}
+	resolver := g.Directory
+	if resolver == nil {
+		var ok bool
+		if resolver, ok = g.Source.(DirectoryResolver); !ok {
+			return nil, fmt.Errorf("no directory resolver")
+		}
+	}
+	out := g.Output
+	if out == nil {
+		out = DiskWriter{}
+	}
+	binary := g.Binary
+	if binary == "" {
+		binary = "terraform"
+	}
+	logWriter := g.Log
+	if logWriter == nil {
+		logWriter = io.Discard
+	}
+	tenantID := g.TenantID
+	if mapping != nil && mapping.TenantID != "" {
+		tenantID = mapping.TenantID
+	}
+
+	policies, err := g.Source.Policies(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error getting existing policies: %v", err)
+	}
+
+	manifest, err := loadManifest(out, cfg.OutputDir)
+	if err != nil {
+		return nil, fmt.Errorf("error loading manifest: %v", err)
+	}
+	values := manifest.attributeValues(cfg.OutputDir)
+
+	// create versions.tf and provider.tf
+	if err := createVersionsFile(out, cfg, binary, values); err != nil {
+		return nil, fmt.Errorf("error creating versions file: %v", err)
+	}
+	if err := createProviderFile(out, cfg, tenantID, values); err != nil {
+		return nil, fmt.Errorf("error creating provider file: %v", err)
+	}
+
+	directory := newDirectoryCache(ctx, resolver)
+	directory.log = logWriter
+	generator := &policyGenerator{
+		mode:      cfg.Mode,
+		out:       out,
+		log:       logWriter,
+		outputDir: cfg.OutputDir,
+		layout:    outputLayout,
+		schema:    schema,
+		directory: directory,
+		data:      newDataSourceSet(),
+		manifest:  manifest,
+		values:    values,
+		labels:    manifest.assignLabels(policies),
+
+		policyResource: cfg.PolicyResource,
+		mapping:        mapping,
+	}
+	result := &Result{Policies: policies}
+	for _, value := range policies {
+		create := create_azurecapolicy
+		if cfg.Mode != modeResources {
+			create = add_module_policy
+		}
+		if err := create(value, generator); err != nil {
+			result.Failed = append(result.Failed, &PolicyError{
+				ID:          stringValue(value.GetId()),
+				DisplayName: stringValue(value.GetDisplayName()),
+				Err:         err,
+			})
+			continue
+		}
+		result.Generated = append(result.Generated, value)
+	}
+	if cfg.Mode != modeResources {
+		if err := generator.writeModulePolicies(binary); err != nil {
+			return nil, fmt.Errorf("error writing policy module: %v", err)
+		}
+	}
+
+	// merge the referenced data sources into the data files
+	if err := generator.data.writeDataFiles(out, logWriter, cfg.OutputDir, cfg.SplitData, values); err != nil {
+		return nil, fmt.Errorf("error writing data files: %v", err)
+	}
+	var roles map[string]string
+	if cfg.Docs || cfg.Matrix != "" || cfg.Graph {
+		if roles, err = generator.directory.directoryRoles(); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Docs {
+		if err := writePolicyDocs(out, logWriter, cfg.OutputDir, generator.policies, roles); err != nil {
+			return nil, fmt.Errorf("error writing documentation: %v", err)
+		}
+	}
+	if cfg.Matrix != "" {
+		if err := writePolicyMatrix(out, logWriter, cfg.OutputDir, cfg.Matrix, generator.policies, roles); err != nil {
+			return nil, fmt.Errorf("error writing policy matrix: %v", err)
+		}
+	}
+	if cfg.Graph {
+		if err := writePolicyGraph(out, logWriter, cfg.OutputDir, generator.policies, roles); err != nil {
+			return nil, fmt.Errorf("error writing dependency graph: %v", err)
+		}
+	}
+	if cfg.Backup {
+		if err := writePolicyBackups(out, logWriter, filepath.Join(cfg.OutputDir, backupDirName), policies, generator.directory); err != nil {
+			return nil, fmt.Errorf("error writing backups: %v", err)
+		}
+	}
+	if cfg.Bicep {
+		if err := writeBicepFiles(out, logWriter, filepath.Join(cfg.OutputDir, bicepDirName), policies, generator.directory); err != nil {
+			return nil, fmt.Errorf("error writing Bicep files: %v", err)
+		}
+	}
+	// clean up resources for policies that were deleted from the tenant
+	if err := pruneStalePolicies(out, logWriter, cfg.OutputDir, policies, manifest, cfg.Prune); err != nil {
+		return nil, fmt.Errorf("error pruning stale policies: %v", err)
+	}
+
+	liveIDs := map[string]bool{}
+	for _, value := range policies {
+		liveIDs[stringValue(value.GetId())] = true
+	}
+	if err := manifest.save(out, cfg.OutputDir, liveIDs); err != nil {
+		return nil, fmt.Errorf("error saving manifest: %v", err)
+	}
+
+	if mapping != nil {
+		result.Unmapped = mapping.unmappedReferences()
+	}
+	return result, nil
+}
@@ -0,0 +1,205 @@
+package converter
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+)
+
+func TestGenerateWritesEachMode(t *testing.T) {
+	for _, tc := range []struct {
+		mode string
+		// files must be written, contents must appear in the policy file
+		files    []string
+		contents []string
+	}{
+		{
+			mode:     modeResources,
+			files:    []string{"gen/Require MFA.tf", "gen/Block legacy.tf"},
+			contents: []string{`resource "azuread_conditional_access_policy" "require_mfa"`, `id = "a1"`},
+		},
+		{
+			mode:     modeModule,
+			files:    []string{"gen/" + policyModuleFileName},
+			contents: []string{`module "ca_policies"`, `"require_mfa" = {`, `to = module.ca_policies["require_mfa"]`},
+		},
+		{
+			mode:     modeYAML,
+			files:    []string{"gen/" + policyModuleFileName, "gen/policies/require_mfa.yaml", "gen/policies/block_legacy.yaml"},
+			contents: []string{`yamldecode`},
+		},
+	} {
+		t.Run(tc.mode, func(t *testing.T) {
+			out := NewMemoryWriter()
+			result, err := generate(out, testConfig(tc.mode), testPolicy("a1", "Require MFA"), testPolicy("b2", "Block legacy"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(result.Generated) != 2 || len(result.Failed) != 0 {
+				t.Fatalf("generated %d, failed %v, want both policies generated", len(result.Generated), result.Failed)
+			}
+			for _, path := range append(tc.files, "gen/"+manifestFileName) {
+				if _, err := out.ReadFile(path); err != nil {
+					t.Errorf("%s not written: %v", path, err)
+				}
+			}
+			src, _ := out.ReadFile(tc.files[0])
+			for _, want := range tc.contents {
+				if !strings.Contains(string(src), want) {
+					t.Errorf("%s does not contain %s:\n%s", tc.files[0], want, src)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateAddsMovedBlockForRenamedPolicy(t *testing.T) {
+	for _, tc := range []struct{ mode, file, from, to string }{
+		{modeResources, "gen/Require MFA for pilots.tf", "azuread_conditional_access_policy.require_mfa", "azuread_conditional_access_policy.require_mfa_for_pilots"},
+		{modeModule, "gen/" + policyModuleFileName, moduleInstanceAddress("require_mfa"), moduleInstanceAddress("require_mfa_for_pilots")},
+		{modeYAML, "gen/" + policyModuleFileName, moduleInstanceAddress("require_mfa"), moduleInstanceAddress("require_mfa_for_pilots")},
+	} {
+		t.Run(tc.mode, func(t *testing.T) {
+			out := NewMemoryWriter()
+			if _, err := generate(out, testConfig(tc.mode), testPolicy("a1", "Require MFA")); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := generate(out, testConfig(tc.mode), testPolicy("a1", "Require MFA for pilots")); err != nil {
+				t.Fatal(err)
+			}
+
+			src, err := out.ReadFile(tc.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range []string{"from = " + tc.from, "to   = " + tc.to} {
+				if !strings.Contains(string(src), want) {
+					t.Errorf("no %q in the moved block:\n%s", want, src)
+				}
+			}
+			for _, old := range []string{"gen/Require MFA.tf", "gen/policies/require_mfa.yaml"} {
+				if _, err := out.ReadFile(old); err == nil {
+					t.Errorf("%s of the old name was kept", old)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateIsIdempotent(t *testing.T) {
+	for _, mode := range []string{modeResources, modeModule, modeYAML} {
+		t.Run(mode, func(t *testing.T) {
+			out := NewMemoryWriter()
+			policies := []models.ConditionalAccessPolicy{testPolicy("a1", "Require MFA"), testPolicy("b2", "Block legacy")}
+			if _, err := generate(out, testConfig(mode), policies...); err != nil {
+				t.Fatal(err)
+			}
+			first := map[string][]byte{}
+			for path, content := range out.Files {
+				first[path] = content
+			}
+
+			if _, err := generate(out, testConfig(mode), policies...); err != nil {
+				t.Fatal(err)
+			}
+			if len(out.Files) != len(first) {
+				t.Errorf("regenerating wrote %d files, want %d", len(out.Files), len(first))
+			}
+			for path, content := range out.Files {
+				if !bytes.Equal(content, first[path]) {
+					t.Errorf("regenerating changed %s:\n%s\nwas:\n%s", path, content, first[path])
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateReturnsPolicyErrors(t *testing.T) {
+	unnamed := testPolicy("c3", "")
+	unnamed.SetDisplayName(nil)
+	out := NewMemoryWriter()
+	result, err := generate(out, testConfig(modeResources), testPolicy("a1", "Require MFA"), unnamed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Generated) != 1 || stringValue(result.Generated[0].GetId()) != "a1" {
+		t.Errorf("generated %d policies, want only a1", len(result.Generated))
+	}
+	if len(result.Failed) != 1 || result.Failed[0].ID != "c3" {
+		t.Fatalf("failed = %v, want c3", result.Failed)
+	}
+	if !strings.Contains(result.Failed[0].Error(), "no display name") {
+		t.Errorf("error = %v, want the cause", result.Failed[0])
+	}
+}
+
+func TestGenerateSuffixesCollidingLabels(t *testing.T) {
+	for _, tc := range []struct{ mode, file string }{
+		{modeResources, "gen/require mfa_2.tf"},
+		{modeModule, "gen/" + policyModuleFileName},
+	} {
+		t.Run(tc.mode, func(t *testing.T) {
+			out := NewMemoryWriter()
+			if _, err := generate(out, testConfig(tc.mode), testPolicy("b2", "require mfa"), testPolicy("a1", "Require MFA")); err != nil {
+				t.Fatal(err)
+			}
+			manifest, err := loadManifest(out, "gen")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if a1, b2 := manifest.Policies["a1"].Label, manifest.Policies["b2"].Label; a1 != "require_mfa" || b2 != "require_mfa_2" {
+				t.Fatalf("labels = %s, %s, want require_mfa, require_mfa_2", a1, b2)
+			}
+			src, err := out.ReadFile(tc.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(src), "require_mfa_2") {
+				t.Errorf("no suffixed label in %s:\n%s", tc.file, src)
+			}
+
+			// the suffix stays with its policy once the other one is deleted
+			if _, err := generate(out, testConfig(tc.mode), testPolicy("b2", "require mfa")); err != nil {
+				t.Fatal(err)
+			}
+			if manifest, _ = loadManifest(out, "gen"); manifest.Policies["b2"].Label != "require_mfa_2" {
+				t.Errorf("label = %s after regenerating, want require_mfa_2", manifest.Policies["b2"].Label)
+			}
+		})
+	}
+}
+
+func TestGenerateMergesYAMLDocuments(t *testing.T) {
+	out := NewMemoryWriter()
+	cfg := testConfig(modeYAML)
+	if _, err := generate(out, cfg, testPolicy("a1", "Require MFA")); err != nil {
+		t.Fatal(err)
+	}
+	src, _ := out.ReadFile("gen/policies/require_mfa.yaml")
+	edited := strings.Replace(string(src), "grant_controls:", "# reviewed by secops\ngrant_controls:", 1)
+	edited = strings.Replace(edited, "operator: OR", "operator: AND", 1)
+	out.WriteFile("gen/policies/require_mfa.yaml", []byte(edited))
+
+	renamed := testPolicy("a1", "Require MFA now")
+	disabled := models.DISABLED_CONDITIONALACCESSPOLICYSTATE
+	renamed.SetState(&disabled)
+	if _, err := generate(out, cfg, renamed); err != nil {
+		t.Fatal(err)
+	}
+	got, err := out.ReadFile("gen/policies/require_mfa_now.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`# Conditional access policy "Require MFA now"`,
+		"state: disabled",
+		"# reviewed by secops",
+		"operator: AND",
+	} {
+		if !strings.Contains(string(got), want) {
+			t.Errorf("merged document has no %q:\n%s", want, got)
+		}
+	}
+}
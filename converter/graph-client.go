@@ -0,0 +1,43 @@
+package converter
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/microsoft/kiota-abstractions-go/authentication"
+	msgraphsdk "github.com/microsoftgraph/msgraph-sdk-go"
+)
+
+// NewGraphClient creates a Graph client signed in with the Azure CLI
+// credentials. When endpoint is set, requests go to that base URL instead and
+// are sent without credentials.
+func NewGraphClient(ctx context.Context, endpoint string) (*msgraphsdk.GraphServiceClient, error) {
+	return NewTenantGraphClient(ctx, endpoint, "")
+}
+
+// NewTenantGraphClient is NewGraphClient for the given tenant, which the
+// Azure CLI has to be signed in to.
+func NewTenantGraphClient(ctx context.Context, endpoint, tenantID string) (*msgraphsdk.GraphServiceClient, error) {
+	if endpoint != "" {
+		adapter, err := msgraphsdk.NewGraphRequestAdapter(&authentication.AnonymousAuthenticationProvider{})
+		if err != nil {
+			return nil, fmt.Errorf("error creating request adapter: %v", err)
+		}
+		adapter.SetBaseUrl(strings.TrimSuffix(endpoint, "/"))
+		return msgraphsdk.NewGraphServiceClient(adapter), nil
+	}
+
+	// Configure Azure credentials
+	cred, err := configureCredentials(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	scopes := []string{"https://graph.microsoft.com/.default"}
+	graphClient, err := msgraphsdk.NewGraphServiceClientWithCredentials(cred, scopes)
+	if err != nil {
+		return nil, fmt.Errorf("error creating client: %v", err)
+	}
+	return graphClient, nil
+}
@@ -0,0 +1,93 @@
+package converter
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+
+	"github.com/hashicorp/terraform-exec/tfexec"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+)
+
+// supportedBinaries lists the CLIs that can run the generated configuration,
+// in the order "auto" looks for them.
+var supportedBinaries = []string{"tofu", "terraform"}
+
+// registryHosts holds the registry each CLI installs providers from. Sources
+// are written fully qualified so the lock file and any mirrors agree with the
+// CLI that will use them.
+var registryHosts = map[string]string{
+	"terraform": "registry.terraform.io",
+	"tofu":      "registry.opentofu.org",
+}
+
+// detectBinary resolves the configured binary, "terraform", "tofu" or "auto",
+// to a CLI name and executable path. When the CLI cannot be found the name is
+// still returned, so configuration can be generated for a machine that will
+// run it elsewhere; auto then falls back to terraform.
+func detectBinary(choice string) (string, string, error) {
+	if choice == "" || choice == "auto" {
+		for _, name := range supportedBinaries {
+			if path, err := exec.LookPath(name); err == nil {
+				return name, path, nil
+			}
+		}
+		return "terraform", "", fmt.Errorf("neither tofu nor terraform found in PATH")
+	}
+
+	if _, ok := registryHosts[choice]; !ok {
+		return "", "", fmt.Errorf("unsupported binary %q, expected terraform, tofu or auto", choice)
+	}
+	path, err := exec.LookPath(choice)
+	if err != nil {
+		return choice, "", fmt.Errorf("error getting %s executable path: %v", choice, err)
+	}
+	return choice, path, nil
+}
+
+// providerSource returns the registry address of provider, e.g.
+// "hashicorp/azuread", for the given CLI.
+func providerSource(binary, provider string) string {
+	return fmt.Sprintf("%s/%s", registryHosts[binary], provider)
+}
+
+// newTerraform prepares workingDir for the import and verify workflows by
+// running init with the given executable, which may be terraform or tofu.
+func newTerraform(workingDir, execPath string) (*tfexec.Terraform, error) {
+	tf, err := tfexec.NewTerraform(workingDir, execPath)
+	if err != nil {
+		return nil, fmt.Errorf("error running NewTerraform: %s", err)
+	}
+
+	err = tf.Init(context.Background(), tfexec.Upgrade(true))
+	if err != nil {
+		return nil, fmt.Errorf("error running Init: %s", err)
+	}
+
+	return tf, nil
+}
+
+// import_policy_to_tfstate imports policy into the resource it was generated
+// as, which entry of the manifest records.
+func import_policy_to_tfstate(tf *tfexec.Terraform, policy models.ConditionalAccessPolicy, entry manifestEntry, mode string) error {
+	address := entry.resourceType() + "." + entry.Label
+	id := policyImportID(entry.resourceType(), *policy.GetId())
+	if mode != modeResources {
+		address = moduleInstanceAddress(entry.Label)
+	}
+	err := tf.Import(context.Background(), address, id)
+	if err != nil {
+		return fmt.Errorf("error running Import: %s", err)
+	}
+	return nil
+}
+
+// verify_tfstate runs a plan against the imported state and reports whether
+// the generated configuration matches the tenant.
+func verify_tfstate(tf *tfexec.Terraform) (bool, error) {
+	hasChanges, err := tf.Plan(context.Background())
+	if err != nil {
+		return false, fmt.Errorf("error running Plan: %s", err)
+	}
+	return !hasChanges, nil
+}
@@ -0,0 +1,409 @@
+package converter
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+)
+
+// Severities of lint rules, from the most to the least severe.
+const (
+	severityHigh   = "high"
+	severityMedium = "medium"
+	severityLow    = "low"
+)
+
+const defaultReportOnlyMaxDays = 30
+
+// phishingResistantStrengthID is the built-in "Phishing-resistant MFA"
+// authentication strength.
+const phishingResistantStrengthID = "00000000-0000-0000-0000-000000000004"
+
+// privilegedRoles are the directory role templates whose members should be
+// required to use phishing-resistant authentication, with their names.
+var privilegedRoles = map[string]string{
+	"62e90394-69f5-4237-9190-012177145e10": "Global Administrator",
+	"e8611ab8-c189-46e8-94e1-60213ab1f814": "Privileged Role Administrator",
+	"7be44c8a-adaf-4e2a-84d6-ab2649e08a13": "Privileged Authentication Administrator",
+	"194ae4cb-b126-40b2-bd5b-6091b380977d": "Security Administrator",
+	"b1be1c3e-b65d-4f19-8427-f6fa0d97feb9": "Conditional Access Administrator",
+	"9b895d92-2cd3-44c7-9d02-a6ac2d5ea5c3": "Application Administrator",
+	"fe930be7-5e62-47db-91af-98c3a49a38b1": "User Administrator",
+	"29232cdf-9323-42fd-ade2-1d097af3e4de": "Exchange Administrator",
+	"f28a1f50-f6e7-4571-818b-6a12f2af6b6c": "SharePoint Administrator",
+}
+
+// breakGlassPattern recognizes emergency access accounts and groups by name
+// when lint.break_glass is not configured.
+var breakGlassPattern = regexp.MustCompile(`(?i)break.?glass|emergency`)
+
+// LintConfig holds the settings of the lint command.
+type LintConfig struct {
+	// DisabledRules lists the IDs of rules that are not run.
+	DisabledRules []string `json:"disabled_rules,omitempty"`
+
+	// BreakGlass lists the emergency access accounts and groups every policy
+	// must exclude, by user principal name, display name or object ID. When
+	// empty, exclusions whose name mentions "break glass" or "emergency"
+	// count.
+	BreakGlass []string `json:"break_glass,omitempty"`
+
+	// ReportOnlyMaxDays is how long a policy may stay in report-only mode
+	// before CA004 reports it. It defaults to 30.
+	ReportOnlyMaxDays int `json:"report_only_max_days,omitempty"`
+}
+
+// lintPolicy is a policy under lint, with the time it was last changed, which
+// is zero when unknown as for policies read from backups.
+type lintPolicy struct {
+	*caPolicy
+	changed time.Time
+}
+
+// lintFinding is a problem found by a rule. Policy is empty for findings
+// about the policy set as a whole.
+type lintFinding struct {
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	Policy   string `json:"policy,omitempty"`
+	Message  string `json:"message"`
+}
+
+// lintRule checks the policies for one best practice.
+type lintRule struct {
+	id       string
+	severity string
+	check    func(l *policyLinter) []lintFinding
+}
+
+// lintRules is the built-in rule set, in the order findings are reported.
+var lintRules = []lintRule{
+	{"CA001", severityHigh, checkBreakGlassExclusion},
+	{"CA002", severityHigh, checkLegacyAuthenticationBlocked},
+	{"CA003", severityHigh, checkAdminPhishingResistant},
+	{"CA004", severityMedium, checkReportOnlyAge},
+	{"CA005", severityMedium, checkAllUsersExclusions},
+	{"CA006", severityMedium, checkSignInRiskPolicy},
+	{"CA007", severityMedium, checkUserRiskPolicy},
+	{"CA008", severityLow, checkDisabledPolicies},
+}
+
+// policyLinter runs the enabled rules over a set of policies.
+type policyLinter struct {
+	cfg      LintConfig
+	policies []lintPolicy
+	now      time.Time
+}
+
+// RunLint checks policies from the tenant or from backups against the
+// built-in rules and returns the process exit code: 0 without findings, 2
+// when there are findings and 1 on errors.
+func RunLint(args []string) int {
+	ctx := context.Background()
+
+	fs := flag.NewFlagSet("lint", flag.ExitOnError)
+	common := addCommonFlags(fs)
+	source := addPolicySourceFlags(fs)
+	format := fs.String("format", "text", "output format: text or json")
+	disable := fs.String("disable", "", "comma-separated IDs of rules to skip in addition to lint.disabled_rules")
+	fs.Parse(args)
+
+	cfg, _, err := common.load()
+	if err != nil {
+		log.Printf("error loading config: %v", err)
+		return 1
+	}
+	if *disable != "" {
+		cfg.Lint.DisabledRules = append(cfg.Lint.DisabledRules, strings.Split(*disable, ",")...)
+	}
+	for _, id := range cfg.Lint.DisabledRules {
+		if lookupLintRule(strings.TrimSpace(id)) == nil {
+			log.Printf("error loading config: unknown lint rule %q", id)
+			return 1
+		}
+	}
+
+	policies, directory, err := source.load(ctx, cfg, fs.Args())
+	if err != nil {
+		log.Printf("error getting policies: %v", err)
+		return 1
+	}
+	linter, err := newPolicyLinter(cfg.Lint, policies, directory, time.Now())
+	if err != nil {
+		log.Printf("error reading policies: %v", err)
+		return 1
+	}
+	findings := linter.run()
+
+	switch *format {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if findings == nil {
+			findings = []lintFinding{}
+		}
+		err = encoder.Encode(findings)
+	case "text":
+		writeLintText(os.Stdout, findings)
+	default:
+		log.Printf("unknown format %q, expected text or json", *format)
+		return 1
+	}
+	if err != nil {
+		log.Printf("error writing report: %v", err)
+		return 1
+	}
+	if len(findings) > 0 {
+		return 2
+	}
+	return 0
+}
+
+func newPolicyLinter(cfg LintConfig, policies []models.ConditionalAccessPolicy, directory *directoryCache, now time.Time) (*policyLinter, error) {
+	if cfg.ReportOnlyMaxDays == 0 {
+		cfg.ReportOnlyMaxDays = defaultReportOnlyMaxDays
+	}
+	l := &policyLinter{cfg: cfg, now: now}
+	for _, policy := range policies {
+		p, err := newCAPolicy(policy, directory)
+		if err != nil {
+			return nil, err
+		}
+		changed := policy.GetModifiedDateTime()
+		if changed == nil {
+			changed = policy.GetCreatedDateTime()
+		}
+		lp := lintPolicy{caPolicy: p}
+		if changed != nil {
+			lp.changed = *changed
+		}
+		l.policies = append(l.policies, lp)
+	}
+	sort.Slice(l.policies, func(i, j int) bool { return l.policies[i].DisplayName < l.policies[j].DisplayName })
+	return l, nil
+}
+
+func lookupLintRule(id string) *lintRule {
+	for i := range lintRules {
+		if lintRules[i].id == id {
+			return &lintRules[i]
+		}
+	}
+	return nil
+}
+
+// run returns the findings of every rule that is not disabled.
+func (l *policyLinter) run() []lintFinding {
+	disabled := map[string]bool{}
+	for _, id := range l.cfg.DisabledRules {
+		disabled[strings.TrimSpace(id)] = true
+	}
+	var findings []lintFinding
+	for _, rule := range lintRules {
+		if disabled[rule.id] {
+			continue
+		}
+		for _, finding := range rule.check(l) {
+			finding.Rule, finding.Severity = rule.id, rule.severity
+			findings = append(findings, finding)
+		}
+	}
+	return findings
+}
+
+// enforced returns the policies that are switched on.
+func (l *policyLinter) enforced() []lintPolicy {
+	var policies []lintPolicy
+	for _, p := range l.policies {
+		if p.State == "enabled" {
+			policies = append(policies, p)
+		}
+	}
+	return policies
+}
+
+// isBreakGlass reports whether ref is an emergency access account or group.
+func (l *policyLinter) isBreakGlass(ref principalRef) bool {
+	if len(l.cfg.BreakGlass) == 0 {
+		return breakGlassPattern.MatchString(ref.Name)
+	}
+	for _, value := range l.cfg.BreakGlass {
+		if strings.EqualFold(value, ref.ID) || ref.Name != "" && strings.EqualFold(value, ref.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// CA001: policies that can lock users out must exclude the emergency access
+// accounts.
+func checkBreakGlassExclusion(l *policyLinter) []lintFinding {
+	var findings []lintFinding
+	for _, p := range l.policies {
+		if p.State == "disabled" || p.Grant == nil {
+			continue
+		}
+		excluded := false
+		for _, refs := range [][]principalRef{p.Conditions.Users.ExcludeUsers, p.Conditions.Users.ExcludeGroups} {
+			for _, ref := range refs {
+				excluded = excluded || l.isBreakGlass(ref)
+			}
+		}
+		if !excluded {
+			findings = append(findings, lintFinding{Policy: p.DisplayName, Message: "does not exclude an emergency access (break glass) account or group"})
+		}
+	}
+	return findings
+}
+
+// CA002: legacy authentication clients must be blocked for everyone.
+func checkLegacyAuthenticationBlocked(l *policyLinter) []lintFinding {
+	for _, p := range l.enforced() {
+		if includesAll(p.Conditions.Users.IncludeUsers) && appliesToAllApps(p.caPolicy) &&
+			contains(p.Conditions.ClientAppTypes, "exchangeActiveSync") && contains(p.Conditions.ClientAppTypes, "other") &&
+			p.Grant != nil && contains(p.Grant.BuiltInControls, "block") {
+			return nil
+		}
+	}
+	return []lintFinding{{Message: "no enabled policy blocks legacy authentication (exchangeActiveSync and other clients) for all users"}}
+}
+
+// CA003: privileged roles must be required to use phishing-resistant
+// authentication for all applications.
+func checkAdminPhishingResistant(l *policyLinter) []lintFinding {
+	covered := map[string]bool{}
+	for _, p := range l.enforced() {
+		if p.Grant == nil || p.Grant.AuthenticationStrengthPolicyID != phishingResistantStrengthID || !appliesToAllApps(p.caPolicy) {
+			continue
+		}
+		for id := range privilegedRoles {
+			if includesAll(p.Conditions.Users.IncludeUsers) && !contains(p.Conditions.Users.ExcludeRoles, id) || contains(p.Conditions.Users.IncludeRoles, id) {
+				covered[id] = true
+			}
+		}
+	}
+	var missing []string
+	for id, name := range privilegedRoles {
+		if !covered[id] {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	sort.Strings(missing)
+	return []lintFinding{{Message: "no enabled policy requires phishing-resistant authentication strength for " + strings.Join(missing, ", ")}}
+}
+
+// CA004: report-only is meant for evaluation, not as a permanent state.
+func checkReportOnlyAge(l *policyLinter) []lintFinding {
+	var findings []lintFinding
+	maxAge := time.Duration(l.cfg.ReportOnlyMaxDays) * 24 * time.Hour
+	for _, p := range l.policies {
+		if p.State != "enabledForReportingButNotEnforced" || p.changed.IsZero() {
+			continue
+		}
+		if age := l.now.Sub(p.changed); age > maxAge {
+			findings = append(findings, lintFinding{
+				Policy:  p.DisplayName,
+				Message: fmt.Sprintf("has been in report-only mode for %d days, longer than %d", int(age.Hours()/24), l.cfg.ReportOnlyMaxDays),
+			})
+		}
+	}
+	return findings
+}
+
+// CA005: a policy for all users without any exclusion leaves no way around a
+// misconfiguration.
+func checkAllUsersExclusions(l *policyLinter) []lintFinding {
+	var findings []lintFinding
+	for _, p := range l.policies {
+		users := &p.Conditions.Users
+		if p.State == "disabled" || !includesAll(users.IncludeUsers) {
+			continue
+		}
+		if len(users.ExcludeUsers)+len(users.ExcludeGroups)+len(users.ExcludeRoles) == 0 && users.ExcludeGuests == nil {
+			findings = append(findings, lintFinding{Policy: p.DisplayName, Message: "applies to all users without any exclusions"})
+		}
+	}
+	return findings
+}
+
+// CA006: risky sign-ins should be challenged.
+func checkSignInRiskPolicy(l *policyLinter) []lintFinding {
+	for _, p := range l.enforced() {
+		if len(p.Conditions.SignInRiskLevels) > 0 {
+			return nil
+		}
+	}
+	return []lintFinding{{Message: "no enabled policy uses a sign-in risk condition"}}
+}
+
+// CA007: users with a high risk should be made to secure their account.
+func checkUserRiskPolicy(l *policyLinter) []lintFinding {
+	for _, p := range l.enforced() {
+		if len(p.Conditions.UserRiskLevels) > 0 {
+			return nil
+		}
+	}
+	return []lintFinding{{Message: "no enabled policy uses a user risk condition"}}
+}
+
+// CA008: disabled policies are clutter that hides the effective design.
+func checkDisabledPolicies(l *policyLinter) []lintFinding {
+	var findings []lintFinding
+	for _, p := range l.policies {
+		if p.State == "disabled" {
+			findings = append(findings, lintFinding{Policy: p.DisplayName, Message: "is disabled"})
+		}
+	}
+	return findings
+}
+
+func writeLintText(w io.Writer, findings []lintFinding) {
+	if len(findings) == 0 {
+		fmt.Fprintln(w, "No findings.")
+		return
+	}
+	for _, finding := range findings {
+		message := finding.Message
+		if finding.Policy != "" {
+			message = fmt.Sprintf("policy %q %s", finding.Policy, message)
+		}
+		fmt.Fprintf(w, "%-6s %s %s\n", strings.ToUpper(finding.Severity), finding.Rule, message)
+	}
+}
+
+// includesAll reports whether refs contain the "All" keyword.
+func includesAll(refs []principalRef) bool {
+	for _, ref := range refs {
+		if ref.ID == "All" {
+			return true
+		}
+	}
+	return false
+}
+
+// appliesToAllApps reports whether p targets all cloud apps.
+func appliesToAllApps(p *caPolicy) bool {
+	return p.Conditions.Applications != nil && contains(p.Conditions.Applications.IncludeApplications, "All")
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+package converter
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLintRules(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	allUsers := func(p *lintPolicy) {
+		p.Conditions.Users.IncludeUsers = []principalRef{{ID: "All"}}
+		p.Conditions.Users.IncludeGroups = nil
+	}
+	state := func(state string) func(p *lintPolicy) {
+		return func(p *lintPolicy) { p.State = state }
+	}
+	noExclusions := func(p *lintPolicy) { p.Conditions.Users.ExcludeUsers = nil }
+	blockLegacy := func(p *lintPolicy) {
+		p.Conditions.ClientAppTypes = []string{"exchangeActiveSync", "other"}
+		p.Grant = &caGrantControls{Operator: "OR", BuiltInControls: []string{"block"}}
+	}
+	phishingResistant := func(p *lintPolicy) {
+		p.Grant = &caGrantControls{Operator: "OR", AuthenticationStrengthPolicyID: phishingResistantStrengthID}
+	}
+	changed := func(days int) func(p *lintPolicy) {
+		return func(p *lintPolicy) { p.changed = now.AddDate(0, 0, -days) }
+	}
+	for _, tc := range []struct {
+		name, rule string
+		cfg        LintConfig
+		// edits change the only policy, "P", a copy of testPolicy
+		edits []func(p *lintPolicy)
+		// findings are the policies reported, "" for a finding about the
+		// policy set; message must appear in the first finding
+		findings []string
+		message  string
+	}{
+		{name: "break glass excluded by name", rule: "CA001"},
+		{name: "no break glass exclusion", rule: "CA001", edits: []func(p *lintPolicy){noExclusions}, findings: []string{"P"}},
+		{name: "disabled policy without break glass", rule: "CA001", edits: []func(p *lintPolicy){noExclusions, state("disabled")}},
+		{name: "configured break glass by ID", rule: "CA001", cfg: LintConfig{BreakGlass: []string{testUserID}}},
+		{name: "configured break glass not excluded", rule: "CA001", cfg: LintConfig{BreakGlass: []string{"emergency@contoso.com"}}, findings: []string{"P"}},
+
+		{name: "legacy authentication not blocked", rule: "CA002", findings: []string{""}},
+		{name: "legacy authentication blocked", rule: "CA002", edits: []func(p *lintPolicy){allUsers, blockLegacy}},
+		{name: "legacy authentication blocked in report-only", rule: "CA002", edits: []func(p *lintPolicy){allUsers, blockLegacy, state("enabledForReportingButNotEnforced")}, findings: []string{""}},
+		{name: "legacy authentication blocked for a group", rule: "CA002", edits: []func(p *lintPolicy){blockLegacy}, findings: []string{""}},
+
+		{name: "admins without phishing-resistant strength", rule: "CA003", findings: []string{""}, message: "Global Administrator"},
+		{name: "phishing-resistant strength for all users", rule: "CA003", edits: []func(p *lintPolicy){allUsers, phishingResistant}},
+		{name: "phishing-resistant strength excluding a role", rule: "CA003", edits: []func(p *lintPolicy){allUsers, phishingResistant, func(p *lintPolicy) {
+			p.Conditions.Users.ExcludeRoles = []string{"62e90394-69f5-4237-9190-012177145e10"}
+		}}, findings: []string{""}, message: "strength for Global Administrator"},
+		{name: "phishing-resistant strength for the roles", rule: "CA003", edits: []func(p *lintPolicy){phishingResistant, func(p *lintPolicy) {
+			for id := range privilegedRoles {
+				p.Conditions.Users.IncludeRoles = append(p.Conditions.Users.IncludeRoles, id)
+			}
+		}}},
+
+		{name: "old report-only policy", rule: "CA004", edits: []func(p *lintPolicy){state("enabledForReportingButNotEnforced"), changed(40)}, findings: []string{"P"}, message: "for 40 days"},
+		{name: "recent report-only policy", rule: "CA004", edits: []func(p *lintPolicy){state("enabledForReportingButNotEnforced"), changed(10)}},
+		{name: "report-only policy of unknown age", rule: "CA004", edits: []func(p *lintPolicy){state("enabledForReportingButNotEnforced")}},
+		{name: "configured report-only age", rule: "CA004", cfg: LintConfig{ReportOnlyMaxDays: 5}, edits: []func(p *lintPolicy){state("enabledForReportingButNotEnforced"), changed(10)}, findings: []string{"P"}},
+		{name: "old enabled policy", rule: "CA004", edits: []func(p *lintPolicy){changed(40)}},
+
+		{name: "all users without exclusions", rule: "CA005", edits: []func(p *lintPolicy){allUsers, noExclusions}, findings: []string{"P"}},
+		{name: "all users with an exclusion", rule: "CA005", edits: []func(p *lintPolicy){allUsers}},
+		{name: "all users excluding guests", rule: "CA005", edits: []func(p *lintPolicy){allUsers, noExclusions, func(p *lintPolicy) {
+			p.Conditions.Users.ExcludeGuests = &caGuestsOrExternalUsers{GuestOrExternalUserTypes: []string{"b2bCollaborationGuest"}}
+		}}},
+		{name: "disabled policy for all users", rule: "CA005", edits: []func(p *lintPolicy){allUsers, noExclusions, state("disabled")}},
+
+		{name: "no sign-in risk policy", rule: "CA006", findings: []string{""}},
+		{name: "sign-in risk policy", rule: "CA006", edits: []func(p *lintPolicy){func(p *lintPolicy) { p.Conditions.SignInRiskLevels = []string{"high"} }}},
+		{name: "report-only sign-in risk policy", rule: "CA006", edits: []func(p *lintPolicy){state("enabledForReportingButNotEnforced"), func(p *lintPolicy) {
+			p.Conditions.SignInRiskLevels = []string{"high"}
+		}}, findings: []string{""}},
+
+		{name: "no user risk policy", rule: "CA007", findings: []string{""}},
+		{name: "user risk policy", rule: "CA007", edits: []func(p *lintPolicy){func(p *lintPolicy) { p.Conditions.UserRiskLevels = []string{"high"} }}},
+
+		{name: "disabled policy", rule: "CA008", edits: []func(p *lintPolicy){state("disabled")}, findings: []string{"P"}},
+		{name: "enabled policy", rule: "CA008"},
+	} {
+		t.Run(tc.rule+" "+tc.name, func(t *testing.T) {
+			p := lintPolicy{caPolicy: testCAPolicy("p1", "P")}
+			for _, edit := range tc.edits {
+				edit(&p)
+			}
+			cfg := tc.cfg
+			if cfg.ReportOnlyMaxDays == 0 {
+				cfg.ReportOnlyMaxDays = defaultReportOnlyMaxDays
+			}
+			l := &policyLinter{cfg: cfg, policies: []lintPolicy{p}, now: now}
+
+			findings := lookupLintRule(tc.rule).check(l)
+			var policies []string
+			for _, finding := range findings {
+				policies = append(policies, finding.Policy)
+			}
+			if !reflect.DeepEqual(policies, tc.findings) {
+				t.Fatalf("findings = %v, want %v", findings, tc.findings)
+			}
+			if tc.message != "" && !strings.Contains(findings[0].Message, tc.message) {
+				t.Errorf("message = %q, want %q in it", findings[0].Message, tc.message)
+			}
+		})
+	}
+}
+
+func TestLintSkipsDisabledRules(t *testing.T) {
+	l := &policyLinter{
+		cfg:      LintConfig{DisabledRules: []string{"CA006", " CA007"}, ReportOnlyMaxDays: defaultReportOnlyMaxDays},
+		policies: []lintPolicy{{caPolicy: testCAPolicy("p1", "P")}},
+	}
+	var rules []string
+	for _, finding := range l.run() {
+		rules = append(rules, finding.Rule)
+		if lookupLintRule(finding.Rule).severity != finding.Severity {
+			t.Errorf("%s finding has severity %s", finding.Rule, finding.Severity)
+		}
+	}
+	if want := []string{"CA002", "CA003"}; !reflect.DeepEqual(rules, want) {
+		t.Errorf("rules = %v, want %v", rules, want)
+	}
+}
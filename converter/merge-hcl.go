@@ -0,0 +1,588 @@
+package converter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+)
+
+// fileChange describes what writeMergedFile did to a file.
+type fileChange int
+
+const (
+	fileUnchanged fileChange = iota
+	fileCreated
+	fileUpdated
+)
+
+func (c fileChange) String() string {
+	switch c {
+	case fileCreated:
+		return "Created"
+	case fileUpdated:
+		return "Updated"
+	}
+	return "Unchanged"
+}
+
+// attributeValues records the expression the generator last wrote for each
+// attribute, so an attribute edited by hand can be told apart from one whose
+// tenant value changed. Files are keyed by their path relative to dir, and
+// attributes by their block and attribute path.
+type attributeValues struct {
+	dir   string
+	files map[string]map[string]string
+}
+
+func (v *attributeValues) key(path string) string {
+	if rel, err := filepath.Rel(v.dir, path); err == nil {
+		return filepath.ToSlash(rel)
+	}
+	return filepath.ToSlash(path)
+}
+
+// previous returns the values last written to the file at path. It is nil
+// when nothing was recorded.
+func (v *attributeValues) previous(path string) map[string]string {
+	if v == nil {
+		return nil
+	}
+	return v.files[v.key(path)]
+}
+
+func (v *attributeValues) record(path string, values map[string]string) {
+	if v != nil {
+		v.files[v.key(path)] = values
+	}
+}
+
+// move carries the values recorded for the file at oldPath over to newPath,
+// for a file that was renamed.
+func (v *attributeValues) move(oldPath, newPath string) {
+	if v == nil {
+		return
+	}
+	if values, ok := v.files[v.key(oldPath)]; ok {
+		delete(v.files, v.key(oldPath))
+		v.files[v.key(newPath)] = values
+	}
+}
+
+// writeMergedFile writes desired to path. If the file already exists the
+// generated blocks are merged into it instead, so comments, hand-written
+// attributes and blocks survive regeneration. known reports whether an
+// attribute or nested block path inside a generated block is one the
+// generator owns; owned entries missing from desired are removed, everything
+// else the generator does not produce is left alone. An owned attribute
+// edited by hand is only replaced when the generated value changed since
+// values recorded it, and never when the hand edit is neither a literal nor
+// a reference to generated data sources, such as a variable reference. The
+// file is only rewritten when its content changes.
+func writeMergedFile(out OutputWriter, path string, desired *hclwrite.File, known func(path string) bool, values *attributeValues) (fileChange, error) {
+	m := &bodyMerger{known: known}
+	return m.writeFile(out, path, desired, values)
+}
+
+// writeFile is writeMergedFile for a merger, which collects in skipped the
+// hand edits kept although the tenant value changed.
+func (m *bodyMerger) writeFile(out OutputWriter, path string, desired *hclwrite.File, values *attributeValues) (fileChange, error) {
+	m.previous = values.previous(path)
+	generated := map[string]string{}
+	for _, block := range desired.Body().Blocks() {
+		m.recordAttributes(block.Body(), blockID(block)+":", "", generated)
+	}
+	values.record(path, generated)
+
+	existingBytes, err := out.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fileCreated, out.WriteFile(path, formatHCL(desired.Bytes()))
+	}
+	if err != nil {
+		return fileUnchanged, err
+	}
+
+	existing, diags := hclwrite.ParseConfig(existingBytes, path, hcl.InitialPos)
+	if diags.HasErrors() {
+		return fileUnchanged, fmt.Errorf("error parsing %s: %s", path, diags.Error())
+	}
+
+	for _, block := range desired.Body().Blocks() {
+		if match := matchingBlock(existing.Body(), block); match != nil {
+			m.mergeBody(match.Body(), block.Body(), blockID(block)+":", "")
+		} else {
+			existing.Body().AppendNewline()
+			existing.Body().AppendBlock(block)
+		}
+	}
+
+	merged := formatHCL(existing.Bytes())
+	if bytes.Equal(merged, existingBytes) {
+		return fileUnchanged, nil
+	}
+	return fileUpdated, out.WriteFile(path, merged)
+}
+
+// removeMatchingBlocks removes the top-level blocks of desired from the file
+// at path, deleting the file if nothing is left. A missing file is ignored.
+func removeMatchingBlocks(out OutputWriter, path string, desired *hclwrite.File) error {
+	src, err := out.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	existing, diags := hclwrite.ParseConfig(src, path, hcl.InitialPos)
+	if diags.HasErrors() {
+		return fmt.Errorf("error parsing %s: %s", path, diags.Error())
+	}
+
+	removed := false
+	for _, block := range desired.Body().Blocks() {
+		if match := matchingBlock(existing.Body(), block); match != nil {
+			existing.Body().RemoveBlock(match)
+			removed = true
+		}
+	}
+	if !removed {
+		return nil
+	}
+	return writeOrRemoveFile(out, path, existing)
+}
+
+// writeOrRemoveFile writes f to path, or deletes path when f has no content
+// left.
+func writeOrRemoveFile(out OutputWriter, path string, f *hclwrite.File) error {
+	if len(f.Body().Blocks()) == 0 && len(f.Body().Attributes()) == 0 {
+		return out.Remove(path)
+	}
+	return out.WriteFile(path, formatHCL(f.Bytes()))
+}
+
+// writeFileIfChanged writes content to path unless the file already holds
+// exactly that content.
+func writeFileIfChanged(out OutputWriter, path string, content []byte) (fileChange, error) {
+	existing, err := out.ReadFile(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return fileCreated, out.WriteFile(path, content)
+	case err != nil:
+		return fileUnchanged, err
+	case bytes.Equal(existing, content):
+		return fileUnchanged, nil
+	}
+	return fileUpdated, out.WriteFile(path, content)
+}
+
+// formatHCL formats src like terraform fmt and drops the extra blank lines
+// left behind where blocks were removed.
+func formatHCL(src []byte) []byte {
+	formatted := hclwrite.Format(src)
+	for bytes.Contains(formatted, []byte("\n\n\n")) {
+		formatted = bytes.ReplaceAll(formatted, []byte("\n\n\n"), []byte("\n\n"))
+	}
+	return bytes.TrimLeft(formatted, "\n")
+}
+
+// bodyMerger merges generated blocks into existing ones. previous holds
+// the expressions written by the last run, keyed like recordAttributes.
+type bodyMerger struct {
+	known func(path string) bool
+
+	// entries reports whether the object value of the attribute at path is
+	// merged entry by entry, as a map holding one generated value per key
+	// such as the ca_policies local. Nil merges every attribute as a whole.
+	entries func(path string) bool
+
+	previous map[string]string
+
+	// skipped are the keys of the hand edits kept although the generated
+	// value changed since the last run.
+	skipped []string
+}
+
+// mergeBody updates existing so that every attribute and nested block in
+// desired is present with the desired value, unless the attribute was edited
+// by hand and keepsEdit says to leave it. key identifies the top-level block
+// for previous and prefix is the path of body inside it.
+func (m *bodyMerger) mergeBody(existing, desired *hclwrite.Body, key, prefix string) {
+	desiredAttributes := desired.Attributes()
+	for _, name := range attributeOrder(desired) {
+		desiredTokens := desiredAttributes[name].Expr().BuildTokens(nil)
+		current := existing.GetAttribute(name)
+		if current != nil && sameTokens(current.Expr().BuildTokens(nil), desiredTokens) {
+			continue
+		}
+		if current != nil && m.entries != nil && m.entries(prefix+name) {
+			currentSrc := current.Expr().BuildTokens(nil).Bytes()
+			if merged, ok := m.mergeObject(currentSrc, desiredTokens.Bytes(), key+prefix+name); ok {
+				if !bytes.Equal(merged, currentSrc) {
+					existing.SetAttributeRaw(name, rawTokens(string(merged)))
+				}
+				continue
+			}
+		}
+		if current != nil && m.keeps(current.Expr().BuildTokens(nil), desiredTokens, key+prefix+name) {
+			continue
+		}
+		existing.SetAttributeRaw(name, desiredTokens)
+	}
+	for name, attribute := range existing.Attributes() {
+		if _, ok := desiredAttributes[name]; ok || !m.known(prefix+name) {
+			continue
+		}
+		if _, generated := m.previous[key+prefix+name]; !generated && !generatorOwned(attribute.Expr().BuildTokens(nil)) {
+			continue
+		}
+		existing.RemoveAttribute(name)
+	}
+
+	desiredBlocks := map[string]bool{}
+	for _, block := range desired.Blocks() {
+		desiredBlocks[blockKey(block)] = true
+		if match := existing.FirstMatchingBlock(block.Type(), block.Labels()); match != nil {
+			m.mergeBody(match.Body(), block.Body(), key, prefix+block.Type()+".")
+		} else {
+			existing.AppendBlock(block)
+		}
+	}
+	for _, block := range existing.Blocks() {
+		if !desiredBlocks[blockKey(block)] && m.known(prefix+block.Type()) {
+			existing.RemoveBlock(block)
+		}
+	}
+}
+
+// keeps is keepsEdit, adding the attribute to skipped when the kept edit
+// hides a change of the generated value.
+func (m *bodyMerger) keeps(current, desired hclwrite.Tokens, key string) bool {
+	if !m.keepsEdit(current, desired, key) {
+		return false
+	}
+	if previous, ok := m.previous[key]; ok && expressionKey(desired) != previous {
+		m.skipped = append(m.skipped, key)
+	}
+	return true
+}
+
+// keepsEdit reports whether the current expression of the attribute at key
+// is a hand edit to keep instead of the desired one. Once the attribute
+// differs from what the last run wrote it was edited by hand: the edit stays
+// while the generated value is unchanged, and an edit the generator could not
+// have written always stays. Without a recorded value, such as for files of
+// an earlier version, only expressions the generator could have written are
+// replaced.
+func (m *bodyMerger) keepsEdit(current, desired hclwrite.Tokens, key string) bool {
+	previous, ok := m.previous[key]
+	switch {
+	case !ok:
+		return !generatorOwned(current)
+	case expressionKey(current) == previous:
+		return false
+	case expressionKey(desired) == previous:
+		return true
+	}
+	return !generatorOwned(current)
+}
+
+// recordAttributes adds the expression of every attribute in body and its
+// nested blocks to values, keyed by the block key, the path prefix of body
+// and the attribute name. The entries of attributes merged entry by entry are
+// recorded as well, keyed like mergeObject.
+func (m *bodyMerger) recordAttributes(body *hclwrite.Body, key, prefix string, values map[string]string) {
+	for name, attribute := range body.Attributes() {
+		tokens := attribute.Expr().BuildTokens(nil)
+		values[key+prefix+name] = expressionKey(tokens)
+		if m.entries != nil && m.entries(prefix+name) {
+			recordEntries(tokens.Bytes(), key+prefix+name, values)
+		}
+	}
+	for _, block := range body.Blocks() {
+		m.recordAttributes(block.Body(), key, prefix+block.Type()+".", values)
+	}
+}
+
+// recordEntries adds the values of the object expression src to values,
+// keyed by key and the path of object keys down to each value that is not
+// an object.
+func recordEntries(src []byte, key string, values map[string]string) {
+	expr, diags := hclsyntax.ParseExpression(src, "", hcl.InitialPos)
+	object, ok := expr.(*hclsyntax.ObjectConsExpr)
+	if diags.HasErrors() || !ok {
+		values[key] = expressionKey(rawTokens(string(src)))
+		return
+	}
+	for _, item := range object.Items {
+		recordEntries(item.ValueExpr.Range().SliceBytes(src), key+"."+objectItemKey(item), values)
+	}
+}
+
+// mergeObject merges the object expression desired into current key by key,
+// recursing into values that are objects on both sides, and returns the
+// merged source. Values merge like attributes; entries missing from desired
+// are dropped unless they were added by hand, and new entries are inserted
+// before the current entry that follows them in desired. Text between the
+// entries, such as comments, is kept. ok is false if either expression is
+// not an object.
+func (m *bodyMerger) mergeObject(current, desired []byte, key string) (merged []byte, ok bool) {
+	currentExpr, diags := hclsyntax.ParseExpression(current, "", hcl.InitialPos)
+	currentObject, isObject := currentExpr.(*hclsyntax.ObjectConsExpr)
+	if diags.HasErrors() || !isObject {
+		return nil, false
+	}
+	desiredExpr, diags := hclsyntax.ParseExpression(desired, "", hcl.InitialPos)
+	desiredObject, isObject := desiredExpr.(*hclsyntax.ObjectConsExpr)
+	if diags.HasErrors() || !isObject {
+		return nil, false
+	}
+
+	desiredValues := map[string][]byte{}
+	desiredItems := map[string][]byte{}
+	currentKeys := map[string]bool{}
+	for _, item := range currentObject.Items {
+		currentKeys[objectItemKey(item)] = true
+	}
+	// inserted maps the key of a current entry, or "" for the end, to the
+	// new entries written before it
+	inserted := map[string][][]byte{}
+	next := ""
+	for i := len(desiredObject.Items) - 1; i >= 0; i-- {
+		item := desiredObject.Items[i]
+		name := objectItemKey(item)
+		desiredValues[name] = item.ValueExpr.Range().SliceBytes(desired)
+		desiredItems[name] = hcl.RangeBetween(item.KeyExpr.Range(), item.ValueExpr.Range()).SliceBytes(desired)
+		if currentKeys[name] {
+			next = name
+		} else {
+			inserted[next] = append([][]byte{desiredItems[name]}, inserted[next]...)
+		}
+	}
+
+	var b bytes.Buffer
+	pos, closing := currentObject.OpenRange.End.Byte, currentObject.SrcRange.End.Byte-1
+	b.Write(current[:pos])
+	insert := func(items [][]byte) {
+		for _, item := range items {
+			if b.Len() > 0 && b.Bytes()[b.Len()-1] != '\n' {
+				b.WriteByte('\n')
+			}
+			b.Write(item)
+			b.WriteByte('\n')
+		}
+	}
+	for i, item := range currentObject.Items {
+		name := objectItemKey(item)
+		// an entry runs from the end of the previous one to the end of its
+		// line, taking along a trailing comment
+		end := closing
+		if i+1 < len(currentObject.Items) {
+			end = currentObject.Items[i+1].KeyExpr.Range().Start.Byte
+		}
+		valueRange := item.ValueExpr.Range()
+		if newline := bytes.IndexByte(current[valueRange.End.Byte:end], '\n'); newline >= 0 {
+			end = valueRange.End.Byte + newline + 1
+		}
+		leading, keyText := current[pos:item.KeyExpr.Range().Start.Byte], current[item.KeyExpr.Range().Start.Byte:valueRange.Start.Byte]
+		value, trailing := valueRange.SliceBytes(current), current[valueRange.End.Byte:end]
+		pos = end
+
+		desiredValue, ok := desiredValues[name]
+		if !ok {
+			if m.recorded(key+"."+name) || generatorOwned(rawTokens(string(value))) {
+				continue
+			}
+			desiredValue = value
+		}
+		insert(inserted[name])
+		b.Write(leading)
+		b.Write(keyText)
+		b.Write(m.mergeValue(value, desiredValue, key+"."+name))
+		b.Write(trailing)
+	}
+	if len(inserted[""]) > 0 {
+		b.Write(bytes.TrimRight(current[pos:closing], " \t"))
+		insert(inserted[""])
+	} else {
+		b.Write(current[pos:closing])
+	}
+	b.Write(current[closing:])
+	return b.Bytes(), true
+}
+
+// mergeValue returns the merged source of an entry of an object merged by
+// mergeObject.
+func (m *bodyMerger) mergeValue(current, desired []byte, key string) []byte {
+	if merged, ok := m.mergeObject(current, desired, key); ok {
+		return merged
+	}
+	if sameTokens(rawTokens(string(current)), rawTokens(string(desired))) || m.keeps(rawTokens(string(current)), rawTokens(string(desired)), key) {
+		return current
+	}
+	return desired
+}
+
+// recorded reports whether the last run wrote the entry at key or anything
+// below it.
+func (m *bodyMerger) recorded(key string) bool {
+	return recordedBelow(m.previous, key)
+}
+
+// recordedBelow reports whether previous holds key or a key below it.
+func recordedBelow(previous map[string]string, key string) bool {
+	for recorded := range previous {
+		if recorded == key || strings.HasPrefix(recorded, key+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// expressionKey returns tokens formatted, so expressions that only differ in
+// whitespace compare equal.
+func expressionKey(tokens hclwrite.Tokens) string {
+	return string(bytes.TrimSpace(hclwrite.Format(tokens.Bytes())))
+}
+
+// isLiteral reports whether tokens form an expression without references or
+// function calls.
+func isLiteral(tokens hclwrite.Tokens) bool {
+	_, ok := literalValue(tokens)
+	return ok
+}
+
+// generatorOwned reports whether tokens form an expression the generator
+// writes: a literal, or literals combined with references to the azuread
+// data sources it declares, such as [data.azuread_group.admins.id].
+func generatorOwned(tokens hclwrite.Tokens) bool {
+	if isLiteral(tokens) {
+		return true
+	}
+	expr, diags := hclsyntax.ParseExpression(tokens.Bytes(), "", hcl.InitialPos)
+	return !diags.HasErrors() && ownedExpr(expr)
+}
+
+func ownedExpr(expr hclsyntax.Expression) bool {
+	switch e := expr.(type) {
+	case *hclsyntax.LiteralValueExpr:
+		return true
+	case *hclsyntax.ScopeTraversalExpr:
+		return isDataSourceReference(e.Traversal)
+	case *hclsyntax.TemplateWrapExpr:
+		return ownedExpr(e.Wrapped)
+	case *hclsyntax.TemplateExpr:
+		return allOwned(e.Parts)
+	case *hclsyntax.TupleConsExpr:
+		return allOwned(e.Exprs)
+	case *hclsyntax.ObjectConsExpr:
+		for _, item := range e.Items {
+			if hcl.ExprAsKeyword(item.KeyExpr) == "" && !ownedExpr(item.KeyExpr) || !ownedExpr(item.ValueExpr) {
+				return false
+			}
+		}
+		return true
+	case *hclsyntax.ObjectConsKeyExpr:
+		return ownedExpr(e.Wrapped)
+	}
+	return false
+}
+
+func allOwned(exprs []hclsyntax.Expression) bool {
+	for _, expr := range exprs {
+		if !ownedExpr(expr) {
+			return false
+		}
+	}
+	return true
+}
+
+// isDataSourceReference reports whether traversal refers to an azuread data
+// source, e.g. data.azuread_user.breakglass.id.
+func isDataSourceReference(traversal hcl.Traversal) bool {
+	if traversal.RootName() != "data" || len(traversal) < 3 {
+		return false
+	}
+	kind, ok := traversal[1].(hcl.TraverseAttr)
+	return ok && strings.HasPrefix(kind.Name, "azuread_")
+}
+
+// blockID identifies a top-level block: by type and labels, or for import,
+// moved and removed blocks by the address they refer to.
+func blockID(block *hclwrite.Block) string {
+	if attribute, ok := blockAddressAttributes[block.Type()]; ok {
+		return block.Type() + " " + expressionText(block.Body().GetAttribute(attribute))
+	}
+	return blockKey(block)
+}
+
+// blockAddressAttributes names the attribute that identifies a top-level
+// block type that has no labels.
+var blockAddressAttributes = map[string]string{
+	"import":  "to",
+	"moved":   "from",
+	"removed": "from",
+}
+
+// matchingBlock returns the block in body that corresponds to desired. Blocks
+// with labels are matched by type and labels; import, moved and removed
+// blocks are matched by the address they refer to.
+func matchingBlock(body *hclwrite.Body, desired *hclwrite.Block) *hclwrite.Block {
+	attribute, ok := blockAddressAttributes[desired.Type()]
+	if !ok {
+		return body.FirstMatchingBlock(desired.Type(), desired.Labels())
+	}
+	address := expressionText(desired.Body().GetAttribute(attribute))
+	for _, block := range body.Blocks() {
+		if block.Type() == desired.Type() && expressionText(block.Body().GetAttribute(attribute)) == address {
+			return block
+		}
+	}
+	return nil
+}
+
+// expressionText returns the source of an attribute's expression without
+// surrounding whitespace, or "" for a missing attribute.
+func expressionText(attribute *hclwrite.Attribute) string {
+	if attribute == nil {
+		return ""
+	}
+	return strings.TrimSpace(string(attribute.Expr().BuildTokens(nil).Bytes()))
+}
+
+func blockKey(block *hclwrite.Block) string {
+	return strings.Join(append([]string{block.Type()}, block.Labels()...), " ")
+}
+
+// attributeOrder returns the names of the attributes directly in body in the
+// order they appear, which hclwrite's Attributes map does not preserve.
+func attributeOrder(body *hclwrite.Body) []string {
+	var names []string
+	tokens := body.BuildTokens(nil)
+	depth := 0
+	for i, token := range tokens {
+		switch token.Type {
+		case hclsyntax.TokenOBrace, hclsyntax.TokenOBrack, hclsyntax.TokenOParen, hclsyntax.TokenTemplateInterp:
+			depth++
+		case hclsyntax.TokenCBrace, hclsyntax.TokenCBrack, hclsyntax.TokenCParen, hclsyntax.TokenTemplateSeqEnd:
+			depth--
+		case hclsyntax.TokenIdent:
+			if depth == 0 && i+1 < len(tokens) && tokens[i+1].Type == hclsyntax.TokenEqual && (i == 0 || tokens[i-1].Type == hclsyntax.TokenNewline) {
+				names = append(names, string(token.Bytes))
+			}
+		}
+	}
+	return names
+}
+
+// sameTokens reports whether two expressions are equal once formatted, so
+// differences in whitespace alone do not count as a change.
+func sameTokens(a, b hclwrite.Tokens) bool {
+	return bytes.Equal(bytes.TrimSpace(hclwrite.Format(a.Bytes())), bytes.TrimSpace(hclwrite.Format(b.Bytes())))
+}
@@ -0,0 +1,183 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func desiredPolicyFile(state, name string) *hclwrite.File {
+	f := hclwrite.NewEmptyFile()
+	body := f.Body().AppendNewBlock("resource", []string{azureADPolicyResourceType, "mfa"}).Body()
+	body.SetAttributeValue("display_name", cty.StringVal(name))
+	body.SetAttributeValue("state", cty.StringVal(state))
+	return f
+}
+
+// mergeTwice generates the policy with the first state and name, replaces
+// the state attribute by edit, and merges the policy with the second state
+// and name.
+func mergeTwice(t *testing.T, edit, state string) string {
+	t.Helper()
+	out := NewMemoryWriter()
+	values := (&policyManifest{}).attributeValues("gen")
+	known := func(string) bool { return true }
+	path := "gen/mfa.tf"
+
+	if _, err := writeMergedFile(out, path, desiredPolicyFile("enabled", "MFA"), known, values); err != nil {
+		t.Fatal(err)
+	}
+	src, _ := out.ReadFile(path)
+	edited := strings.Replace(string(src), `state        = "enabled"`, edit, 1)
+	if edited == string(src) {
+		t.Fatalf("edit did not apply to\n%s", src)
+	}
+	out.WriteFile(path, []byte(edited))
+
+	if _, err := writeMergedFile(out, path, desiredPolicyFile(state, "MFA v2"), known, values); err != nil {
+		t.Fatal(err)
+	}
+	src, _ = out.ReadFile(path)
+	if !strings.Contains(string(src), `"MFA v2"`) {
+		t.Errorf("unedited attribute was not updated:\n%s", src)
+	}
+	return string(src)
+}
+
+func TestMergeKeepsReferenceWhenTenantValueUnchanged(t *testing.T) {
+	got := mergeTwice(t, `state        = var.mfa_state # toggled per env`, "enabled")
+	if !strings.Contains(got, "state        = var.mfa_state # toggled per env") {
+		t.Errorf("hand-written reference was replaced:\n%s", got)
+	}
+}
+
+func TestMergeNeverReplacesReference(t *testing.T) {
+	got := mergeTwice(t, `state        = var.mfa_state`, "disabled")
+	if !strings.Contains(got, "var.mfa_state") {
+		t.Errorf("hand-written reference was replaced:\n%s", got)
+	}
+}
+
+func TestMergeKeepsLiteralEditUntilTenantValueChanges(t *testing.T) {
+	got := mergeTwice(t, `state        = "enabledForReportingButNotEnforced"`, "enabled")
+	if !strings.Contains(got, `"enabledForReportingButNotEnforced"`) {
+		t.Errorf("hand-edited literal was replaced although the tenant value is unchanged:\n%s", got)
+	}
+
+	got = mergeTwice(t, `state        = "enabledForReportingButNotEnforced"`, "disabled")
+	if !strings.Contains(got, `state        = "disabled"`) {
+		t.Errorf("hand-edited literal was kept although the tenant value changed:\n%s", got)
+	}
+}
+
+func TestMergeUpdatesUneditedAttribute(t *testing.T) {
+	got := mergeTwice(t, `state        = "enabled" # reviewed`, "disabled")
+	if !strings.Contains(got, `state        = "disabled" # reviewed`) {
+		t.Errorf("attribute was not updated to the tenant value:\n%s", got)
+	}
+}
+
+func TestMergeWithoutRecordedValuesReplacesGeneratedReferences(t *testing.T) {
+	out := NewMemoryWriter()
+	path := "gen/mfa.tf"
+	out.WriteFile(path, []byte(`resource "azuread_conditional_access_policy" "mfa" {
+  display_name = "MFA"
+  state        = var.mfa_state
+  conditions {
+    users {
+      included_groups = [data.azuread_group.pilot.id]
+    }
+  }
+}
+`))
+
+	desired := desiredPolicyFile("enabled", "MFA")
+	users := desired.Body().Blocks()[0].Body().AppendNewBlock("conditions", nil).Body().AppendNewBlock("users", nil).Body()
+	users.SetAttributeRaw("included_groups", rawTokens("[data.azuread_group.all_staff.id]"))
+	values := (&policyManifest{}).attributeValues("gen")
+	if _, err := writeMergedFile(out, path, desired, func(string) bool { return true }, values); err != nil {
+		t.Fatal(err)
+	}
+
+	src, _ := out.ReadFile(path)
+	if !strings.Contains(string(src), "[data.azuread_group.all_staff.id]") {
+		t.Errorf("generated data source reference was not updated:\n%s", src)
+	}
+	if !strings.Contains(string(src), "var.mfa_state") {
+		t.Errorf("hand-written reference was replaced:\n%s", src)
+	}
+}
+
+func TestGeneratorOwned(t *testing.T) {
+	for expr, want := range map[string]bool{
+		`"enabled"`:                            true,
+		`["All"]`:                              true,
+		`[data.azuread_group.pilot.id, "abc"]`: true,
+		`{ id = data.azuread_user.admin.id }`:  true,
+		`var.mfa_state`:                        false,
+		`[local.groups.pilot]`:                 false,
+		`[data.external.groups.id]`:            false,
+		`upper("enabled")`:                     false,
+		`"${var.prefix}-mfa"`:                  false,
+	} {
+		if got := generatorOwned(rawTokens(expr)); got != want {
+			t.Errorf("generatorOwned(%s) = %v, want %v", expr, got, want)
+		}
+	}
+}
+
+func TestMergeModuleMapEntryByEntry(t *testing.T) {
+	out := NewMemoryWriter()
+	cfg := testConfig(modeModule)
+	if _, err := generate(out, cfg, testPolicy("a1", "Require MFA"), testPolicy("b2", "Other"), testPolicy("c3", "Pilot")); err != nil {
+		t.Fatal(err)
+	}
+	path := "gen/" + policyModuleFileName
+	src, _ := out.ReadFile(path)
+	edited := strings.Replace(string(src), `"other" = {`, `"other" = {
+      # reviewed by secops`, 1)
+	edited = strings.Replace(edited, `display_name = "Pilot"
+      state        = "enabled"`, `display_name = "Pilot"
+      state        = var.pilot_state`, 1)
+	if edited == string(src) {
+		t.Fatalf("edits did not apply to\n%s", src)
+	}
+	out.WriteFile(path, []byte(edited))
+
+	disabled := models.DISABLED_CONDITIONALACCESSPOLICYSTATE
+	requireMFA, pilot := testPolicy("a1", "Require MFA"), testPolicy("c3", "Pilot")
+	requireMFA.SetState(&disabled)
+	pilot.SetState(&disabled)
+	var log bytes.Buffer
+	g := &Generator{
+		Config:    cfg,
+		Source:    fakeSource{requireMFA, testPolicy("b2", "Other"), pilot},
+		Directory: testDirectory,
+		Output:    out,
+		TenantID:  "contoso.onmicrosoft.com",
+		Log:       &log,
+	}
+	if _, err := g.Generate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	src, _ = out.ReadFile(path)
+	if !strings.Contains(string(src), `display_name = "Require MFA"
+      state        = "disabled"`) {
+		t.Errorf("tenant change of an unedited entry was not applied:\n%s", src)
+	}
+	if !strings.Contains(string(src), "# reviewed by secops") {
+		t.Errorf("comment in another entry was lost:\n%s", src)
+	}
+	if !strings.Contains(string(src), "var.pilot_state") {
+		t.Errorf("hand-written reference was replaced:\n%s", src)
+	}
+	if !strings.Contains(log.String(), `locals:ca_policies.pilot.state`) {
+		t.Errorf("no warning for the kept edit hiding a tenant change in:\n%s", log.String())
+	}
+}
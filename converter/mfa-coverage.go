@@ -0,0 +1,279 @@
+package converter
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+)
+
+// coverageEntry is the MFA coverage of one role, group or user. Policies
+// lists the MFA policies covering it and ExcludedBy those excluding it.
+type coverageEntry struct {
+	Kind       string   `json:"kind"`
+	ID         string   `json:"id"`
+	Name       string   `json:"name,omitempty"`
+	Covered    bool     `json:"covered"`
+	Policies   []string `json:"policies,omitempty"`
+	ExcludedBy []string `json:"excluded_by,omitempty"`
+}
+
+func (c coverageEntry) label() string {
+	if c.Name != "" {
+		return c.Kind + " " + c.Name
+	}
+	return c.Kind + " " + c.ID
+}
+
+// mfaCoverage is the result of the coverage analysis.
+type mfaCoverage struct {
+	// MFAPolicies are the enabled policies requiring MFA or an
+	// authentication strength for all cloud apps.
+	MFAPolicies []string        `json:"mfa_policies"`
+	Roles       []coverageEntry `json:"roles"`
+	Groups      []coverageEntry `json:"groups"`
+	// ExcludedEverywhere are the users, groups and roles some MFA policy
+	// excludes and no MFA policy covers once its exclusions are applied.
+	// Membership is not expanded, so a user included only through a group
+	// or role counts as not covered.
+	ExcludedEverywhere []coverageEntry `json:"excluded_from_every_policy"`
+}
+
+// gaps reports whether any role or group is not covered or any principal is
+// excluded from every MFA policy.
+func (c *mfaCoverage) gaps() bool {
+	for _, entries := range [][]coverageEntry{c.Roles, c.Groups} {
+		for _, entry := range entries {
+			if !entry.Covered {
+				return true
+			}
+		}
+	}
+	return len(c.ExcludedEverywhere) > 0
+}
+
+// requiresMFA reports whether p is an enabled policy for all cloud apps whose
+// grant cannot be satisfied without MFA or an authentication strength. With
+// the OR operator that is only the case when it is the only control.
+func requiresMFA(p *caPolicy) bool {
+	grant := p.Grant
+	if p.State != "enabled" || !appliesToAllApps(p) || grant == nil {
+		return false
+	}
+	if !contains(grant.BuiltInControls, "mfa") && grant.AuthenticationStrengthPolicyID == "" {
+		return false
+	}
+	return grant.Operator == "AND" || len(grantControlLabels(grant)) == 1
+}
+
+// analyzeMFACoverage checks, for each of roles and each group policies refer
+// to, whether an MFA policy includes it without excluding it. Group
+// membership is not expanded, so a group is only covered through All users
+// or by being included itself.
+func analyzeMFACoverage(policies []*caPolicy, roles map[string]string) *mfaCoverage {
+	sorted := append([]*caPolicy(nil), policies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].DisplayName < sorted[j].DisplayName })
+
+	coverage := &mfaCoverage{MFAPolicies: []string{}, ExcludedEverywhere: []coverageEntry{}}
+	var mfaPolicies []*caPolicy
+	roles = copyMap(roles)
+	groups := map[string]string{}
+	for _, p := range sorted {
+		users := &p.Conditions.Users
+		for _, refs := range [][]principalRef{users.IncludeGroups, users.ExcludeGroups} {
+			for _, ref := range refs {
+				groups[ref.ID] = ref.Name
+			}
+		}
+		for _, ids := range [][]string{users.IncludeRoles, users.ExcludeRoles} {
+			for _, id := range ids {
+				if _, ok := roles[id]; !ok {
+					roles[id] = privilegedRoles[id]
+				}
+			}
+		}
+		if requiresMFA(p) {
+			mfaPolicies = append(mfaPolicies, p)
+			coverage.MFAPolicies = append(coverage.MFAPolicies, p.DisplayName)
+		}
+	}
+
+	entry := func(kind, id, name string, included, excluded func(users *caUsers) bool) coverageEntry {
+		e := coverageEntry{Kind: kind, ID: id, Name: name}
+		for _, p := range mfaPolicies {
+			users := &p.Conditions.Users
+			switch {
+			case excluded(users):
+				e.ExcludedBy = append(e.ExcludedBy, p.DisplayName)
+			case includesAll(users.IncludeUsers) || included(users):
+				e.Policies = append(e.Policies, p.DisplayName)
+			}
+		}
+		e.Covered = len(e.Policies) > 0
+		return e
+	}
+	for id, name := range roles {
+		coverage.Roles = append(coverage.Roles, entry("role", id, name,
+			func(users *caUsers) bool { return contains(users.IncludeRoles, id) },
+			func(users *caUsers) bool { return contains(users.ExcludeRoles, id) }))
+	}
+	for id, name := range groups {
+		coverage.Groups = append(coverage.Groups, entry("group", id, name,
+			func(users *caUsers) bool { return containsRef(users.IncludeGroups, id) },
+			func(users *caUsers) bool { return containsRef(users.ExcludeGroups, id) }))
+	}
+	sortCoverage(coverage.Roles)
+	sortCoverage(coverage.Groups)
+
+	// a principal some MFA policy excludes is excluded everywhere when no
+	// MFA policy covers it once the exclusions are applied
+	seen := map[string]bool{}
+	addExcluded := func(e coverageEntry) {
+		if key := e.Kind + "/" + e.ID; !seen[key] && !e.Covered {
+			seen[key] = true
+			coverage.ExcludedEverywhere = append(coverage.ExcludedEverywhere, e)
+		}
+	}
+	for _, p := range mfaPolicies {
+		users := &p.Conditions.Users
+		for _, ref := range users.ExcludeUsers {
+			id := ref.ID
+			addExcluded(entry("user", id, ref.Name,
+				func(users *caUsers) bool { return containsRef(users.IncludeUsers, id) },
+				func(users *caUsers) bool { return containsRef(users.ExcludeUsers, id) }))
+		}
+		for _, ref := range users.ExcludeGroups {
+			id := ref.ID
+			addExcluded(entry("group", id, ref.Name,
+				func(users *caUsers) bool { return containsRef(users.IncludeGroups, id) },
+				func(users *caUsers) bool { return containsRef(users.ExcludeGroups, id) }))
+		}
+		for _, id := range users.ExcludeRoles {
+			addExcluded(entry("role", id, roles[id],
+				func(users *caUsers) bool { return contains(users.IncludeRoles, id) },
+				func(users *caUsers) bool { return contains(users.ExcludeRoles, id) }))
+		}
+	}
+	sortCoverage(coverage.ExcludedEverywhere)
+	return coverage
+}
+
+func containsRef(refs []principalRef, id string) bool {
+	for _, ref := range refs {
+		if ref.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func copyMap(m map[string]string) map[string]string {
+	copied := make(map[string]string, len(m))
+	for key, value := range m {
+		copied[key] = value
+	}
+	return copied
+}
+
+func sortCoverage(entries []coverageEntry) {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].label() < entries[j].label() })
+}
+
+// RunCoverage reports the MFA coverage of the directory roles and of the
+// groups policies refer to and returns the process exit code: 0 without
+// gaps, 2 when there are gaps and 1 on errors.
+func RunCoverage(args []string) int {
+	ctx := context.Background()
+
+	fs := flag.NewFlagSet("coverage", flag.ExitOnError)
+	common := addCommonFlags(fs)
+	source := addPolicySourceFlags(fs)
+	format := fs.String("format", "text", "output format: text or json")
+	fs.Parse(args)
+
+	cfg, _, err := common.load()
+	if err != nil {
+		log.Printf("error loading config: %v", err)
+		return 1
+	}
+	policies, directory, err := source.load(ctx, cfg, fs.Args())
+	if err != nil {
+		log.Printf("error getting policies: %v", err)
+		return 1
+	}
+	normalized, err := normalizePolicies(policies, directory)
+	if err != nil {
+		log.Printf("error reading policies: %v", err)
+		return 1
+	}
+	roles, err := directory.directoryRoles()
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	coverage := analyzeMFACoverage(normalized, roles)
+
+	switch *format {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(coverage)
+	case "text":
+		writeCoverageText(os.Stdout, coverage)
+	default:
+		log.Printf("unknown format %q, expected text or json", *format)
+		return 1
+	}
+	if err != nil {
+		log.Printf("error writing report: %v", err)
+		return 1
+	}
+	if coverage.gaps() {
+		return 2
+	}
+	return 0
+}
+
+func writeCoverageText(w io.Writer, coverage *mfaCoverage) {
+	fmt.Fprintln(w, "MFA policies:")
+	if len(coverage.MFAPolicies) == 0 {
+		fmt.Fprintln(w, "  (none)")
+	}
+	for _, name := range coverage.MFAPolicies {
+		fmt.Fprintf(w, "  - %s\n", name)
+	}
+	for _, section := range []struct {
+		title   string
+		entries []coverageEntry
+	}{
+		{"Roles", coverage.Roles},
+		{"Groups", coverage.Groups},
+	} {
+		fmt.Fprintf(w, "\n%s:\n", section.title)
+		if len(section.entries) == 0 {
+			fmt.Fprintln(w, "  (none)")
+		}
+		for _, entry := range section.entries {
+			name := entry.Name
+			if name == "" {
+				name = entry.ID
+			}
+			if entry.Covered {
+				fmt.Fprintf(w, "  covered  %s (%s)\n", name, listText(entry.Policies))
+			} else {
+				fmt.Fprintf(w, "  GAP      %s\n", name)
+			}
+		}
+	}
+	fmt.Fprintln(w, "\nExcluded from every MFA policy:")
+	if len(coverage.ExcludedEverywhere) == 0 {
+		fmt.Fprintln(w, "  (none)")
+	}
+	for _, entry := range coverage.ExcludedEverywhere {
+		fmt.Fprintf(w, "  - %s\n", entry.label())
+	}
+}
@@ -0,0 +1,93 @@
+package converter
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAnalyzeMFACoverage(t *testing.T) {
+	const globalAdmin = "62e90394-69f5-4237-9190-012177145e10"
+	roles := map[string]string{globalAdmin: "Global Administrator"}
+	allUsers := func(p *caPolicy) {
+		p.Conditions.Users.IncludeUsers = []principalRef{{ID: "All"}}
+		p.Conditions.Users.IncludeGroups = nil
+	}
+	admins := func(p *caPolicy) {
+		p.Conditions.Users.IncludeGroups = nil
+		p.Conditions.Users.ExcludeUsers = nil
+		p.Conditions.Users.IncludeRoles = []string{globalAdmin}
+	}
+	for _, tc := range []struct {
+		name string
+		// edits change the second policy, "B", of a pair of copies of
+		// testPolicy; first edits the first, "A"
+		first, edits []func(p *caPolicy)
+		// uncovered are the roles and groups with a gap, excluded the
+		// principals excluded from every policy
+		uncovered, excluded []string
+	}{
+		{
+			name:     "break glass excluded from all users and not mentioned by the admin policy",
+			first:    []func(p *caPolicy){allUsers},
+			edits:    []func(p *caPolicy){admins},
+			excluded: []string{"user breakglass@contoso.com"},
+		},
+		{
+			name:  "excluded user included by another policy",
+			first: []func(p *caPolicy){allUsers},
+			edits: []func(p *caPolicy){admins, func(p *caPolicy) {
+				p.Conditions.Users.IncludeUsers = []principalRef{{ID: testUserID, Name: "breakglass@contoso.com"}}
+			}},
+		},
+		{
+			name: "excluded group included through all users",
+			first: []func(p *caPolicy){allUsers, func(p *caPolicy) {
+				p.Conditions.Users.ExcludeUsers = nil
+				p.Conditions.Users.ExcludeGroups = []principalRef{{ID: "g2", Name: "Contractors"}}
+			}},
+			edits: []func(p *caPolicy){allUsers},
+		},
+		{
+			name: "role excluded from every policy",
+			first: []func(p *caPolicy){allUsers, func(p *caPolicy) {
+				p.Conditions.Users.ExcludeRoles = []string{globalAdmin}
+			}},
+			edits:     []func(p *caPolicy){allUsers, func(p *caPolicy) { p.Conditions.Users.ExcludeRoles = []string{globalAdmin} }},
+			uncovered: []string{"role Global Administrator"},
+			excluded:  []string{"role Global Administrator", "user breakglass@contoso.com"},
+		},
+		{
+			name:      "policies without MFA do not cover",
+			first:     []func(p *caPolicy){allUsers, func(p *caPolicy) { p.State = "disabled" }},
+			edits:     []func(p *caPolicy){func(p *caPolicy) { p.Grant.BuiltInControls = []string{"compliantDevice"} }},
+			uncovered: []string{"group CA Pilot", "role Global Administrator"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			a, b := testCAPolicy("a1", "A"), testCAPolicy("b2", "B")
+			for _, edit := range tc.first {
+				edit(a)
+			}
+			for _, edit := range tc.edits {
+				edit(b)
+			}
+			coverage := analyzeMFACoverage([]*caPolicy{a, b}, roles)
+
+			var uncovered, excluded []string
+			for _, entry := range append(coverage.Groups, coverage.Roles...) {
+				if !entry.Covered {
+					uncovered = append(uncovered, entry.label())
+				}
+			}
+			for _, entry := range coverage.ExcludedEverywhere {
+				excluded = append(excluded, entry.label())
+			}
+			if !reflect.DeepEqual(uncovered, tc.uncovered) {
+				t.Errorf("gaps = %v, want %v", uncovered, tc.uncovered)
+			}
+			if !reflect.DeepEqual(excluded, tc.excluded) {
+				t.Errorf("excluded from every policy = %v, want %v", excluded, tc.excluded)
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+package converter
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// Resource types policies can be written as in the resources mode.
+const (
+	// policyResourceAzureAD writes azuread_conditional_access_policy
+	// resources.
+	policyResourceAzureAD = "azuread"
+	// policyResourceMSGraph writes msgraph_resource resources of the
+	// microsoft/msgraph provider, whose body mirrors the Graph JSON.
+	policyResourceMSGraph = "msgraph"
+	// policyResourceAuto writes azuread resources, falling back to
+	// msgraph_resource for policies the azuread schema cannot express.
+	policyResourceAuto = "auto"
+)
+
+const (
+	azureADPolicyResourceType = "azuread_conditional_access_policy"
+	msgraphResourceType       = "msgraph_resource"
+
+	// msgraphPoliciesURL is the collection msgraph_resource creates policies
+	// in, relative to the Graph API version.
+	msgraphPoliciesURL = "identity/conditionalAccess/policies"
+
+	defaultMSGraphProviderVersion = "~> 0.1"
+)
+
+// graphReferenceFields maps the Graph JSON fields of a policy holding object
+// IDs onto the kind of object they refer to.
+var graphReferenceFields = map[string]dataSourceKind{
+	"conditions.users.includeUsers":         userDataSource,
+	"conditions.users.excludeUsers":         userDataSource,
+	"conditions.users.includeGroups":        groupDataSource,
+	"conditions.users.excludeGroups":        groupDataSource,
+	"conditions.locations.includeLocations": namedLocationDataSource,
+	"conditions.locations.excludeLocations": namedLocationDataSource,
+}
+
+// resourceAddress returns the address of the resource with the given type and
+// name.
+func resourceAddress(resourceType, resourceName string) hcl.Traversal {
+	return hcl.Traversal{
+		hcl.TraverseRoot{Name: resourceType},
+		hcl.TraverseAttr{Name: resourceName},
+	}
+}
+
+// policyImportID returns the ID a policy resource of the given type is
+// imported by. msgraph_resource takes the URL of the policy.
+func policyImportID(resourceType, policyID string) string {
+	if resourceType == msgraphResourceType {
+		return msgraphPoliciesURL + "/" + policyID
+	}
+	return policyID
+}
+
+// isMSGraphResourcePath reports whether path is an attribute the generator
+// writes in a msgraph_resource block.
+func isMSGraphResourcePath(path string) bool {
+	return path == "url" || path == "body"
+}
+
+// renderMSGraphPolicyFile renders policy as a msgraph_resource with the given
+// label whose body is the Graph JSON of the policy written as an HCL object.
+// Users, groups and named locations resolved in p are referred to through the
+// same data sources as the azuread resources, and the other objects by the
+// IDs in p, which are those of the target tenant for a promoted policy.
+func renderMSGraphPolicyFile(p *caPolicy, resourceName string, policy *models.ConditionalAccessPolicy) (*hclwrite.File, []dataSourceRef, error) {
+	body, err := policyGraphBody(policy)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error serializing policy %q: %v", p.DisplayName, err)
+	}
+	setGraphObjectIDs(body, p)
+
+	r := &msgraphRenderer{names: resolvedNames(p)}
+
+	f := hclwrite.NewEmptyFile()
+	rootBody := f.Body()
+
+	resourceBlock := rootBody.AppendNewBlock("resource", []string{msgraphResourceType, resourceName})
+	resourceBody := resourceBlock.Body()
+	resourceBody.SetAttributeValue("url", cty.StringVal(msgraphPoliciesURL))
+	resourceBody.SetAttributeRaw("body", r.valueTokens("", body))
+
+	// the provider imports a resource by its URL
+	if p.ID != "" {
+		rootBody.AppendNewline()
+		importBlock := rootBody.AppendNewBlock("import", nil)
+		importBlock.Body().SetAttributeTraversal("to", resourceAddress(msgraphResourceType, resourceName))
+		importBlock.Body().SetAttributeValue("id", cty.StringVal(policyImportID(msgraphResourceType, p.ID)))
+	}
+	return f, r.dataSources, nil
+}
+
+// setGraphObjectIDs replaces the terms of use, authentication strength,
+// service principal and external tenant IDs in the Graph JSON body with those
+// of p.
+func setGraphObjectIDs(body map[string]any, p *caPolicy) {
+	if grant := graphObject(body, "grantControls"); grant != nil && p.Grant != nil {
+		setGraphList(grant, "termsOfUse", p.Grant.TermsOfUse)
+		if strength := graphObject(grant, "authenticationStrength"); strength != nil {
+			strength["id"] = p.Grant.AuthenticationStrengthPolicyID
+		}
+	}
+	if apps := graphObject(body, "conditions", "clientApplications"); apps != nil && p.Conditions.ClientApplications != nil {
+		setGraphList(apps, "includeServicePrincipals", p.Conditions.ClientApplications.IncludeServicePrincipals)
+		setGraphList(apps, "excludeServicePrincipals", p.Conditions.ClientApplications.ExcludeServicePrincipals)
+	}
+	for key, guests := range map[string]*caGuestsOrExternalUsers{
+		"includeGuestsOrExternalUsers": p.Conditions.Users.IncludeGuests,
+		"excludeGuestsOrExternalUsers": p.Conditions.Users.ExcludeGuests,
+	} {
+		if tenants := graphObject(body, "conditions", "users", key, "externalTenants"); tenants != nil && guests != nil && guests.ExternalTenants != nil {
+			setGraphList(tenants, "members", guests.ExternalTenants.Members)
+		}
+	}
+}
+
+// graphObject returns the object at the given keys of body, or nil.
+func graphObject(body map[string]any, keys ...string) map[string]any {
+	for _, key := range keys {
+		child, ok := body[key].(map[string]any)
+		if !ok {
+			return nil
+		}
+		body = child
+	}
+	return body
+}
+
+// setGraphList replaces the list at key of object with values, if it is
+// set.
+func setGraphList(object map[string]any, key string, values []string) {
+	if _, ok := object[key].([]any); !ok {
+		return
+	}
+	list := make([]any, len(values))
+	for i, value := range values {
+		list[i] = value
+	}
+	object[key] = list
+}
+
+// msgraphRenderer converts decoded Graph JSON into HCL expressions.
+type msgraphRenderer struct {
+	// names maps the IDs of resolved objects onto their names.
+	names       map[string]string
+	dataSources []dataSourceRef
+}
+
+// resolvedNames maps the IDs of the users, groups and named locations p
+// refers to onto their names, leaving out those that were not resolved.
+func resolvedNames(p *caPolicy) map[string]string {
+	names := map[string]string{}
+	users := &p.Conditions.Users
+	lists := [][]principalRef{users.IncludeUsers, users.ExcludeUsers, users.IncludeGroups, users.ExcludeGroups}
+	if locations := p.Conditions.Locations; locations != nil {
+		lists = append(lists, locations.IncludeLocations, locations.ExcludeLocations)
+	}
+	for _, refs := range lists {
+		for _, ref := range refs {
+			if ref.Name != "" {
+				names[ref.ID] = ref.Name
+			}
+		}
+	}
+	return names
+}
+
+// valueTokens renders value, found at the dotted JSON path, as an HCL
+// expression. Object keys are written in sorted order and null properties are
+// left out.
+func (r *msgraphRenderer) valueTokens(path string, value any) hclwrite.Tokens {
+	switch v := value.(type) {
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		var entries []hclwrite.Tokens
+		for _, key := range keys {
+			if v[key] == nil {
+				continue
+			}
+			entry := objectKeyTokens(key)
+			entry = append(entry, &hclwrite.Token{Type: hclsyntax.TokenEqual, Bytes: []byte{'='}})
+			childPath := key
+			if path != "" {
+				childPath = path + "." + key
+			}
+			entries = append(entries, append(entry, r.valueTokens(childPath, v[key])...))
+		}
+		return objectTokens(entries)
+	case []any:
+		elements := make([]hclwrite.Tokens, len(v))
+		kind, isReference := graphReferenceFields[path]
+		for i, element := range v {
+			if id, ok := element.(string); ok && isReference && r.names[id] != "" {
+				elements[i] = r.referenceTokens(kind, r.names[id])
+				continue
+			}
+			elements[i] = r.valueTokens(path, element)
+		}
+		return listTokens(elements)
+	case string:
+		return hclwrite.TokensForValue(cty.StringVal(v))
+	case bool:
+		return hclwrite.TokensForValue(cty.BoolVal(v))
+	case json.Number:
+		return rawTokens(v.String())
+	}
+	return rawTokens("null")
+}
+
+// referenceTokens refers to the ID of the named object through a data source
+// of the given kind.
+func (r *msgraphRenderer) referenceTokens(kind dataSourceKind, name string) hclwrite.Tokens {
+	r.dataSources = append(r.dataSources, dataSourceRef{kind: kind, name: name})
+	return rawTokens(fmt.Sprintf("data.%s.%s.id", kind.dataType, kind.label(name)))
+}
+
+// objectKeyTokens writes key as an identifier, or quoted when it is not a
+// valid one, as with "@odata.type".
+func objectKeyTokens(key string) hclwrite.Tokens {
+	if hclsyntax.ValidIdentifier(key) {
+		return rawTokens(key)
+	}
+	return hclwrite.TokensForValue(cty.StringVal(key))
+}
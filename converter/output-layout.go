@@ -0,0 +1,90 @@
+package converter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Layout modes deciding which file each policy is written to.
+const (
+	// layoutPerPolicy writes every policy to a file named after it.
+	layoutPerPolicy = "per-policy"
+	// layoutSingle writes all policies to policies.tf.
+	layoutSingle = "single"
+	// layoutByState groups policies by state, e.g. policies_enabled.tf.
+	layoutByState = "by-state"
+	// layoutByPrefix groups policies by the persona prefix of their display
+	// name, e.g. policies_admins.tf for "CA100-Admins-RequireMFA".
+	layoutByPrefix = "by-prefix"
+)
+
+const defaultOutputDir = "generated"
+
+// defaultPrefixPattern captures the persona of display names following the
+// common "CA<number>-<persona>-<description>" convention, with or without the
+// number.
+const defaultPrefixPattern = `^(?:CA\d+\s*-\s*)?([^-]+?)\s*-`
+
+// outputLayout maps policies to the files they are generated in.
+type outputLayout struct {
+	mode   string
+	prefix *regexp.Regexp
+}
+
+func newOutputLayout(mode, prefixPattern string) (*outputLayout, error) {
+	switch mode {
+	case layoutPerPolicy, layoutSingle, layoutByState, layoutByPrefix:
+	default:
+		return nil, fmt.Errorf("unknown layout %q, expected %s, %s, %s or %s", mode, layoutPerPolicy, layoutSingle, layoutByState, layoutByPrefix)
+	}
+
+	if prefixPattern == "" {
+		prefixPattern = defaultPrefixPattern
+	}
+	prefix, err := regexp.Compile(prefixPattern)
+	if err != nil {
+		return nil, fmt.Errorf("error compiling layout prefix pattern: %v", err)
+	}
+	if prefix.NumSubexp() < 1 {
+		return nil, fmt.Errorf("layout prefix pattern %q needs a capture group", prefixPattern)
+	}
+
+	return &outputLayout{mode: mode, prefix: prefix}, nil
+}
+
+// fileName returns the name of the file p is generated in with the given
+// resource label. A file of its own carries the suffix assignLabels added to
+// the label, so policies whose names differ only in case do not share a file
+// on case-insensitive file systems.
+func (l *outputLayout) fileName(p *caPolicy, label string) string {
+	switch l.mode {
+	case layoutSingle:
+		return "policies.tf"
+	case layoutByState:
+		return fmt.Sprintf("policies_%s.tf", fileNamePart(p.State))
+	case layoutByPrefix:
+		group := "other"
+		if match := l.prefix.FindStringSubmatch(p.DisplayName); match != nil && match[1] != "" {
+			group = match[1]
+		}
+		return fmt.Sprintf("policies_%s.tf", fileNamePart(group))
+	}
+	return fmt.Sprintf("%s%s.tf", p.DisplayName, strings.TrimPrefix(label, policyResourceName(p.DisplayName)))
+}
+
+// fileNamePart lowercases value and replaces anything but letters and digits
+// with underscores.
+func fileNamePart(value string) string {
+	return strings.Trim(nonFileNameChars.ReplaceAllString(strings.ToLower(value), "_"), "_")
+}
+
+var nonFileNameChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// dataFileName returns the file the data sources of kind are written to.
+func dataFileName(kind dataSourceKind, split bool) string {
+	if !split {
+		return "data.tf"
+	}
+	return "data_" + strings.TrimPrefix(kind.dataType, "azuread_") + "s.tf"
+}
@@ -0,0 +1,104 @@
+package converter
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// OutputWriter stores the files the generator writes. The generator reads
+// the files it wrote before to merge into them, so hand-written changes
+// survive regeneration.
+type OutputWriter interface {
+	// ReadFile returns the content of the file at path, or an error wrapping
+	// fs.ErrNotExist when there is none.
+	ReadFile(path string) ([]byte, error)
+	// WriteFile creates or replaces the file at path, creating its
+	// directory as needed.
+	WriteFile(path string, content []byte) error
+	// Remove deletes the file at path.
+	Remove(path string) error
+	// Glob returns the paths of the files matching pattern, with the syntax
+	// of filepath.Match, in sorted order.
+	Glob(pattern string) ([]string, error)
+}
+
+// DiskWriter writes files to the local file system.
+type DiskWriter struct{}
+
+func (DiskWriter) ReadFile(path string) ([]byte, error) {
+	return os.ReadFile(path)
+}
+
+func (DiskWriter) WriteFile(path string, content []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, content, 0644)
+}
+
+func (DiskWriter) Remove(path string) error {
+	return os.Remove(path)
+}
+
+func (DiskWriter) Glob(pattern string) ([]string, error) {
+	return filepath.Glob(pattern)
+}
+
+// MemoryWriter keeps files in memory by path, e.g. to generate configuration
+// in tests or to post-process it before writing it elsewhere.
+type MemoryWriter struct {
+	mu    sync.Mutex
+	Files map[string][]byte
+}
+
+// NewMemoryWriter returns an empty MemoryWriter.
+func NewMemoryWriter() *MemoryWriter {
+	return &MemoryWriter{Files: map[string][]byte{}}
+}
+
+func (w *MemoryWriter) ReadFile(path string) ([]byte, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	content, ok := w.Files[filepath.Clean(path)]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: path, Err: fs.ErrNotExist}
+	}
+	return append([]byte(nil), content...), nil
+}
+
+func (w *MemoryWriter) WriteFile(path string, content []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.Files[filepath.Clean(path)] = append([]byte(nil), content...)
+	return nil
+}
+
+func (w *MemoryWriter) Remove(path string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.Files[filepath.Clean(path)]; !ok {
+		return &fs.PathError{Op: "remove", Path: path, Err: fs.ErrNotExist}
+	}
+	delete(w.Files, filepath.Clean(path))
+	return nil
+}
+
+func (w *MemoryWriter) Glob(pattern string) ([]string, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var matches []string
+	for path := range w.Files {
+		matched, err := filepath.Match(filepath.Clean(pattern), path)
+		if err != nil {
+			return nil, err
+		}
+		if matched {
+			matches = append(matches, path)
+		}
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
@@ -0,0 +1,242 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"github.com/microsoft/kiota-abstractions-go/serialization"
+	jsonserialization "github.com/microsoft/kiota-serialization-json-go"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+)
+
+// backupDirName is the directory below the output directory holding the
+// JSON backups. Backups of deleted policies are kept.
+const backupDirName = "backup"
+
+// backupReadOnlyFields are the top-level properties of policies and named
+// locations that Graph sets itself and rejects or ignores on create.
+var backupReadOnlyFields = []string{"id", "createdDateTime", "modifiedDateTime", "templateId"}
+
+// backupRefs is the sidecar written next to each backup. It maps the IDs of
+// the objects the policy refers to onto their names at backup time, so they
+// can be found again in a tenant where the IDs differ or after a rename.
+type backupRefs struct {
+	ID             string            `json:"id"`
+	DisplayName    string            `json:"display_name"`
+	TemplateID     string            `json:"template_id,omitempty"`
+	Users          map[string]string `json:"users,omitempty"`
+	Groups         map[string]string `json:"groups,omitempty"`
+	NamedLocations map[string]string `json:"named_locations,omitempty"`
+}
+
+// writePolicyBackups writes a Graph JSON backup and a references sidecar for
+// every policy to dir. The files are named after the display name and ID of
+// the policy, so policies with similar names get separate backups. The
+// backup of a policy written under an earlier name is removed.
+func writePolicyBackups(out OutputWriter, log io.Writer, dir string, policies []models.ConditionalAccessPolicy, directory *directoryCache) error {
+	existing, err := existingBackups(out, dir)
+	if err != nil {
+		return err
+	}
+	written := map[string]string{}
+	for i := range policies {
+		p, err := newCAPolicy(policies[i], directory)
+		if err != nil {
+			return err
+		}
+		body, err := policyBackupJSON(&policies[i])
+		if err != nil {
+			return fmt.Errorf("error serializing policy %q: %v", p.DisplayName, err)
+		}
+		sidecar := newBackupRefs(p)
+		if templateID := policies[i].GetTemplateId(); templateID != nil {
+			sidecar.TemplateID = *templateID
+		}
+		refs, err := json.MarshalIndent(sidecar, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		name := backupFileName(p)
+		if previous, ok := written[name]; ok {
+			return fmt.Errorf("policies %q and %q would both be backed up to %s", previous, p.DisplayName, name+".json")
+		}
+		written[name] = p.DisplayName
+		for _, previous := range existing[p.ID] {
+			if previous == name {
+				continue
+			}
+			for _, path := range []string{filepath.Join(dir, previous+".json"), filepath.Join(dir, previous+".refs.json")} {
+				err := out.Remove(path)
+				if errors.Is(err, fs.ErrNotExist) {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(log, "Removed backup file: %s\n", path)
+			}
+		}
+		files := []struct {
+			path    string
+			content []byte
+		}{
+			{filepath.Join(dir, name+".json"), body},
+			{filepath.Join(dir, name+".refs.json"), append(refs, '\n')},
+		}
+		for _, file := range files {
+			change, err := writeFileIfChanged(out, file.path, file.content)
+			if err != nil {
+				return err
+			}
+			if change != fileUnchanged {
+				fmt.Fprintf(log, "%s backup file: %s\n", change, file.path)
+			}
+		}
+	}
+	return nil
+}
+
+// existingBackups returns the names of the backups in dir, without the
+// extension, by the policy ID recorded in their sidecar.
+func existingBackups(out OutputWriter, dir string) (map[string][]string, error) {
+	paths, err := out.Glob(filepath.Join(dir, "*.refs.json"))
+	if err != nil {
+		return nil, err
+	}
+	backups := map[string][]string{}
+	for _, path := range paths {
+		content, err := out.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var refs backupRefs
+		if err := json.Unmarshal(content, &refs); err != nil {
+			return nil, fmt.Errorf("error parsing %s: %v", path, err)
+		}
+		if refs.ID != "" {
+			backups[refs.ID] = append(backups[refs.ID], strings.TrimSuffix(filepath.Base(path), ".refs.json"))
+		}
+	}
+	return backups, nil
+}
+
+// backupFileName returns the name of the backup files of p without the
+// extension.
+func backupFileName(p *caPolicy) string {
+	name := fileNamePart(p.DisplayName)
+	if p.ID != "" {
+		name += "_" + p.ID
+	}
+	return name
+}
+
+// policyBackupJSON serializes policy as Graph JSON that can be posted to
+// create it again.
+func policyBackupJSON(policy *models.ConditionalAccessPolicy) ([]byte, error) {
+	body, err := policyGraphBody(policy)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.MarshalIndent(body, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// policyGraphBody returns the Graph JSON request body that creates policy:
+// read-only properties and OData annotations are dropped, and the
+// authentication strength is reduced to the reference by ID that Graph
+// accepts.
+func policyGraphBody(policy *models.ConditionalAccessPolicy) (map[string]any, error) {
+	body, err := graphObjectBody(policy)
+	if err != nil {
+		return nil, err
+	}
+	if grant, ok := body["grantControls"].(map[string]any); ok {
+		if strength, ok := grant["authenticationStrength"].(map[string]any); ok {
+			grant["authenticationStrength"] = map[string]any{"id": strength["id"]}
+		}
+	}
+	return body, nil
+}
+
+// graphObjectBody serializes value as Graph JSON and decodes it without the
+// read-only properties and OData annotations. Numbers are kept as
+// json.Number.
+func graphObjectBody(value serialization.Parsable) (map[string]any, error) {
+	writer := jsonserialization.NewJsonSerializationWriter()
+	defer writer.Close()
+	if err := writer.WriteObjectValue("", value); err != nil {
+		return nil, err
+	}
+	content, err := writer.GetSerializedContent()
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	var body map[string]any
+	if err := decoder.Decode(&body); err != nil {
+		return nil, err
+	}
+	for _, field := range backupReadOnlyFields {
+		delete(body, field)
+	}
+	removeODataAnnotations(body)
+	return body, nil
+}
+
+// removeODataAnnotations deletes "@odata.context" and similar annotations,
+// keeping "@odata.type", which Graph needs to tell derived types apart.
+func removeODataAnnotations(value any) {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, child := range v {
+			if strings.Contains(key, "@odata.") && !strings.HasSuffix(key, "@odata.type") {
+				delete(v, key)
+				continue
+			}
+			removeODataAnnotations(child)
+		}
+	case []any:
+		for _, child := range v {
+			removeODataAnnotations(child)
+		}
+	}
+}
+
+func newBackupRefs(p *caPolicy) *backupRefs {
+	refs := &backupRefs{
+		ID:             p.ID,
+		DisplayName:    p.DisplayName,
+		Users:          map[string]string{},
+		Groups:         map[string]string{},
+		NamedLocations: map[string]string{},
+	}
+	addBackupRefs(refs.Users, p.Conditions.Users.IncludeUsers, p.Conditions.Users.ExcludeUsers)
+	addBackupRefs(refs.Groups, p.Conditions.Users.IncludeGroups, p.Conditions.Users.ExcludeGroups)
+	if locations := p.Conditions.Locations; locations != nil {
+		addBackupRefs(refs.NamedLocations, locations.IncludeLocations, locations.ExcludeLocations)
+	}
+	return refs
+}
+
+// addBackupRefs records the name of every resolved reference in names.
+func addBackupRefs(names map[string]string, lists ...[]principalRef) {
+	for _, refs := range lists {
+		for _, ref := range refs {
+			if ref.Name != "" {
+				names[ref.ID] = ref.Name
+			}
+		}
+	}
+}
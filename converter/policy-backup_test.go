@@ -0,0 +1,47 @@
+package converter
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+)
+
+func TestPolicyBackupsOfSimilarNamesDoNotCollide(t *testing.T) {
+	out := NewMemoryWriter()
+	policies := []models.ConditionalAccessPolicy{testPolicy("a1", "Require MFA"), testPolicy("b2", "Require: MFA")}
+	if err := writePolicyBackups(out, io.Discard, "backup", policies, newDirectoryCache(context.Background(), testDirectory)); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"backup/require_mfa_a1.json", "backup/require_mfa_b2.json", "backup/require_mfa_b2.refs.json"} {
+		if _, err := out.ReadFile(path); err != nil {
+			t.Errorf("missing backup: %v", err)
+		}
+	}
+}
+
+func TestPolicyBackupsFailOnCollision(t *testing.T) {
+	first, second := testPolicy("", "Require MFA"), testPolicy("", "Require: MFA")
+	first.SetId(nil)
+	second.SetId(nil)
+	err := writePolicyBackups(NewMemoryWriter(), io.Discard, "backup", []models.ConditionalAccessPolicy{first, second}, newDirectoryCache(context.Background(), testDirectory))
+	if err == nil {
+		t.Error("colliding backups were written")
+	}
+}
+
+func TestPolicyBackupsRemoveBackupOfOldName(t *testing.T) {
+	out := NewMemoryWriter()
+	directory := newDirectoryCache(context.Background(), testDirectory)
+	if err := writePolicyBackups(out, io.Discard, "backup", []models.ConditionalAccessPolicy{testPolicy("a1", "Require MFA")}, directory); err != nil {
+		t.Fatal(err)
+	}
+	if err := writePolicyBackups(out, io.Discard, "backup", []models.ConditionalAccessPolicy{testPolicy("a1", "Require MFA for all")}, directory); err != nil {
+		t.Fatal(err)
+	}
+	paths, _ := out.Glob("backup/*")
+	if len(paths) != 2 || paths[0] != "backup/require_mfa_for_all_a1.json" {
+		t.Errorf("backups = %v, want only those of the new name", paths)
+	}
+}
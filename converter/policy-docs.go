@@ -0,0 +1,342 @@
+package converter
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// policyDocsDir is the directory below the output directory holding the
+// Markdown documentation. The generator owns it: files for policies that no
+// longer exist are removed.
+const policyDocsDir = "docs"
+
+const policyDocsIndex = "README.md"
+
+// policyStateLabels are the portal names of the policy states.
+var policyStateLabels = map[string]string{
+	"enabled":                           "On",
+	"disabled":                          "Off",
+	"enabledForReportingButNotEnforced": "Report-only",
+}
+
+// builtInControlLabels are the portal names of the built-in grant controls.
+var builtInControlLabels = map[string]string{
+	"block":                "Block access",
+	"mfa":                  "Require multifactor authentication",
+	"compliantDevice":      "Require device to be marked as compliant",
+	"domainJoinedDevice":   "Require Microsoft Entra hybrid joined device",
+	"approvedApplication":  "Require approved client app",
+	"compliantApplication": "Require app protection policy",
+	"passwordChange":       "Require password change",
+}
+
+// writePolicyDocs writes a Markdown document per policy and an index table
+// of all policies to the docs directory below dir. Directory roles are listed
+// by their names in roles.
+func writePolicyDocs(out OutputWriter, log io.Writer, dir string, policies []*caPolicy, roles map[string]string) error {
+	docsDir := filepath.Join(dir, policyDocsDir)
+
+	sorted := append([]*caPolicy(nil), policies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].DisplayName < sorted[j].DisplayName })
+
+	written := map[string]bool{policyDocsIndex: true}
+	for _, p := range sorted {
+		name := policyDocFileName(p)
+		written[name] = true
+		if err := writeDocFile(out, log, filepath.Join(docsDir, name), policyDoc(p, roles)); err != nil {
+			return err
+		}
+	}
+	if err := writeDocFile(out, log, filepath.Join(docsDir, policyDocsIndex), policyDocsIndexDoc(sorted, roles)); err != nil {
+		return err
+	}
+
+	existing, err := out.Glob(filepath.Join(docsDir, "*.md"))
+	if err != nil {
+		return err
+	}
+	for _, path := range existing {
+		if written[filepath.Base(path)] {
+			continue
+		}
+		if err := out.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		fmt.Fprintf(log, "Removed documentation file: %s\n", path)
+	}
+	return nil
+}
+
+func writeDocFile(out OutputWriter, log io.Writer, path, content string) error {
+	change, err := writeFileIfChanged(out, path, []byte(content))
+	if err != nil {
+		return err
+	}
+	if change != fileUnchanged {
+		fmt.Fprintf(log, "%s documentation file: %s\n", change, path)
+	}
+	return nil
+}
+
+func policyDocFileName(p *caPolicy) string {
+	return fileNamePart(p.DisplayName) + ".md"
+}
+
+// policyDoc renders the documentation for one policy, following the sections
+// of the policy page in the portal.
+func policyDoc(p *caPolicy, roles map[string]string) string {
+	d := &markdownDoc{}
+	d.line("# %s", p.DisplayName)
+	d.line("")
+	d.line("| | |")
+	d.line("|---|---|")
+	d.line("| State | %s |", markdownCell(stateLabel(p.State)))
+	if p.ID != "" {
+		d.line("| Policy ID | `%s` |", p.ID)
+	}
+
+	c := &p.Conditions
+	d.section("Users")
+	d.item("Include users", refLabels(c.Users.IncludeUsers))
+	d.item("Include groups", refLabels(c.Users.IncludeGroups))
+	d.item("Include directory roles", refLabels(roleRefs(c.Users.IncludeRoles, roles)))
+	d.guests("Include guests or external users", c.Users.IncludeGuests)
+	d.item("Exclude users", refLabels(c.Users.ExcludeUsers))
+	d.item("Exclude groups", refLabels(c.Users.ExcludeGroups))
+	d.item("Exclude directory roles", refLabels(roleRefs(c.Users.ExcludeRoles, roles)))
+	d.guests("Exclude guests or external users", c.Users.ExcludeGuests)
+
+	d.section("Target resources")
+	if apps := c.Applications; apps != nil {
+		d.item("Include applications", codeLabels(apps.IncludeApplications))
+		d.item("Exclude applications", codeLabels(apps.ExcludeApplications))
+		d.item("User actions", apps.IncludeUserActions)
+		d.item("Authentication contexts", codeLabels(apps.IncludeAuthenticationContext))
+		d.filter("Application filter", apps.Filter)
+	}
+	if clientApps := c.ClientApplications; clientApps != nil {
+		d.item("Include workload identities", codeLabels(clientApps.IncludeServicePrincipals))
+		d.item("Exclude workload identities", codeLabels(clientApps.ExcludeServicePrincipals))
+		d.filter("Workload identity filter", clientApps.Filter)
+	}
+
+	d.section("Conditions")
+	d.item("User risk", c.UserRiskLevels)
+	d.item("Sign-in risk", c.SignInRiskLevels)
+	d.item("Insider risk", c.InsiderRiskLevels)
+	d.item("Service principal risk", c.ServicePrincipalRiskLevels)
+	if platforms := c.Platforms; platforms != nil {
+		d.item("Include device platforms", platforms.IncludePlatforms)
+		d.item("Exclude device platforms", platforms.ExcludePlatforms)
+	}
+	if locations := c.Locations; locations != nil {
+		d.item("Include locations", refLabels(locations.IncludeLocations))
+		d.item("Exclude locations", refLabels(locations.ExcludeLocations))
+	}
+	d.item("Client apps", c.ClientAppTypes)
+	if devices := c.Devices; devices != nil {
+		d.filter("Device filter", devices.Filter)
+	}
+
+	d.section("Grant")
+	if grant := p.Grant; grant != nil {
+		d.item("Controls", grantControlLabels(grant))
+		if len(grant.BuiltInControls)+len(grant.CustomAuthenticationFactors)+len(grant.TermsOfUse) > 1 || grant.AuthenticationStrengthPolicyID != "" {
+			d.item("For multiple controls", []string{operatorLabel(grant.Operator)})
+		}
+	}
+
+	d.section("Session")
+	if session := p.Session; session != nil {
+		if session.ApplicationEnforcedRestrictionsEnabled != nil && *session.ApplicationEnforcedRestrictionsEnabled {
+			d.item("Use app enforced restrictions", []string{"Yes"})
+		}
+		d.item("Use Conditional Access App Control", stringItem(session.CloudAppSecurityPolicy))
+		if session.SignInFrequency != nil {
+			d.item("Sign-in frequency", []string{fmt.Sprintf("%d %s", *session.SignInFrequency, session.SignInFrequencyPeriod)})
+		} else if session.SignInFrequencyInterval == "everyTime" {
+			d.item("Sign-in frequency", []string{"Every time"})
+		}
+		d.item("Persistent browser session", stringItem(session.PersistentBrowserMode))
+		if session.DisableResilienceDefaults != nil && *session.DisableResilienceDefaults {
+			d.item("Disable resilience defaults", []string{"Yes"})
+		}
+	}
+
+	return d.text()
+}
+
+// policyDocsIndexDoc renders the table listing every policy.
+func policyDocsIndexDoc(policies []*caPolicy, roles map[string]string) string {
+	d := &markdownDoc{}
+	d.line("# Conditional access policies")
+	d.line("")
+	d.line("| Policy | State | Users | Target resources | Grant |")
+	d.line("|---|---|---|---|---|")
+	for _, p := range policies {
+		users := append(refLabels(p.Conditions.Users.IncludeUsers), refLabels(p.Conditions.Users.IncludeGroups)...)
+		users = append(users, refLabels(roleRefs(p.Conditions.Users.IncludeRoles, roles))...)
+		var apps, grant []string
+		if p.Conditions.Applications != nil {
+			apps = append(codeLabels(p.Conditions.Applications.IncludeApplications), p.Conditions.Applications.IncludeUserActions...)
+		}
+		if p.Grant != nil {
+			grant = grantControlLabels(p.Grant)
+		}
+		d.line("| [%s](%s) | %s | %s | %s | %s |",
+			markdownCell(p.DisplayName), policyDocFileName(p), markdownCell(stateLabel(p.State)),
+			markdownCell(strings.Join(users, ", ")), markdownCell(strings.Join(apps, ", ")), markdownCell(strings.Join(grant, ", ")))
+	}
+	return d.text()
+}
+
+// markdownDoc builds a document from headings and bulleted items. Sections
+// without any items get a placeholder so every document has the same shape.
+type markdownDoc struct {
+	strings.Builder
+	sectionEmpty bool
+}
+
+func (d *markdownDoc) line(format string, args ...any) {
+	fmt.Fprintf(d, format+"\n", args...)
+}
+
+func (d *markdownDoc) section(title string) {
+	d.endSection()
+	d.line("")
+	d.line("## %s", title)
+	d.line("")
+	d.sectionEmpty = true
+}
+
+func (d *markdownDoc) endSection() {
+	if d.sectionEmpty {
+		d.line("Not configured.")
+		d.sectionEmpty = false
+	}
+}
+
+func (d *markdownDoc) item(label string, values []string) {
+	if len(values) == 0 {
+		return
+	}
+	d.sectionEmpty = false
+	if len(values) == 1 {
+		d.line("- **%s:** %s", label, values[0])
+		return
+	}
+	d.line("- **%s:**", label)
+	for _, value := range values {
+		d.line("  - %s", value)
+	}
+}
+
+func (d *markdownDoc) filter(label string, filter *caFilter) {
+	if filter != nil {
+		d.item(label, []string{fmt.Sprintf("%s `%s`", filter.Mode, filter.Rule)})
+	}
+}
+
+func (d *markdownDoc) guests(label string, guests *caGuestsOrExternalUsers) {
+	if guests == nil {
+		return
+	}
+	values := append([]string(nil), guests.GuestOrExternalUserTypes...)
+	if tenants := guests.ExternalTenants; tenants != nil {
+		if len(tenants.Members) > 0 {
+			values = append(values, "tenants: "+strings.Join(codeLabels(tenants.Members), ", "))
+		} else {
+			values = append(values, "tenants: "+tenants.MembershipKind)
+		}
+	}
+	d.item(label, values)
+}
+
+// text closes the last section and returns the document.
+func (d *markdownDoc) text() string {
+	d.endSection()
+	return d.String()
+}
+
+// refLabels returns the resolved name of each reference, or its ID in code
+// style when it could not be resolved. Keywords are kept as they are.
+func refLabels(refs []principalRef) []string {
+	var labels []string
+	for _, ref := range refs {
+		switch {
+		case ref.Name != "":
+			labels = append(labels, ref.Name)
+		case isReferenceKeyword(ref.ID):
+			labels = append(labels, ref.ID)
+		default:
+			labels = append(labels, "`"+ref.ID+"`")
+		}
+	}
+	return labels
+}
+
+// codeLabels formats IDs in code style, keeping keywords such as "All" as
+// they are.
+func codeLabels(values []string) []string {
+	var labels []string
+	for _, value := range values {
+		if isReferenceKeyword(value) || value == "Office365" || value == "MicrosoftAdminPortals" || value == "ServicePrincipalsInMyTenant" {
+			labels = append(labels, value)
+		} else {
+			labels = append(labels, "`"+value+"`")
+		}
+	}
+	return labels
+}
+
+func grantControlLabels(grant *caGrantControls) []string {
+	var labels []string
+	for _, control := range grant.BuiltInControls {
+		if label, ok := builtInControlLabels[control]; ok {
+			labels = append(labels, label)
+		} else {
+			labels = append(labels, control)
+		}
+	}
+	if grant.AuthenticationStrengthPolicyID != "" {
+		labels = append(labels, fmt.Sprintf("Require authentication strength `%s`", grant.AuthenticationStrengthPolicyID))
+	}
+	for _, terms := range grant.TermsOfUse {
+		labels = append(labels, fmt.Sprintf("Terms of use `%s`", terms))
+	}
+	for _, factor := range grant.CustomAuthenticationFactors {
+		labels = append(labels, fmt.Sprintf("Custom control `%s`", factor))
+	}
+	return labels
+}
+
+func operatorLabel(operator string) string {
+	if operator == "AND" {
+		return "Require all the selected controls"
+	}
+	return "Require one of the selected controls"
+}
+
+func stateLabel(state string) string {
+	if label, ok := policyStateLabels[state]; ok {
+		return label
+	}
+	return state
+}
+
+func stringItem(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return []string{value}
+}
+
+// markdownCell escapes value for use in a table cell.
+func markdownCell(value string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(value, "|", `\|`), "\n", " ")
+}
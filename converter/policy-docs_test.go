@@ -0,0 +1,24 @@
+package converter
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPolicyDocListsRolesByName(t *testing.T) {
+	const unknownRole = "33333333-3333-3333-3333-333333333333"
+	p := &caPolicy{DisplayName: "Admins MFA", State: "enabled"}
+	p.Conditions.Users.IncludeRoles = []string{"62e90394-69f5-4237-9190-012177145e10"}
+	p.Conditions.Users.ExcludeRoles = []string{unknownRole}
+
+	doc := policyDoc(p, privilegedRoles)
+	if !strings.Contains(doc, "- **Include directory roles:** Global Administrator\n") {
+		t.Errorf("included role not listed by name:\n%s", doc)
+	}
+	if !strings.Contains(doc, "- **Exclude directory roles:** `"+unknownRole+"`\n") {
+		t.Errorf("unknown role not listed by ID:\n%s", doc)
+	}
+	if index := policyDocsIndexDoc([]*caPolicy{p}, privilegedRoles); !strings.Contains(index, "| Global Administrator |") {
+		t.Errorf("index does not list the role by name:\n%s", index)
+	}
+}
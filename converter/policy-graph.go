@@ -0,0 +1,199 @@
+package converter
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// graphNode is a policy or an object policies refer to in the dependency
+// graph.
+type graphNode struct {
+	key   string
+	kind  string
+	label string
+}
+
+// graphEdge connects a policy to an object it includes or excludes.
+type graphEdge struct {
+	from, to string
+	exclude  bool
+}
+
+// policyGraph is the dependency graph of a set of policies.
+type policyGraph struct {
+	nodes []graphNode
+	seen  map[string]bool
+	edges []graphEdge
+}
+
+// Node kinds other than policies, in the order they are listed.
+var graphObjectKinds = []string{"user", "group", "role", "application", "named location"}
+
+// newPolicyGraph builds the graph of policies and the users, groups, roles,
+// applications and named locations they include or exclude. Roles are
+// labelled with their names in roles.
+func newPolicyGraph(policies []*caPolicy, roles map[string]string) *policyGraph {
+	g := &policyGraph{seen: map[string]bool{}}
+
+	sorted := append([]*caPolicy(nil), policies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].DisplayName < sorted[j].DisplayName })
+
+	for _, p := range sorted {
+		policyKey := "policy/" + p.ID
+		if p.ID == "" {
+			policyKey = "policy/" + p.DisplayName
+		}
+		g.addNode(graphNode{key: policyKey, kind: "policy", label: p.DisplayName})
+
+		users := &p.Conditions.Users
+		g.addRefs(policyKey, "user", users.IncludeUsers, false)
+		g.addRefs(policyKey, "user", users.ExcludeUsers, true)
+		g.addRefs(policyKey, "group", users.IncludeGroups, false)
+		g.addRefs(policyKey, "group", users.ExcludeGroups, true)
+		g.addRefs(policyKey, "role", roleRefs(users.IncludeRoles, roles), false)
+		g.addRefs(policyKey, "role", roleRefs(users.ExcludeRoles, roles), true)
+		if apps := p.Conditions.Applications; apps != nil {
+			g.addIDs(policyKey, "application", apps.IncludeApplications, false)
+			g.addIDs(policyKey, "application", apps.ExcludeApplications, true)
+		}
+		if locations := p.Conditions.Locations; locations != nil {
+			g.addRefs(policyKey, "named location", locations.IncludeLocations, false)
+			g.addRefs(policyKey, "named location", locations.ExcludeLocations, true)
+		}
+	}
+
+	// list policies first, then the objects by kind and label
+	kindOrder := map[string]int{"policy": 0}
+	for i, kind := range graphObjectKinds {
+		kindOrder[kind] = i + 1
+	}
+	sort.SliceStable(g.nodes, func(i, j int) bool {
+		a, b := g.nodes[i], g.nodes[j]
+		if a.kind != b.kind {
+			return kindOrder[a.kind] < kindOrder[b.kind]
+		}
+		return a.kind != "policy" && a.label < b.label
+	})
+	return g
+}
+
+func (g *policyGraph) addNode(node graphNode) {
+	if !g.seen[node.key] {
+		g.seen[node.key] = true
+		g.nodes = append(g.nodes, node)
+	}
+}
+
+func (g *policyGraph) addRefs(policyKey, kind string, refs []principalRef, exclude bool) {
+	for _, ref := range refs {
+		key := kind + "/" + ref.ID
+		label := ref.Name
+		if label == "" {
+			label = ref.ID
+		}
+		g.addNode(graphNode{key: key, kind: kind, label: label})
+		g.edges = append(g.edges, graphEdge{from: policyKey, to: key, exclude: exclude})
+	}
+}
+
+func (g *policyGraph) addIDs(policyKey, kind string, ids []string, exclude bool) {
+	for _, id := range ids {
+		key := kind + "/" + id
+		g.addNode(graphNode{key: key, kind: kind, label: id})
+		g.edges = append(g.edges, graphEdge{from: policyKey, to: key, exclude: exclude})
+	}
+}
+
+// nodeLabel is the text shown for node, prefixed with its kind for objects.
+func nodeLabel(node graphNode) string {
+	if node.kind == "policy" {
+		return node.label
+	}
+	return fmt.Sprintf("%s: %s", strings.ToUpper(node.kind[:1])+node.kind[1:], node.label)
+}
+
+// dot renders the graph in the Graphviz DOT language. Exclusions are dashed
+// red edges.
+func (g *policyGraph) dot() string {
+	var b strings.Builder
+	b.WriteString("digraph policies {\n")
+	b.WriteString("  rankdir=LR;\n")
+	b.WriteString("  node [shape=box, style=rounded];\n\n")
+	for _, node := range g.nodes {
+		shape := "ellipse"
+		if node.kind == "policy" {
+			shape = "box"
+		}
+		fmt.Fprintf(&b, "  %s [label=%s, shape=%s];\n", dotQuote(node.key), dotQuote(nodeLabel(node)), shape)
+	}
+	b.WriteString("\n")
+	for _, edge := range g.edges {
+		attributes := `label="include"`
+		if edge.exclude {
+			attributes = `label="exclude", style=dashed, color=red`
+		}
+		fmt.Fprintf(&b, "  %s -> %s [%s];\n", dotQuote(edge.from), dotQuote(edge.to), attributes)
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// mermaid renders the graph as a Mermaid flowchart. Node IDs are numbered
+// since Mermaid only accepts simple identifiers; exclusions are dotted edges.
+func (g *policyGraph) mermaid() string {
+	ids := map[string]string{}
+	var b strings.Builder
+	b.WriteString("flowchart LR\n")
+	for i, node := range g.nodes {
+		id := fmt.Sprintf("n%d", i+1)
+		ids[node.key] = id
+		open, close := "([", "])"
+		if node.kind == "policy" {
+			open, close = "[", "]"
+		}
+		fmt.Fprintf(&b, "  %s%s\"%s\"%s\n", id, open, mermaidText(nodeLabel(node)), close)
+	}
+	for _, edge := range g.edges {
+		arrow := "-->|include|"
+		if edge.exclude {
+			arrow = "-.->|exclude|"
+		}
+		fmt.Fprintf(&b, "  %s %s %s\n", ids[edge.from], arrow, ids[edge.to])
+	}
+	return b.String()
+}
+
+// writePolicyGraph writes the dependency graph of policies to policies.dot
+// and policies.mmd in dir, labelling roles with their names in roles.
+func writePolicyGraph(out OutputWriter, log io.Writer, dir string, policies []*caPolicy, roles map[string]string) error {
+	g := newPolicyGraph(policies, roles)
+	for _, file := range []struct {
+		name    string
+		content string
+	}{
+		{"policies.dot", g.dot()},
+		{"policies.mmd", g.mermaid()},
+	} {
+		path := filepath.Join(dir, file.name)
+		change, err := writeFileIfChanged(out, path, []byte(file.content))
+		if err != nil {
+			return err
+		}
+		if change != fileUnchanged {
+			fmt.Fprintf(log, "%s dependency graph: %s\n", change, path)
+		}
+	}
+	return nil
+}
+
+func dotQuote(value string) string {
+	return `"` + strings.ReplaceAll(strings.ReplaceAll(value, `\`, `\\`), `"`, `\"`) + `"`
+}
+
+// mermaidText escapes quotes, which cannot appear in a quoted Mermaid label.
+func mermaidText(value string) string {
+	return strings.ReplaceAll(value, `"`, "#quot;")
+}
@@ -0,0 +1,27 @@
+package converter
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestPolicyGraphLabelsRolesByName(t *testing.T) {
+	const unknownRole = "33333333-3333-3333-3333-333333333333"
+	p := &caPolicy{ID: "a1", DisplayName: "Admins MFA", State: "enabled"}
+	p.Conditions.Users.IncludeRoles = []string{"62e90394-69f5-4237-9190-012177145e10", unknownRole}
+
+	out := NewMemoryWriter()
+	if err := writePolicyGraph(out, io.Discard, "gen", []*caPolicy{p}, privilegedRoles); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"gen/policies.dot", "gen/policies.mmd"} {
+		src, err := out.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(src), "Role: Global Administrator") || !strings.Contains(string(src), "Role: "+unknownRole) {
+			t.Errorf("%s does not label roles by name with the ID as fallback:\n%s", path, src)
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package converter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+)
+
+const manifestFileName = ".capolicy-manifest.json"
+
+// policyManifest remembers, between runs, which resource label and file each
+// policy was generated as. It lets a rename in the portal become a moved
+// block instead of a destroy and create. It also records the attribute values
+// written to each file, so later runs keep hand edits.
+type policyManifest struct {
+	Policies map[string]manifestEntry `json:"policies"`
+
+	// Values holds the expression last generated for each attribute, by file
+	// relative to the output directory and then by block and attribute.
+	Values map[string]map[string]string `json:"values,omitempty"`
+}
+
+type manifestEntry struct {
+	DisplayName string `json:"display_name"`
+	Label       string `json:"label"`
+	File        string `json:"file"`
+
+	// ResourceType is the type of the policy resource. It is empty for
+	// azuread_conditional_access_policy.
+	ResourceType string `json:"resource_type,omitempty"`
+}
+
+// resourceType returns the type of the resource the policy was generated as.
+func (e manifestEntry) resourceType() string {
+	if e.ResourceType == "" {
+		return azureADPolicyResourceType
+	}
+	return e.ResourceType
+}
+
+// loadManifest reads the manifest in dir. A missing manifest is empty.
+func loadManifest(out OutputWriter, dir string) (*policyManifest, error) {
+	manifest := &policyManifest{Policies: map[string]manifestEntry{}}
+
+	data, err := out.ReadFile(filepath.Join(dir, manifestFileName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return manifest, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("error parsing manifest: %v", err)
+	}
+	if manifest.Policies == nil {
+		manifest.Policies = map[string]manifestEntry{}
+	}
+	return manifest, nil
+}
+
+// attributeValues returns the recorded attribute values of the files below
+// dir, which writeMergedFile reads and updates.
+func (m *policyManifest) attributeValues(dir string) *attributeValues {
+	if m.Values == nil {
+		m.Values = map[string]map[string]string{}
+	}
+	return &attributeValues{dir: dir, files: m.Values}
+}
+
+// save writes the manifest to dir, keeping only the policies in liveIDs and
+// the values of files that still exist.
+func (m *policyManifest) save(out OutputWriter, dir string, liveIDs map[string]bool) error {
+	for id := range m.Policies {
+		if !liveIDs[id] {
+			delete(m.Policies, id)
+		}
+	}
+	for file := range m.Values {
+		if _, err := out.ReadFile(filepath.Join(dir, filepath.FromSlash(file))); errors.Is(err, fs.ErrNotExist) {
+			delete(m.Values, file)
+		}
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return out.WriteFile(filepath.Join(dir, manifestFileName), append(data, '\n'))
+}
+
+// assignLabels returns the resource label of each policy by ID. A label is
+// derived from the display name, so names differing only in case or spacing
+// would share one; the later policies by ID then get a numeric suffix. A
+// policy keeps the label it was generated with as long as that label still
+// belongs to its display name, so suffixes do not move between policies.
+func (m *policyManifest) assignLabels(policies []models.ConditionalAccessPolicy) map[string]string {
+	var ids []string
+	bases := map[string]string{}
+	for _, policy := range policies {
+		id, name := stringValue(policy.GetId()), stringValue(policy.GetDisplayName())
+		if id == "" || name == "" {
+			continue
+		}
+		ids = append(ids, id)
+		bases[id] = policyResourceName(name)
+	}
+	sort.Strings(ids)
+
+	labels := map[string]string{}
+	taken := map[string]bool{}
+	for _, id := range ids {
+		previous, ok := m.Policies[id]
+		if ok && strings.HasPrefix(previous.Label, bases[id]) && labelSuffix.MatchString(previous.Label[len(bases[id]):]) && !taken[previous.Label] {
+			labels[id] = previous.Label
+			taken[previous.Label] = true
+		}
+	}
+	for _, id := range ids {
+		if _, ok := labels[id]; ok {
+			continue
+		}
+		label := bases[id]
+		for n := 2; taken[label]; n++ {
+			label = fmt.Sprintf("%s_%d", bases[id], n)
+		}
+		labels[id] = label
+		taken[label] = true
+	}
+	return labels
+}
+
+// labelSuffix matches what assignLabels appends to a label: nothing, or an
+// underscore and a number.
+var labelSuffix = regexp.MustCompile(`^(_\d+)?$`)
+
+// idForLabel returns the ID of the policy generated with the given resource
+// label, or "" if the manifest has none.
+func (m *policyManifest) idForLabel(label string) string {
+	for id, entry := range m.Policies {
+		if entry.Label == label {
+			return id
+		}
+	}
+	return ""
+}
+
+// movePolicyResource carries the configuration previously generated for a
+// policy over to its new label and file, so hand edits survive a rename in
+// the portal or a change of layout. The resource block and the import and
+// moved blocks pointing at it are moved; the rest of the old file stays. It
+// does nothing if the old configuration is gone, and only drops it if the
+// new file already has a resource with the new label.
+func movePolicyResource(out OutputWriter, oldPath, newPath, resourceType, oldLabel, newLabel string) error {
+	src, err := out.ReadFile(oldPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	oldFile, diags := hclwrite.ParseConfig(src, oldPath, hcl.InitialPos)
+	if diags.HasErrors() {
+		return fmt.Errorf("error parsing %s: %s", oldPath, diags.Error())
+	}
+
+	blocks := policyBlocks(oldFile.Body(), resourceType, oldLabel)
+	for _, block := range blocks {
+		if block.Type() == "resource" {
+			block.SetLabels([]string{resourceType, newLabel})
+		} else {
+			block.Body().SetAttributeTraversal("to", resourceAddress(resourceType, newLabel))
+		}
+	}
+	if len(blocks) == 0 {
+		return nil
+	}
+	if oldPath == newPath {
+		return out.WriteFile(oldPath, formatHCL(oldFile.Bytes()))
+	}
+
+	newFile := hclwrite.NewEmptyFile()
+	if src, err := out.ReadFile(newPath); err == nil {
+		newFile, diags = hclwrite.ParseConfig(src, newPath, hcl.InitialPos)
+		if diags.HasErrors() {
+			return fmt.Errorf("error parsing %s: %s", newPath, diags.Error())
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	alreadyMoved := newFile.Body().FirstMatchingBlock("resource", []string{resourceType, newLabel}) != nil
+	for _, block := range blocks {
+		oldFile.Body().RemoveBlock(block)
+		if !alreadyMoved {
+			newFile.Body().AppendNewline()
+			newFile.Body().AppendUnstructuredTokens(block.BuildTokens(nil))
+		}
+	}
+
+	if !alreadyMoved {
+		if err := out.WriteFile(newPath, formatHCL(newFile.Bytes())); err != nil {
+			return err
+		}
+	}
+	return writeOrRemoveFile(out, oldPath, oldFile)
+}
+
+// removePolicyResource deletes the resource block of the given type and label
+// from the file at path, together with the import and moved blocks pointing
+// at it. A missing file is ignored.
+func removePolicyResource(out OutputWriter, path, resourceType, label string) error {
+	src, err := out.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	f, diags := hclwrite.ParseConfig(src, path, hcl.InitialPos)
+	if diags.HasErrors() {
+		return fmt.Errorf("error parsing %s: %s", path, diags.Error())
+	}
+
+	blocks := policyBlocks(f.Body(), resourceType, label)
+	if len(blocks) == 0 {
+		return nil
+	}
+	for _, block := range blocks {
+		f.Body().RemoveBlock(block)
+	}
+	return writeOrRemoveFile(out, path, f)
+}
+
+// removeRemovedBlock deletes the removed block for address from the file at
+// path, so a resource that was replaced by another type can be declared
+// again. A missing file is ignored.
+func removeRemovedBlock(out OutputWriter, path string, address hcl.Traversal) error {
+	src, err := out.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	f, diags := hclwrite.ParseConfig(src, path, hcl.InitialPos)
+	if diags.HasErrors() {
+		return fmt.Errorf("error parsing %s: %s", path, diags.Error())
+	}
+
+	removedBlock := hclwrite.NewBlock("removed", nil)
+	removedBlock.Body().SetAttributeTraversal("from", address)
+	match := matchingBlock(f.Body(), removedBlock)
+	if match == nil {
+		return nil
+	}
+	f.Body().RemoveBlock(match)
+	return writeOrRemoveFile(out, path, f)
+}
+
+// policyBlocks returns the resource block for the policy resource with the
+// given type and label and the import and moved blocks that point at it.
+func policyBlocks(body *hclwrite.Body, resourceType, label string) []*hclwrite.Block {
+	address := resourceType + "." + label
+	var blocks []*hclwrite.Block
+	for _, block := range body.Blocks() {
+		switch block.Type() {
+		case "resource":
+			if labels := block.Labels(); len(labels) == 2 && labels[0] == resourceType && labels[1] == label {
+				blocks = append(blocks, block)
+			}
+		case "import", "moved":
+			if expressionText(block.Body().GetAttribute("to")) == address {
+				blocks = append(blocks, block)
+			}
+		}
+	}
+	return blocks
+}
+
+// appendMovedBlock records that the policy resource was renamed.
+func appendMovedBlock(body *hclwrite.Body, resourceType, oldLabel, newLabel string) {
+	body.AppendNewline()
+	movedBlock := body.AppendNewBlock("moved", nil)
+	movedBlock.Body().SetAttributeTraversal("from", resourceAddress(resourceType, oldLabel))
+	movedBlock.Body().SetAttributeTraversal("to", resourceAddress(resourceType, newLabel))
+}
@@ -0,0 +1,211 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Formats of the policy matrix.
+const (
+	matrixCSV = "csv"
+	matrixTSV = "tsv"
+)
+
+// matrixValueSeparator joins the values of a multi-valued cell.
+const matrixValueSeparator = "; "
+
+// matrixColumn is one column of the policy matrix.
+type matrixColumn struct {
+	header string
+	value  func(p *caPolicy) []string
+}
+
+// matrixColumns lists the columns of the policy matrix in order. Directory
+// roles are listed by their names in roles.
+func matrixColumns(roles map[string]string) []matrixColumn {
+	return []matrixColumn{
+		{"Policy", func(p *caPolicy) []string { return []string{p.DisplayName} }},
+		{"ID", func(p *caPolicy) []string { return stringItem(p.ID) }},
+		{"State", func(p *caPolicy) []string { return []string{stateLabel(p.State)} }},
+		{"Included users", func(p *caPolicy) []string { return refNames(p.Conditions.Users.IncludeUsers) }},
+		{"Excluded users", func(p *caPolicy) []string { return refNames(p.Conditions.Users.ExcludeUsers) }},
+		{"Included groups", func(p *caPolicy) []string { return refNames(p.Conditions.Users.IncludeGroups) }},
+		{"Excluded groups", func(p *caPolicy) []string { return refNames(p.Conditions.Users.ExcludeGroups) }},
+		{"Included roles", func(p *caPolicy) []string { return refNames(roleRefs(p.Conditions.Users.IncludeRoles, roles)) }},
+		{"Excluded roles", func(p *caPolicy) []string { return refNames(roleRefs(p.Conditions.Users.ExcludeRoles, roles)) }},
+		{"Included guests", func(p *caPolicy) []string { return guestTypes(p.Conditions.Users.IncludeGuests) }},
+		{"Excluded guests", func(p *caPolicy) []string { return guestTypes(p.Conditions.Users.ExcludeGuests) }},
+		{"Included applications", func(p *caPolicy) []string {
+			if apps := p.Conditions.Applications; apps != nil {
+				return apps.IncludeApplications
+			}
+			return nil
+		}},
+		{"Excluded applications", func(p *caPolicy) []string {
+			if apps := p.Conditions.Applications; apps != nil {
+				return apps.ExcludeApplications
+			}
+			return nil
+		}},
+		{"User actions", func(p *caPolicy) []string {
+			if apps := p.Conditions.Applications; apps != nil {
+				return apps.IncludeUserActions
+			}
+			return nil
+		}},
+		{"Included platforms", func(p *caPolicy) []string {
+			if platforms := p.Conditions.Platforms; platforms != nil {
+				return platforms.IncludePlatforms
+			}
+			return nil
+		}},
+		{"Excluded platforms", func(p *caPolicy) []string {
+			if platforms := p.Conditions.Platforms; platforms != nil {
+				return platforms.ExcludePlatforms
+			}
+			return nil
+		}},
+		{"Included locations", func(p *caPolicy) []string {
+			if locations := p.Conditions.Locations; locations != nil {
+				return refNames(locations.IncludeLocations)
+			}
+			return nil
+		}},
+		{"Excluded locations", func(p *caPolicy) []string {
+			if locations := p.Conditions.Locations; locations != nil {
+				return refNames(locations.ExcludeLocations)
+			}
+			return nil
+		}},
+		{"Client app types", func(p *caPolicy) []string { return p.Conditions.ClientAppTypes }},
+		{"Sign-in risk", func(p *caPolicy) []string { return p.Conditions.SignInRiskLevels }},
+		{"User risk", func(p *caPolicy) []string { return p.Conditions.UserRiskLevels }},
+		{"Service principal risk", func(p *caPolicy) []string { return p.Conditions.ServicePrincipalRiskLevels }},
+		{"Insider risk", func(p *caPolicy) []string { return p.Conditions.InsiderRiskLevels }},
+		{"Grant controls", func(p *caPolicy) []string {
+			if grant := p.Grant; grant != nil {
+				controls := append([]string(nil), grant.BuiltInControls...)
+				if grant.AuthenticationStrengthPolicyID != "" {
+					controls = append(controls, "authenticationStrength:"+grant.AuthenticationStrengthPolicyID)
+				}
+				for _, terms := range grant.TermsOfUse {
+					controls = append(controls, "termsOfUse:"+terms)
+				}
+				for _, factor := range grant.CustomAuthenticationFactors {
+					controls = append(controls, "custom:"+factor)
+				}
+				return controls
+			}
+			return nil
+		}},
+		{"Grant operator", func(p *caPolicy) []string {
+			if grant := p.Grant; grant != nil {
+				return stringItem(grant.Operator)
+			}
+			return nil
+		}},
+		{"Session controls", func(p *caPolicy) []string { return sessionControlSummary(p.Session) }},
+	}
+}
+
+// writePolicyMatrix writes one row per policy to policies.csv or
+// policies.tsv in dir, depending on format.
+func writePolicyMatrix(out OutputWriter, log io.Writer, dir, format string, policies []*caPolicy, roles map[string]string) error {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	switch format {
+	case matrixCSV:
+	case matrixTSV:
+		writer.Comma = '\t'
+	default:
+		return fmt.Errorf("unknown matrix format %q, expected %s or %s", format, matrixCSV, matrixTSV)
+	}
+
+	sorted := append([]*caPolicy(nil), policies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].DisplayName < sorted[j].DisplayName })
+
+	columns := matrixColumns(roles)
+	header := make([]string, len(columns))
+	for i, column := range columns {
+		header[i] = column.header
+	}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	for _, p := range sorted {
+		row := make([]string, len(columns))
+		for i, column := range columns {
+			row[i] = strings.Join(column.value(p), matrixValueSeparator)
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, "policies."+format)
+	change, err := writeFileIfChanged(out, path, buf.Bytes())
+	if err != nil {
+		return err
+	}
+	if change != fileUnchanged {
+		fmt.Fprintf(log, "%s policy matrix: %s\n", change, path)
+	}
+	return nil
+}
+
+// refNames returns the resolved name of each reference, or its ID when it
+// has none.
+func refNames(refs []principalRef) []string {
+	var names []string
+	for _, ref := range refs {
+		if ref.Name != "" {
+			names = append(names, ref.Name)
+		} else {
+			names = append(names, ref.ID)
+		}
+	}
+	return names
+}
+
+func guestTypes(guests *caGuestsOrExternalUsers) []string {
+	if guests == nil {
+		return nil
+	}
+	return guests.GuestOrExternalUserTypes
+}
+
+// sessionControlSummary describes each configured session control as
+// "name: value".
+func sessionControlSummary(session *caSessionControls) []string {
+	if session == nil {
+		return nil
+	}
+	var controls []string
+	if session.ApplicationEnforcedRestrictionsEnabled != nil && *session.ApplicationEnforcedRestrictionsEnabled {
+		controls = append(controls, "applicationEnforcedRestrictions")
+	}
+	if session.CloudAppSecurityPolicy != "" {
+		controls = append(controls, "cloudAppSecurity: "+session.CloudAppSecurityPolicy)
+	}
+	if session.SignInFrequency != nil {
+		controls = append(controls, fmt.Sprintf("signInFrequency: %d %s", *session.SignInFrequency, session.SignInFrequencyPeriod))
+	} else if session.SignInFrequencyInterval != "" {
+		controls = append(controls, "signInFrequency: "+session.SignInFrequencyInterval)
+	}
+	if session.PersistentBrowserMode != "" {
+		controls = append(controls, "persistentBrowser: "+session.PersistentBrowserMode)
+	}
+	if session.DisableResilienceDefaults != nil && *session.DisableResilienceDefaults {
+		controls = append(controls, "disableResilienceDefaults")
+	}
+	return controls
+}
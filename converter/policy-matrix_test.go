@@ -0,0 +1,25 @@
+package converter
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestPolicyMatrixListsRolesByName(t *testing.T) {
+	const unknownRole = "33333333-3333-3333-3333-333333333333"
+	p := &caPolicy{DisplayName: "Admins MFA", State: "enabled"}
+	p.Conditions.Users.IncludeRoles = []string{"62e90394-69f5-4237-9190-012177145e10", unknownRole}
+
+	out := NewMemoryWriter()
+	if err := writePolicyMatrix(out, io.Discard, "gen", matrixCSV, []*caPolicy{p}, privilegedRoles); err != nil {
+		t.Fatal(err)
+	}
+	src, err := out.ReadFile("gen/policies.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(src), ",Global Administrator; "+unknownRole+",") {
+		t.Errorf("roles not listed by name with the ID as fallback:\n%s", src)
+	}
+}
@@ -0,0 +1,431 @@
+package converter
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+)
+
+// Kinds of overlap findings.
+const (
+	// overlapDuplicate is a pair of policies with the same scope and
+	// controls.
+	overlapDuplicate = "duplicate"
+	// overlapDuplicateState is a duplicate pair differing only by state.
+	overlapDuplicateState = "duplicate-state"
+	// overlapIdenticalScope is a pair of policies targeting the same scope
+	// with different controls.
+	overlapIdenticalScope = "identical-scope"
+	// overlapSubset is a policy whose scope lies within another's.
+	overlapSubset = "subset"
+	// overlapShadowed is a grant policy whose scope lies within an enforced
+	// block policy, so it never grants access.
+	overlapShadowed = "shadowed"
+	// overlapSessionConflict is a pair of overlapping policies setting the
+	// same session control to different values.
+	overlapSessionConflict = "session-conflict"
+	// overlapPossible is a pair of policies that overlap apart from their
+	// device filters, which are not compared.
+	overlapPossible = "possible-overlap"
+)
+
+// overlapFinding is a relation found between two policies, with the
+// conditions under which both apply.
+type overlapFinding struct {
+	Kind       string    `json:"kind"`
+	Policies   [2]string `json:"policies"`
+	Message    string    `json:"message"`
+	Conditions []string  `json:"conditions"`
+}
+
+// policyScope is the set of values one condition of a policy targets. all
+// is set when the condition targets everything, or is not configured.
+type policyScope struct {
+	all     bool
+	include []string
+	exclude []string
+}
+
+// within reports whether everything s targets is targeted by other. Excluded
+// values are compared as given, so s is only within other when other
+// excludes nothing s does not.
+func (s policyScope) within(other policyScope) bool {
+	if !other.all && (s.all || !subsetOf(s.include, other.include)) {
+		return false
+	}
+	return subsetOf(other.exclude, s.exclude)
+}
+
+// overlap returns the values both s and other target, ["All"] when both
+// target everything, or nil when they have nothing in common.
+func (s policyScope) overlap(other policyScope) []string {
+	var values []string
+	switch {
+	case s.all && other.all:
+		return []string{"All"}
+	case s.all:
+		values = other.include
+	case other.all:
+		values = s.include
+	default:
+		for _, value := range s.include {
+			if contains(other.include, value) {
+				values = append(values, value)
+			}
+		}
+	}
+	var remaining []string
+	for _, value := range values {
+		if !contains(s.exclude, value) && !contains(other.exclude, value) {
+			remaining = append(remaining, value)
+		}
+	}
+	return remaining
+}
+
+func subsetOf(values, set []string) bool {
+	for _, value := range values {
+		if !contains(set, value) {
+			return false
+		}
+	}
+	return true
+}
+
+// scopeDimension is one condition overlap analysis compares.
+type scopeDimension struct {
+	name  string
+	scope func(p *caPolicy) policyScope
+}
+
+var scopeDimensions = []scopeDimension{
+	{"users", userScope},
+	{"applications", applicationScope},
+	{"client apps", func(p *caPolicy) policyScope {
+		return listScope(p.Conditions.ClientAppTypes, "all")
+	}},
+	{"platforms", func(p *caPolicy) policyScope {
+		platforms := p.Conditions.Platforms
+		if platforms == nil {
+			return policyScope{all: true}
+		}
+		s := listScope(platforms.IncludePlatforms, "all")
+		s.exclude = platforms.ExcludePlatforms
+		return s
+	}},
+	{"locations", func(p *caPolicy) policyScope {
+		locations := p.Conditions.Locations
+		if locations == nil {
+			return policyScope{all: true}
+		}
+		return policyScope{
+			all:     includesAll(locations.IncludeLocations),
+			include: refNames(locations.IncludeLocations),
+			exclude: refNames(locations.ExcludeLocations),
+		}
+	}},
+	{"sign-in risk", func(p *caPolicy) policyScope { return listScope(p.Conditions.SignInRiskLevels, "") }},
+	{"user risk", func(p *caPolicy) policyScope { return listScope(p.Conditions.UserRiskLevels, "") }},
+	{"insider risk", func(p *caPolicy) policyScope { return listScope(p.Conditions.InsiderRiskLevels, "") }},
+}
+
+// deviceFilterText returns the device filter of p as "mode: rule", or "" when p
+// has none. Filters are only compared as text: findOverlaps treats policies
+// with the same filter like policies without one, and reports every other
+// pair with a filter as possibly overlapping.
+func deviceFilterText(p *caPolicy) string {
+	if devices := p.Conditions.Devices; devices != nil && devices.Filter != nil {
+		return devices.Filter.Mode + ": " + devices.Filter.Rule
+	}
+	return ""
+}
+
+// listScope returns the scope of a list condition, which targets everything
+// when empty or when it holds the allKeyword.
+func listScope(values []string, allKeyword string) policyScope {
+	return policyScope{all: len(values) == 0 || allKeyword != "" && contains(values, allKeyword), include: values}
+}
+
+// userScope labels users, groups, roles and guest types so they can be
+// compared in a single scope.
+func userScope(p *caPolicy) policyScope {
+	users := &p.Conditions.Users
+	labels := func(refs []principalRef, groups []principalRef, roles []string, guests *caGuestsOrExternalUsers) []string {
+		var values []string
+		for _, name := range refNames(refs) {
+			if !isReferenceKeyword(name) {
+				values = append(values, "user "+name)
+			}
+		}
+		for _, name := range refNames(groups) {
+			values = append(values, "group "+name)
+		}
+		for _, role := range roles {
+			if name, ok := privilegedRoles[role]; ok {
+				role = name
+			}
+			values = append(values, "role "+role)
+		}
+		for _, guestType := range guestTypes(guests) {
+			values = append(values, "guests "+guestType)
+		}
+		return values
+	}
+	return policyScope{
+		all:     includesAll(users.IncludeUsers),
+		include: labels(users.IncludeUsers, users.IncludeGroups, users.IncludeRoles, users.IncludeGuests),
+		exclude: labels(users.ExcludeUsers, users.ExcludeGroups, users.ExcludeRoles, users.ExcludeGuests),
+	}
+}
+
+// applicationScope targets the included applications, user actions and
+// authentication contexts of p.
+func applicationScope(p *caPolicy) policyScope {
+	apps := p.Conditions.Applications
+	if apps == nil {
+		return policyScope{all: true}
+	}
+	s := policyScope{all: contains(apps.IncludeApplications, "All"), exclude: apps.ExcludeApplications}
+	s.include = append(s.include, apps.IncludeApplications...)
+	for _, action := range apps.IncludeUserActions {
+		s.include = append(s.include, "user action "+action)
+	}
+	for _, context := range apps.IncludeAuthenticationContext {
+		s.include = append(s.include, "authentication context "+context)
+	}
+	return s
+}
+
+// policyScopes is the scope of a policy in every dimension.
+type policyScopes []policyScope
+
+func scopesOf(p *caPolicy) policyScopes {
+	scopes := make(policyScopes, len(scopeDimensions))
+	for i, dimension := range scopeDimensions {
+		scopes[i] = dimension.scope(p)
+	}
+	return scopes
+}
+
+func (s policyScopes) within(other policyScopes) bool {
+	for i := range s {
+		if !s[i].within(other[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// overlapConditions describes the values both scopes target in every
+// dimension, or returns nil when they do not overlap in some dimension.
+func (s policyScopes) overlapConditions(other policyScopes) []string {
+	var conditions []string
+	for i, dimension := range scopeDimensions {
+		values := s[i].overlap(other[i])
+		if len(values) == 0 {
+			return nil
+		}
+		if dimension.name == "users" || dimension.name == "applications" || !s[i].all || !other[i].all {
+			conditions = append(conditions, fmt.Sprintf("%s: %s", dimension.name, strings.Join(values, ", ")))
+		}
+	}
+	return conditions
+}
+
+// findOverlaps compares every pair of policies. Disabled policies are only
+// compared to find duplicates. Pairs whose device filters differ can only be
+// reported as possibly overlapping, as the filters are not compared.
+func findOverlaps(policies []*caPolicy) []overlapFinding {
+	sorted := append([]*caPolicy(nil), policies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].DisplayName < sorted[j].DisplayName })
+	scopes := make([]policyScopes, len(sorted))
+	for i, p := range sorted {
+		scopes[i] = scopesOf(p)
+	}
+
+	var findings []overlapFinding
+	for i, p := range sorted {
+		for j := i + 1; j < len(sorted); j++ {
+			q := sorted[j]
+			conditions := scopes[i].overlapConditions(scopes[j])
+			if conditions == nil {
+				continue
+			}
+			finding := func(kind string, first, second *caPolicy, message string) {
+				findings = append(findings, overlapFinding{kind, [2]string{first.DisplayName, second.DisplayName}, message, conditions})
+			}
+
+			pFilter, qFilter := deviceFilterText(p), deviceFilterText(q)
+			if pFilter != qFilter {
+				if p.State != "disabled" && q.State != "disabled" {
+					for _, filter := range []string{pFilter, qFilter} {
+						if filter != "" {
+							conditions = append(conditions, "device filter "+filter)
+						}
+					}
+					finding(overlapPossible, p, q, "possibly overlapping: the device filters differ and are not compared")
+				}
+				continue
+			}
+			if pFilter != "" {
+				conditions = append(conditions, "device filter: "+pFilter)
+			}
+
+			pWithinQ, qWithinP := scopes[i].within(scopes[j]), scopes[j].within(scopes[i])
+			sameControls := reflect.DeepEqual(p.Grant, q.Grant) && reflect.DeepEqual(p.Session, q.Session)
+			switch {
+			case pWithinQ && qWithinP && sameControls && p.State != q.State:
+				finding(overlapDuplicateState, p, q, fmt.Sprintf("duplicates differing only by state (%s and %s)", p.State, q.State))
+				continue
+			case pWithinQ && qWithinP && sameControls:
+				finding(overlapDuplicate, p, q, "duplicates with the same scope and controls")
+				continue
+			case p.State == "disabled" || q.State == "disabled":
+				continue
+			}
+
+			switch {
+			case pWithinQ && shadows(q, p):
+				finding(overlapShadowed, p, q, fmt.Sprintf("grant policy %q never applies because block policy %q covers its scope", p.DisplayName, q.DisplayName))
+			case qWithinP && shadows(p, q):
+				finding(overlapShadowed, q, p, fmt.Sprintf("grant policy %q never applies because block policy %q covers its scope", q.DisplayName, p.DisplayName))
+			case pWithinQ && qWithinP:
+				finding(overlapIdenticalScope, p, q, "same scope with different controls")
+			case pWithinQ:
+				finding(overlapSubset, p, q, fmt.Sprintf("%q targets a subset of the scope of %q", p.DisplayName, q.DisplayName))
+			case qWithinP:
+				finding(overlapSubset, q, p, fmt.Sprintf("%q targets a subset of the scope of %q", q.DisplayName, p.DisplayName))
+			}
+			for _, conflict := range sessionConflicts(p.Session, q.Session) {
+				finding(overlapSessionConflict, p, q, "different "+conflict)
+			}
+		}
+	}
+	return findings
+}
+
+// shadows reports whether block is an enforced block policy and grant a
+// policy granting access under conditions.
+func shadows(block, grant *caPolicy) bool {
+	return block.State == "enabled" && isBlock(block) && grant.Grant != nil && !isBlock(grant)
+}
+
+func isBlock(p *caPolicy) bool {
+	return p.Grant != nil && contains(p.Grant.BuiltInControls, "block")
+}
+
+// sessionConflicts describes the session controls a and b both set to
+// different values.
+func sessionConflicts(a, b *caSessionControls) []string {
+	if a == nil || b == nil {
+		return nil
+	}
+	var conflicts []string
+	frequency := func(s *caSessionControls) string {
+		if s.SignInFrequency != nil {
+			return fmt.Sprintf("%d %s", *s.SignInFrequency, s.SignInFrequencyPeriod)
+		}
+		return s.SignInFrequencyInterval
+	}
+	for _, control := range []struct {
+		name string
+		a, b string
+	}{
+		{"sign-in frequencies", frequency(a), frequency(b)},
+		{"persistent browser modes", a.PersistentBrowserMode, b.PersistentBrowserMode},
+		{"cloud app security policies", a.CloudAppSecurityPolicy, b.CloudAppSecurityPolicy},
+	} {
+		if control.a != "" && control.b != "" && control.a != control.b {
+			conflicts = append(conflicts, fmt.Sprintf("%s (%s and %s)", control.name, control.a, control.b))
+		}
+	}
+	return conflicts
+}
+
+// RunOverlap reports overlapping and conflicting policies from the tenant or
+// from backups and returns the process exit code: 0 without findings, 2 when
+// there are findings and 1 on errors.
+func RunOverlap(args []string) int {
+	ctx := context.Background()
+
+	fs := flag.NewFlagSet("overlap", flag.ExitOnError)
+	common := addCommonFlags(fs)
+	source := addPolicySourceFlags(fs)
+	format := fs.String("format", "text", "output format: text or json")
+	fs.Parse(args)
+
+	cfg, _, err := common.load()
+	if err != nil {
+		log.Printf("error loading config: %v", err)
+		return 1
+	}
+	policies, directory, err := source.load(ctx, cfg, fs.Args())
+	if err != nil {
+		log.Printf("error getting policies: %v", err)
+		return 1
+	}
+	normalized, err := normalizePolicies(policies, directory)
+	if err != nil {
+		log.Printf("error reading policies: %v", err)
+		return 1
+	}
+	findings := findOverlaps(normalized)
+
+	switch *format {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if findings == nil {
+			findings = []overlapFinding{}
+		}
+		err = encoder.Encode(findings)
+	case "text":
+		writeOverlapText(os.Stdout, findings)
+	default:
+		log.Printf("unknown format %q, expected text or json", *format)
+		return 1
+	}
+	if err != nil {
+		log.Printf("error writing report: %v", err)
+		return 1
+	}
+	if len(findings) > 0 {
+		return 2
+	}
+	return 0
+}
+
+// normalizePolicies converts policies into the normalized model.
+func normalizePolicies(policies []models.ConditionalAccessPolicy, directory *directoryCache) ([]*caPolicy, error) {
+	var normalized []*caPolicy
+	for _, policy := range policies {
+		p, err := newCAPolicy(policy, directory)
+		if err != nil {
+			return nil, err
+		}
+		normalized = append(normalized, p)
+	}
+	return normalized, nil
+}
+
+func writeOverlapText(w io.Writer, findings []overlapFinding) {
+	if len(findings) == 0 {
+		fmt.Fprintln(w, "No findings.")
+		return
+	}
+	for _, finding := range findings {
+		fmt.Fprintf(w, "%s %q / %q: %s\n", strings.ToUpper(finding.Kind), finding.Policies[0], finding.Policies[1], finding.Message)
+		for _, condition := range finding.Conditions {
+			fmt.Fprintf(w, "  %s\n", condition)
+		}
+	}
+}
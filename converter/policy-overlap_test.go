@@ -0,0 +1,78 @@
+package converter
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindOverlaps(t *testing.T) {
+	allUsers := func(p *caPolicy) {
+		p.Conditions.Users.IncludeUsers = []principalRef{{ID: "All"}}
+		p.Conditions.Users.IncludeGroups = nil
+	}
+	controls := func(control string) func(p *caPolicy) {
+		return func(p *caPolicy) { p.Grant = &caGrantControls{Operator: "OR", BuiltInControls: []string{control}} }
+	}
+	filter := func(mode, rule string) func(p *caPolicy) {
+		return func(p *caPolicy) { p.Conditions.Devices = &caDevices{Filter: &caFilter{Mode: mode, Rule: rule}} }
+	}
+	frequency := func(hours int64) func(p *caPolicy) {
+		return func(p *caPolicy) {
+			p.Session = &caSessionControls{SignInFrequency: &hours, SignInFrequencyPeriod: "hours"}
+		}
+	}
+	for _, tc := range []struct {
+		name string
+		// edits change the second policy, "B", of a pair of copies of
+		// testPolicy; first edits the first, "A"
+		first, edits []func(p *caPolicy)
+		kinds        []string
+	}{
+		{name: "duplicate", kinds: []string{overlapDuplicate}},
+		{name: "duplicate by state", edits: []func(p *caPolicy){func(p *caPolicy) { p.State = "disabled" }}, kinds: []string{overlapDuplicateState}},
+		{name: "identical scope", edits: []func(p *caPolicy){controls("compliantDevice")}, kinds: []string{overlapIdenticalScope}},
+		{name: "subset", edits: []func(p *caPolicy){allUsers, controls("compliantDevice")}, kinds: []string{overlapSubset}},
+		{name: "shadowed", edits: []func(p *caPolicy){allUsers, controls("block")}, kinds: []string{overlapShadowed}},
+		{name: "report-only block does not shadow", edits: []func(p *caPolicy){allUsers, controls("block"), func(p *caPolicy) { p.State = "enabledForReportingButNotEnforced" }}, kinds: []string{overlapSubset}},
+		{name: "disabled only compared for duplicates", edits: []func(p *caPolicy){allUsers, controls("block"), func(p *caPolicy) { p.State = "disabled" }}},
+		{name: "session conflict", first: []func(p *caPolicy){frequency(1)}, edits: []func(p *caPolicy){frequency(8)}, kinds: []string{overlapIdenticalScope, overlapSessionConflict}},
+		{name: "disjoint groups", edits: []func(p *caPolicy){func(p *caPolicy) {
+			p.Conditions.Users.IncludeGroups = []principalRef{{ID: "g2", Name: "Other"}}
+		}}},
+		{name: "disjoint risk levels", first: []func(p *caPolicy){func(p *caPolicy) { p.Conditions.SignInRiskLevels = []string{"high"} }}, edits: []func(p *caPolicy){func(p *caPolicy) {
+			p.Conditions.SignInRiskLevels = []string{"low"}
+		}}},
+		{name: "same device filter", first: []func(p *caPolicy){filter("include", `device.isCompliant -eq True`)}, edits: []func(p *caPolicy){filter("include", `device.isCompliant -eq True`)}, kinds: []string{overlapDuplicate}},
+		{name: "different device filters", first: []func(p *caPolicy){filter("include", `device.isCompliant -eq True`)}, edits: []func(p *caPolicy){filter("exclude", `device.trustType -eq "ServerAD"`)}, kinds: []string{overlapPossible}},
+		{name: "device filter on one side", edits: []func(p *caPolicy){filter("exclude", `device.isCompliant -eq True`), controls("block")}, kinds: []string{overlapPossible}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			a, b := testCAPolicy("a1", "A"), testCAPolicy("b2", "B")
+			for _, edit := range tc.first {
+				edit(a)
+			}
+			for _, edit := range tc.edits {
+				edit(b)
+			}
+			var kinds []string
+			for _, finding := range findOverlaps([]*caPolicy{b, a}) {
+				kinds = append(kinds, finding.Kind)
+			}
+			if !reflect.DeepEqual(kinds, tc.kinds) {
+				t.Errorf("findings = %v, want %v", kinds, tc.kinds)
+			}
+		})
+	}
+}
+
+func TestFindOverlapsOrdersShadowedPair(t *testing.T) {
+	grant, block := testCAPolicy("a1", "Z grant"), testCAPolicy("b2", "A block")
+	block.Conditions.Users.IncludeUsers = []principalRef{{ID: "All"}}
+	block.Conditions.Users.IncludeGroups = nil
+	block.Grant = &caGrantControls{Operator: "OR", BuiltInControls: []string{"block"}}
+
+	findings := findOverlaps([]*caPolicy{grant, block})
+	if len(findings) != 1 || findings[0].Policies != [2]string{"Z grant", "A block"} {
+		t.Errorf("findings = %+v, want the grant policy shadowed by the block policy", findings)
+	}
+}
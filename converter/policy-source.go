@@ -0,0 +1,196 @@
+package converter
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	msgraphsdk "github.com/microsoftgraph/msgraph-sdk-go"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+)
+
+// PolicySource provides the conditional access policies to generate
+// configuration for.
+type PolicySource interface {
+	Policies(ctx context.Context) ([]models.ConditionalAccessPolicy, error)
+}
+
+// policyDirectory is a source that also resolves the objects its policies
+// refer to, as the tenant and backups do.
+type policyDirectory interface {
+	PolicySource
+	DirectoryResolver
+}
+
+// GraphSource reads policies from a tenant through Microsoft Graph and
+// resolves the objects they refer to there.
+type GraphSource struct {
+	Client *msgraphsdk.GraphServiceClient
+}
+
+func (s *GraphSource) Policies(ctx context.Context) ([]models.ConditionalAccessPolicy, error) {
+	return getExistingPolicies(ctx, s.Client)
+}
+
+func (s *GraphSource) UserPrincipalName(ctx context.Context, id string) (string, error) {
+	return get_aad_upn_from_id(ctx, id, s.Client)
+}
+
+func (s *GraphSource) GroupDisplayName(ctx context.Context, id string) (string, error) {
+	return get_aad_display_name_from_id(ctx, id, s.Client)
+}
+
+func (s *GraphSource) NamedLocationDisplayName(ctx context.Context, id string) (string, error) {
+	return get_aad_ca_named_location_from_id(ctx, id, s.Client)
+}
+
+func (s *GraphSource) NamedLocation(ctx context.Context, id string) (models.NamedLocationable, error) {
+	return s.Client.Identity().ConditionalAccess().NamedLocations().ByNamedLocationId(id).Get(ctx, nil)
+}
+
+func (s *GraphSource) DirectoryRoleNames(ctx context.Context) (map[string]string, error) {
+	result, err := s.Client.DirectoryRoleTemplates().Get(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error getting directory role templates: %v", err)
+	}
+	roles := map[string]string{}
+	for _, template := range result.GetValue() {
+		if id, name := template.GetId(), template.GetDisplayName(); id != nil && name != nil {
+			roles[*id] = *name
+		}
+	}
+	return roles, nil
+}
+
+// BackupSource reads policies from the backups written with Config.Backup.
+// It resolves only the objects named in the backup sidecars.
+type BackupSource struct {
+	policies  []models.ConditionalAccessPolicy
+	users     map[string]string
+	groups    map[string]string
+	locations map[string]string
+}
+
+// NewBackupSource reads the policy backups at paths. The backups leave out
+// the policy and template IDs, so they are taken from the sidecar.
+func NewBackupSource(paths []string) (*BackupSource, error) {
+	s := &BackupSource{
+		users:     map[string]string{},
+		groups:    map[string]string{},
+		locations: map[string]string{},
+	}
+	if err := checkBackupIDs(paths); err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		policy, refs, err := readBackup(path)
+		if err != nil {
+			return nil, err
+		}
+		if refs.ID != "" {
+			policy.SetId(&refs.ID)
+		}
+		if refs.TemplateID != "" {
+			policy.SetTemplateId(&refs.TemplateID)
+		}
+		s.policies = append(s.policies, *policy)
+		copyNames(s.users, refs.Users)
+		copyNames(s.groups, refs.Groups)
+		copyNames(s.locations, refs.NamedLocations)
+	}
+	return s, nil
+}
+
+func copyNames(dst, src map[string]string) {
+	for id, name := range src {
+		dst[id] = name
+	}
+}
+
+func (s *BackupSource) Policies(ctx context.Context) ([]models.ConditionalAccessPolicy, error) {
+	return s.policies, nil
+}
+
+func (s *BackupSource) UserPrincipalName(ctx context.Context, id string) (string, error) {
+	return backupName(s.users, id)
+}
+
+func (s *BackupSource) GroupDisplayName(ctx context.Context, id string) (string, error) {
+	return backupName(s.groups, id)
+}
+
+func (s *BackupSource) NamedLocationDisplayName(ctx context.Context, id string) (string, error) {
+	return backupName(s.locations, id)
+}
+
+// NamedLocation fails, as the backups hold only the names of named
+// locations.
+func (s *BackupSource) NamedLocation(ctx context.Context, id string) (models.NamedLocationable, error) {
+	return nil, fmt.Errorf("the backups do not hold the definition of named location %s", id)
+}
+
+// DirectoryRoleNames returns the names of the privileged roles, the only
+// roles known without the tenant.
+func (s *BackupSource) DirectoryRoleNames(ctx context.Context) (map[string]string, error) {
+	roles := map[string]string{}
+	copyNames(roles, privilegedRoles)
+	return roles, nil
+}
+
+func backupName(names map[string]string, id string) (string, error) {
+	name, ok := names[id]
+	if !ok {
+		return "", fmt.Errorf("object is not in the backup references")
+	}
+	return name, nil
+}
+
+// policySourceFlags select where a command reads policies from: the tenant,
+// or offline from the backups written by generate -backup.
+type policySourceFlags struct {
+	backupDir *string
+}
+
+func addPolicySourceFlags(fs *flag.FlagSet) *policySourceFlags {
+	return &policySourceFlags{
+		backupDir: fs.String("backup-dir", "", "read policies from the backups in this directory instead of the tenant"),
+	}
+}
+
+// load returns the policies and a directory cache resolving the objects they
+// refer to. Backup files given as arguments are read instead of the tenant
+// too; offline, names come from the backup sidecars.
+func (s *policySourceFlags) load(ctx context.Context, cfg *Config, files []string) ([]models.ConditionalAccessPolicy, *directoryCache, error) {
+	var source policyDirectory
+	if *s.backupDir == "" && len(files) == 0 {
+		graphClient, err := NewGraphClient(ctx, cfg.GraphEndpoint)
+		if err != nil {
+			return nil, nil, err
+		}
+		source = &GraphSource{Client: graphClient}
+	} else {
+		paths := files
+		if len(paths) == 0 {
+			var err error
+			if paths, err = backupFiles(*s.backupDir); err != nil {
+				return nil, nil, err
+			}
+		}
+		backups, err := NewBackupSource(paths)
+		if err != nil {
+			return nil, nil, err
+		}
+		source = backups
+	}
+	return loadPolicies(ctx, source)
+}
+
+// loadPolicies returns the policies of source with a directory cache
+// resolving the objects they refer to.
+func loadPolicies(ctx context.Context, source policyDirectory) ([]models.ConditionalAccessPolicy, *directoryCache, error) {
+	policies, err := source.Policies(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return policies, newDirectoryCache(ctx, source), nil
+}
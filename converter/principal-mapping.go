@@ -0,0 +1,195 @@
+package converter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// principalMapping maps the objects policies refer to in the tenant they are
+// read from onto the tenant the configuration is promoted to. Users, groups
+// and named locations are keyed by source name or object ID and map onto the
+// target user principal name or display name, which data sources look them
+// up by. The other objects are written by ID, so they map source IDs onto
+// target IDs.
+type principalMapping struct {
+	// TenantID is the target tenant the providers are configured for.
+	TenantID string `json:"tenant_id,omitempty"`
+
+	Users          map[string]string `json:"users,omitempty"`
+	Groups         map[string]string `json:"groups,omitempty"`
+	NamedLocations map[string]string `json:"named_locations,omitempty"`
+
+	TermsOfUse              map[string]string `json:"terms_of_use,omitempty"`
+	AuthenticationStrengths map[string]string `json:"authentication_strengths,omitempty"`
+	ServicePrincipals       map[string]string `json:"service_principals,omitempty"`
+	ExternalTenants         map[string]string `json:"external_tenants,omitempty"`
+
+	// unmapped collects the references without a mapping by kind and ID.
+	unmapped map[string]*UnmappedReference
+}
+
+// UnmappedReference is a reference with no target in the mapping, with the
+// policies using it. Name is empty when the object could not be resolved.
+type UnmappedReference struct {
+	Kind     string
+	ID       string
+	Name     string
+	Policies []string
+}
+
+// loadPrincipalMapping reads the JSON mapping file at path.
+func loadPrincipalMapping(path string) (*principalMapping, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading mapping file: %v", err)
+	}
+	m := &principalMapping{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("error parsing mapping file %s: %v", path, err)
+	}
+	m.unmapped = map[string]*UnmappedReference{}
+	return m, nil
+}
+
+// apply returns a copy of p referring to the objects in the target tenant.
+// Users, groups and named locations get their target names, so data sources
+// look them up there, and the other objects their target IDs. References
+// without a mapping are kept as they are and recorded as unmapped. p itself
+// is not changed.
+func (m *principalMapping) apply(p *caPolicy) *caPolicy {
+	mapped := *p
+	users := &mapped.Conditions.Users
+	users.IncludeUsers = m.mapRefs("user", m.Users, users.IncludeUsers, p.DisplayName)
+	users.ExcludeUsers = m.mapRefs("user", m.Users, users.ExcludeUsers, p.DisplayName)
+	users.IncludeGroups = m.mapRefs("group", m.Groups, users.IncludeGroups, p.DisplayName)
+	users.ExcludeGroups = m.mapRefs("group", m.Groups, users.ExcludeGroups, p.DisplayName)
+	users.IncludeGuests = m.mapGuests(users.IncludeGuests, p.DisplayName)
+	users.ExcludeGuests = m.mapGuests(users.ExcludeGuests, p.DisplayName)
+	if p.Conditions.Locations != nil {
+		locations := *p.Conditions.Locations
+		locations.IncludeLocations = m.mapRefs("named location", m.NamedLocations, locations.IncludeLocations, p.DisplayName)
+		locations.ExcludeLocations = m.mapRefs("named location", m.NamedLocations, locations.ExcludeLocations, p.DisplayName)
+		mapped.Conditions.Locations = &locations
+	}
+	if p.Conditions.ClientApplications != nil {
+		clientApplications := *p.Conditions.ClientApplications
+		clientApplications.IncludeServicePrincipals = m.mapIDs("service principal", m.ServicePrincipals, clientApplications.IncludeServicePrincipals, p.DisplayName)
+		clientApplications.ExcludeServicePrincipals = m.mapIDs("service principal", m.ServicePrincipals, clientApplications.ExcludeServicePrincipals, p.DisplayName)
+		mapped.Conditions.ClientApplications = &clientApplications
+	}
+	if p.Grant != nil {
+		grant := *p.Grant
+		grant.TermsOfUse = m.mapIDs("terms of use", m.TermsOfUse, grant.TermsOfUse, p.DisplayName)
+		// the built-in authentication strengths have the same ID everywhere
+		if id := grant.AuthenticationStrengthPolicyID; id != "" && !strings.HasPrefix(id, builtInAuthenticationStrengthPrefix) {
+			grant.AuthenticationStrengthPolicyID = m.mapIDs("authentication strength", m.AuthenticationStrengths, []string{id}, p.DisplayName)[0]
+		}
+		mapped.Grant = &grant
+	}
+	return &mapped
+}
+
+// builtInAuthenticationStrengthPrefix starts the IDs of the authentication
+// strengths Microsoft provides in every tenant.
+const builtInAuthenticationStrengthPrefix = "00000000-0000-0000-0000-"
+
+// mapRefs returns refs with the target name of each mapped reference.
+func (m *principalMapping) mapRefs(kind string, targets map[string]string, refs []principalRef, policy string) []principalRef {
+	var mapped []principalRef
+	for _, ref := range refs {
+		if !isReferenceKeyword(ref.ID) {
+			target, ok := targets[ref.ID]
+			if !ok && ref.Name != "" {
+				target, ok = targets[ref.Name]
+			}
+			if ok {
+				ref.Name = target
+			} else {
+				m.recordUnmapped(kind, ref.ID, ref.Name, policy)
+			}
+		}
+		mapped = append(mapped, ref)
+	}
+	return mapped
+}
+
+// mapIDs returns ids with the target ID of each mapped object.
+func (m *principalMapping) mapIDs(kind string, targets map[string]string, ids []string, policy string) []string {
+	var mapped []string
+	for _, id := range ids {
+		if target, ok := targets[id]; ok {
+			id = target
+		} else if !isReferenceKeyword(id) && id != "ServicePrincipalsInMyTenant" {
+			m.recordUnmapped(kind, id, "", policy)
+		}
+		mapped = append(mapped, id)
+	}
+	return mapped
+}
+
+// mapGuests returns a copy of guests with the target IDs of the external
+// tenants it lists.
+func (m *principalMapping) mapGuests(guests *caGuestsOrExternalUsers, policy string) *caGuestsOrExternalUsers {
+	if guests == nil || guests.ExternalTenants == nil {
+		return guests
+	}
+	mapped := *guests
+	tenants := *guests.ExternalTenants
+	tenants.Members = m.mapIDs("external tenant", m.ExternalTenants, tenants.Members, policy)
+	mapped.ExternalTenants = &tenants
+	return &mapped
+}
+
+func (m *principalMapping) recordUnmapped(kind, id, name, policy string) {
+	key := kind + "/" + id
+	unmapped := m.unmapped[key]
+	if unmapped == nil {
+		unmapped = &UnmappedReference{Kind: kind, ID: id, Name: name}
+		m.unmapped[key] = unmapped
+	}
+	if !contains(unmapped.Policies, policy) {
+		unmapped.Policies = append(unmapped.Policies, policy)
+	}
+}
+
+// unmappedReferences returns the unmapped references sorted by kind and name.
+func (m *principalMapping) unmappedReferences() []*UnmappedReference {
+	var refs []*UnmappedReference
+	for _, ref := range m.unmapped {
+		refs = append(refs, ref)
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].Kind != refs[j].Kind {
+			return refs[i].Kind < refs[j].Kind
+		}
+		return refs[i].Name+refs[i].ID < refs[j].Name+refs[j].ID
+	})
+	return refs
+}
+
+func writeUnmappedReport(w io.Writer, refs []*UnmappedReference) {
+	fmt.Fprintln(w, "Unmapped references:")
+	for _, ref := range refs {
+		name := ref.ID
+		if ref.Name != "" {
+			name = fmt.Sprintf("%s (%s)", ref.Name, ref.ID)
+		}
+		fmt.Fprintf(w, "  - %s %s, used by %s\n", ref.Kind, name, listText(ref.Policies))
+	}
+}
+
+// promotePolicy applies the mapping, if any, to p and returns the policy to
+// render. Promoted policies are rendered without their ID, as they are
+// created in the target tenant rather than imported from it.
+func (g *policyGenerator) promotePolicy(p *caPolicy) *caPolicy {
+	if g.mapping == nil {
+		return p
+	}
+	promoted := g.mapping.apply(p)
+	promoted.ID = ""
+	return promoted
+}
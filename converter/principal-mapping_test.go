@@ -0,0 +1,186 @@
+package converter
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func mappingTestPolicy() *caPolicy {
+	p := &caPolicy{ID: "a1", DisplayName: "Partner access", State: "enabled"}
+	p.Conditions.Users = caUsers{
+		IncludeUsers:  []principalRef{{ID: "All"}},
+		ExcludeGroups: []principalRef{{ID: testGroupID, Name: "CA Pilot"}},
+		IncludeGuests: &caGuestsOrExternalUsers{
+			GuestOrExternalUserTypes: []string{"b2bCollaborationGuest"},
+			ExternalTenants:          &caExternalTenants{MembershipKind: "enumerated", Members: []string{"partner-tenant"}},
+		},
+	}
+	p.Conditions.ClientApplications = &caClientApplications{IncludeServicePrincipals: []string{"source-sp", "ServicePrincipalsInMyTenant"}}
+	p.Grant = &caGrantControls{
+		Operator:                       "AND",
+		TermsOfUse:                     []string{"source-terms"},
+		AuthenticationStrengthPolicyID: "source-strength",
+	}
+	return p
+}
+
+func TestMappingMapsObjectsReferencedByID(t *testing.T) {
+	m := &principalMapping{
+		Groups:                  map[string]string{"CA Pilot": "CA Pilot (prod)"},
+		TermsOfUse:              map[string]string{"source-terms": "target-terms"},
+		AuthenticationStrengths: map[string]string{"source-strength": "target-strength"},
+		ServicePrincipals:       map[string]string{"source-sp": "target-sp"},
+		ExternalTenants:         map[string]string{"partner-tenant": "partner-tenant-prod"},
+		unmapped:                map[string]*UnmappedReference{},
+	}
+	p := mappingTestPolicy()
+	mapped := m.apply(p)
+
+	if got := mapped.Conditions.Users.ExcludeGroups[0].Name; got != "CA Pilot (prod)" {
+		t.Errorf("group = %q", got)
+	}
+	if got := mapped.Grant.TermsOfUse; !reflect.DeepEqual(got, []string{"target-terms"}) {
+		t.Errorf("terms of use = %v", got)
+	}
+	if got := mapped.Grant.AuthenticationStrengthPolicyID; got != "target-strength" {
+		t.Errorf("authentication strength = %q", got)
+	}
+	if got := mapped.Conditions.ClientApplications.IncludeServicePrincipals; !reflect.DeepEqual(got, []string{"target-sp", "ServicePrincipalsInMyTenant"}) {
+		t.Errorf("service principals = %v", got)
+	}
+	if got := mapped.Conditions.Users.IncludeGuests.ExternalTenants.Members; !reflect.DeepEqual(got, []string{"partner-tenant-prod"}) {
+		t.Errorf("external tenants = %v", got)
+	}
+	if refs := m.unmappedReferences(); len(refs) != 0 {
+		t.Errorf("unmapped = %+v, want none", refs)
+	}
+
+	if !reflect.DeepEqual(p, mappingTestPolicy()) {
+		t.Errorf("apply changed the source policy: %+v", p)
+	}
+}
+
+func TestMappingReportsUnmappedObjects(t *testing.T) {
+	m := &principalMapping{unmapped: map[string]*UnmappedReference{}}
+	p := mappingTestPolicy()
+	p.Grant.AuthenticationStrengthPolicyID = phishingResistantStrengthID
+	mapped := m.apply(p)
+
+	kinds := map[string]string{}
+	for _, ref := range m.unmappedReferences() {
+		kinds[ref.Kind] = ref.ID
+	}
+	want := map[string]string{
+		"group":             testGroupID,
+		"terms of use":      "source-terms",
+		"service principal": "source-sp",
+		"external tenant":   "partner-tenant",
+	}
+	if !reflect.DeepEqual(kinds, want) {
+		t.Errorf("unmapped = %v, want %v", kinds, want)
+	}
+	if mapped.Grant.AuthenticationStrengthPolicyID != phishingResistantStrengthID {
+		t.Errorf("built-in authentication strength changed to %q", mapped.Grant.AuthenticationStrengthPolicyID)
+	}
+}
+
+func TestGenerateAppliesMapping(t *testing.T) {
+	mapping := filepath.Join(t.TempDir(), "mapping.json")
+	content := `{
+		"users": {"breakglass@contoso.com": "breakglass@fabrikam.com"},
+		"groups": {"CA Pilot": "CA Pilot Prod"},
+		"terms_of_use": {"source-terms": "target-terms"}
+	}`
+	if err := os.WriteFile(mapping, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		mode, resource, file string
+		// group is how the file refers to the target group
+		group string
+	}{
+		{modeResources, policyResourceAzureAD, "gen/Require MFA.tf", "data.azuread_group.CA_Pilot_Prod.id"},
+		{modeResources, policyResourceMSGraph, "gen/Require MFA.tf", "data.azuread_group.CA_Pilot_Prod.id"},
+		{modeModule, policyResourceAzureAD, "gen/" + policyModuleFileName, "data.azuread_group.CA_Pilot_Prod.id"},
+		{modeYAML, policyResourceAzureAD, "gen/policies/require_mfa.yaml", "CA Pilot Prod"},
+	} {
+		t.Run(tc.mode+" "+tc.resource, func(t *testing.T) {
+			policy := testPolicy("a1", "Require MFA")
+			policy.GetGrantControls().SetTermsOfUse([]string{"source-terms"})
+			cfg := testConfig(tc.mode)
+			cfg.Mapping = mapping
+			cfg.PolicyResource = tc.resource
+			out := NewMemoryWriter()
+			result, err := generate(out, cfg, policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(result.Unmapped) != 0 {
+				t.Errorf("unmapped = %+v, want none", result.Unmapped)
+			}
+
+			src, err := out.ReadFile(tc.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range []string{"target-terms", tc.group} {
+				if !strings.Contains(string(src), want) {
+					t.Errorf("%s does not refer to %s:\n%s", tc.file, want, src)
+				}
+			}
+			if strings.Contains(string(src), "source-terms") {
+				t.Errorf("%s refers to the source terms of use:\n%s", tc.file, src)
+			}
+		})
+	}
+}
+
+func TestSetGraphObjectIDs(t *testing.T) {
+	m := &principalMapping{
+		TermsOfUse:              map[string]string{"source-terms": "target-terms"},
+		AuthenticationStrengths: map[string]string{"source-strength": "target-strength"},
+		ServicePrincipals:       map[string]string{"source-sp": "target-sp"},
+		ExternalTenants:         map[string]string{"partner-tenant": "partner-tenant-prod"},
+		unmapped:                map[string]*UnmappedReference{},
+	}
+	body := map[string]any{
+		"conditions": map[string]any{
+			"clientApplications": map[string]any{"includeServicePrincipals": []any{"source-sp", "ServicePrincipalsInMyTenant"}},
+			"users": map[string]any{
+				"includeUsers": []any{"All"},
+				"includeGuestsOrExternalUsers": map[string]any{
+					"guestOrExternalUserTypes": "b2bCollaborationGuest",
+					"externalTenants":          map[string]any{"membershipKind": "enumerated", "members": []any{"partner-tenant"}},
+				},
+			},
+		},
+		"grantControls": map[string]any{
+			"operator":               "AND",
+			"termsOfUse":             []any{"source-terms"},
+			"authenticationStrength": map[string]any{"id": "source-strength"},
+		},
+	}
+	setGraphObjectIDs(body, m.apply(mappingTestPolicy()))
+
+	for _, tc := range []struct {
+		keys []string
+		key  string
+		want any
+	}{
+		{[]string{"grantControls"}, "termsOfUse", []any{"target-terms"}},
+		{[]string{"grantControls", "authenticationStrength"}, "id", "target-strength"},
+		{[]string{"conditions", "clientApplications"}, "includeServicePrincipals", []any{"target-sp", "ServicePrincipalsInMyTenant"}},
+		{[]string{"conditions", "users", "includeGuestsOrExternalUsers", "externalTenants"}, "members", []any{"partner-tenant-prod"}},
+		{[]string{"conditions", "users"}, "includeUsers", []any{"All"}},
+	} {
+		if got := graphObject(body, tc.keys...)[tc.key]; !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s.%s = %v, want %v", strings.Join(tc.keys, "."), tc.key, got, tc.want)
+		}
+	}
+	if _, ok := graphObject(body, "conditions", "clientApplications")["excludeServicePrincipals"]; ok {
+		t.Error("excludeServicePrincipals was added to the body")
+	}
+}
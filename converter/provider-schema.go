@@ -0,0 +1,249 @@
+package converter
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// providerSchema maps the fields of the normalized policy model onto the
+// attribute and block names of azuread_conditional_access_policy for one major
+// version of the azuread provider. Fields are identified by their path in the
+// v3 schema, e.g. "conditions.users.included_groups". A field missing from
+// names cannot be expressed by that provider version.
+type providerSchema struct {
+	target         string
+	defaultVersion string
+	names          map[string]string
+
+	// since holds the release that added a field within the major version,
+	// for fields that are not in every release of it.
+	since map[string]string
+
+	// version is the version constraint the schema was narrowed to.
+	version string
+}
+
+// commonSchemaPaths are the fields both provider major versions accept under
+// the same name in every release the generator supports.
+var commonSchemaPaths = []string{
+	"display_name",
+	"state",
+	"conditions",
+	"conditions.client_app_types",
+	"conditions.sign_in_risk_levels",
+	"conditions.user_risk_levels",
+	"conditions.service_principal_risk_levels",
+	"conditions.applications",
+	"conditions.applications.included_applications",
+	"conditions.applications.excluded_applications",
+	"conditions.applications.included_user_actions",
+	"conditions.applications.filter",
+	"conditions.applications.filter.mode",
+	"conditions.applications.filter.rule",
+	"conditions.client_applications",
+	"conditions.client_applications.included_service_principals",
+	"conditions.client_applications.excluded_service_principals",
+	"conditions.client_applications.filter",
+	"conditions.client_applications.filter.mode",
+	"conditions.client_applications.filter.rule",
+	"conditions.devices",
+	"conditions.devices.filter",
+	"conditions.devices.filter.mode",
+	"conditions.devices.filter.rule",
+	"conditions.locations",
+	"conditions.locations.included_locations",
+	"conditions.locations.excluded_locations",
+	"conditions.platforms",
+	"conditions.platforms.included_platforms",
+	"conditions.platforms.excluded_platforms",
+	"conditions.users",
+	"conditions.users.included_users",
+	"conditions.users.excluded_users",
+	"conditions.users.included_groups",
+	"conditions.users.excluded_groups",
+	"conditions.users.included_roles",
+	"conditions.users.excluded_roles",
+	"grant_controls",
+	"grant_controls.operator",
+	"grant_controls.built_in_controls",
+	"grant_controls.custom_authentication_factors",
+	"grant_controls.terms_of_use",
+	"session_controls",
+	"session_controls.application_enforced_restrictions_enabled",
+	"session_controls.cloud_app_security_policy",
+	"session_controls.disable_resilience_defaults",
+	"session_controls.persistent_browser_mode",
+	"session_controls.sign_in_frequency",
+	"session_controls.sign_in_frequency_period",
+}
+
+// guestsSchemaPaths are the fields of the included and excluded
+// guests_or_external_users blocks.
+var guestsSchemaPaths = func() []string {
+	var paths []string
+	for _, block := range []string{"conditions.users.included_guests_or_external_users", "conditions.users.excluded_guests_or_external_users"} {
+		for _, suffix := range []string{"", ".guest_or_external_user_types", ".external_tenants", ".external_tenants.membership_kind", ".external_tenants.members"} {
+			paths = append(paths, block+suffix)
+		}
+	}
+	return paths
+}()
+
+// signInFrequencySchemaPaths are the session controls for the kind of
+// authentication sign-in frequency applies to and for "every time".
+var signInFrequencySchemaPaths = []string{
+	"session_controls.sign_in_frequency_authentication_type",
+	"session_controls.sign_in_frequency_interval",
+}
+
+// providerSchemas holds the supported provider targets. Within v2, the
+// authentication strength, guests_or_external_users and sign-in frequency
+// type and interval fields only exist in later 2.x releases, so they are
+// dropped for a version constraint that allows older ones. v3 has all of
+// them, and adds insider risk levels and authentication context references.
+var providerSchemas = map[string]*providerSchema{
+	"v2": newProviderSchema("v2", "~> 2.47", map[string][]string{
+		"":       commonSchemaPaths,
+		"2.36.0": {"grant_controls.authentication_strength_policy_id"},
+		"2.44.0": guestsSchemaPaths,
+		"2.47.0": signInFrequencySchemaPaths,
+	}),
+	"v3": newProviderSchema("v3", "~> 3.1", map[string][]string{
+		"": append(append(append(append([]string{}, commonSchemaPaths...), guestsSchemaPaths...), signInFrequencySchemaPaths...),
+			"grant_controls.authentication_strength_policy_id",
+			"conditions.applications.included_authentication_context_class_references",
+		),
+		"3.1.0": {"conditions.insider_risk_levels"},
+	}),
+}
+
+// newProviderSchema builds a schema from the fields added by each release,
+// keyed by version; "" holds the fields every release of target has.
+func newProviderSchema(target, defaultVersion string, pathsSince map[string][]string) *providerSchema {
+	schema := &providerSchema{target: target, defaultVersion: defaultVersion, names: map[string]string{}, since: map[string]string{}}
+	for since, paths := range pathsSince {
+		for _, path := range paths {
+			schema.names[path] = lastPathSegment(path)
+			if since != "" {
+				schema.since[path] = since
+			}
+		}
+	}
+	return schema
+}
+
+// lookupProviderSchema returns the schema for target, e.g. "v2" or "v3",
+// without the fields the lowest release allowed by the version constraint
+// lacks. An empty version uses the default constraint of target.
+func lookupProviderSchema(target, version string) (*providerSchema, error) {
+	base, ok := providerSchemas[target]
+	if !ok {
+		var targets []string
+		for name := range providerSchemas {
+			targets = append(targets, name)
+		}
+		sort.Strings(targets)
+		return nil, fmt.Errorf("unknown provider target %q, expected one of %s", target, strings.Join(targets, ", "))
+	}
+	if version == "" {
+		version = base.defaultVersion
+	}
+	minimum := minimumVersion(version)
+	if minimum != nil && "v"+strconv.Itoa(minimum[0]) != target {
+		return nil, fmt.Errorf("provider version %q does not match provider target %s", version, target)
+	}
+
+	schema := &providerSchema{target: target, defaultVersion: base.defaultVersion, names: map[string]string{}, version: version}
+	for path, name := range base.names {
+		if since, ok := base.since[path]; ok && minimum != nil && compareVersions(minimum, minimumVersion(since)) < 0 {
+			continue
+		}
+		schema.names[path] = name
+	}
+	return schema, nil
+}
+
+var versionNumber = regexp.MustCompile(`\d+(\.\d+)*`)
+
+// minimumVersion returns the first version number in a constraint such as
+// "~> 2.40" or ">= 2.30, < 3.0" as its numeric parts, which is the lowest
+// release the constraint allows for the usual constraints. It returns nil
+// when the constraint has no version number.
+func minimumVersion(constraint string) []int {
+	match := versionNumber.FindString(constraint)
+	if match == "" {
+		return nil
+	}
+	var parts []int
+	for _, part := range strings.Split(match, ".") {
+		n, _ := strconv.Atoi(part)
+		parts = append(parts, n)
+	}
+	return parts
+}
+
+// compareVersions compares two versions part by part, treating missing parts
+// as 0.
+func compareVersions(a, b []int) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// name returns the attribute or block name for path and whether this
+// provider version supports it.
+func (s *providerSchema) name(path string) (string, bool) {
+	name, ok := s.names[path]
+	return name, ok
+}
+
+// knows reports whether path is a field the generator writes for this
+// provider version, as opposed to one added to the configuration by hand.
+func (s *providerSchema) knows(path string) bool {
+	_, ok := s.names[path]
+	return ok
+}
+
+// hasChildren reports whether path is a block with fields of its own in this
+// provider version.
+func (s *providerSchema) hasChildren(path string) bool {
+	for name := range s.names {
+		if strings.HasPrefix(name, path+".") {
+			return true
+		}
+	}
+	return false
+}
+
+func lastPathSegment(path string) string {
+	return path[strings.LastIndex(path, ".")+1:]
+}
+
+// unsupportedFeatureError is returned when a policy uses a field the target
+// provider version cannot express.
+type unsupportedFeatureError struct {
+	policy  string
+	target  string
+	version string
+	paths   []string
+}
+
+func (e *unsupportedFeatureError) Error() string {
+	return fmt.Sprintf("policy %q uses %s, which azuread provider %s (%s) cannot express", e.policy, strings.Join(e.paths, ", "), e.target, e.version)
+}
@@ -0,0 +1,125 @@
+package converter
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// schemaTestPolicy uses a field from every area that differs between
+// provider versions.
+func schemaTestPolicy() *caPolicy {
+	return &caPolicy{
+		DisplayName: "Require strong auth for guests",
+		State:       "enabled",
+		Conditions: caConditions{
+			ClientAppTypes: []string{"all"},
+			Applications:   &caApplications{IncludeApplications: []string{"All"}},
+			Users: caUsers{
+				IncludeGuests: &caGuestsOrExternalUsers{GuestOrExternalUserTypes: []string{"b2bCollaborationGuest"}},
+			},
+		},
+		Grant: &caGrantControls{Operator: "OR", AuthenticationStrengthPolicyID: "00000000-0000-0000-0000-000000000002"},
+		Session: &caSessionControls{
+			SignInFrequencyAuthenticationType: "primaryAndSecondaryAuthentication",
+			SignInFrequencyInterval:           "everyTime",
+		},
+	}
+}
+
+func renderForVersion(t *testing.T, p *caPolicy, target, version string) (string, error) {
+	t.Helper()
+	schema, err := lookupProviderSchema(target, version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, _, err := renderPolicyFile(p, policyResourceName(p.DisplayName), schema)
+	if err != nil {
+		return "", err
+	}
+	return string(f.Bytes()), nil
+}
+
+func unsupportedPaths(t *testing.T, err error) []string {
+	t.Helper()
+	var unsupported *unsupportedFeatureError
+	if !errors.As(err, &unsupported) {
+		t.Fatalf("got error %v, want an unsupported feature error", err)
+	}
+	return unsupported.paths
+}
+
+func TestRenderPolicyForEachProviderVersion(t *testing.T) {
+	for _, target := range []string{"v2", "v3"} {
+		hcl, err := renderForVersion(t, schemaTestPolicy(), target, "")
+		if err != nil {
+			t.Fatalf("%s: %v", target, err)
+		}
+		for _, name := range []string{"included_guests_or_external_users", "authentication_strength_policy_id", "sign_in_frequency_interval"} {
+			if !strings.Contains(hcl, name) {
+				t.Errorf("%s: %s missing from\n%s", target, name, hcl)
+			}
+		}
+	}
+}
+
+func TestRenderPolicyRejectsFieldsOfNewerReleases(t *testing.T) {
+	_, err := renderForVersion(t, schemaTestPolicy(), "v2", "~> 2.30")
+	want := []string{
+		"conditions.users.included_guests_or_external_users",
+		"grant_controls.authentication_strength_policy_id",
+		"session_controls.sign_in_frequency_authentication_type",
+		"session_controls.sign_in_frequency_interval",
+	}
+	if got := unsupportedPaths(t, err); !reflect.DeepEqual(got, want) {
+		t.Errorf("unsupported paths = %v, want %v", got, want)
+	}
+	if !strings.Contains(err.Error(), "v2 (~> 2.30)") {
+		t.Errorf("error %q does not name the provider version", err)
+	}
+
+	_, err = renderForVersion(t, schemaTestPolicy(), "v2", "~> 2.44")
+	want = []string{
+		"session_controls.sign_in_frequency_authentication_type",
+		"session_controls.sign_in_frequency_interval",
+	}
+	if got := unsupportedPaths(t, err); !reflect.DeepEqual(got, want) {
+		t.Errorf("unsupported paths = %v, want %v", got, want)
+	}
+}
+
+func TestRenderPolicyRejectsV3OnlyFieldsForV2(t *testing.T) {
+	p := schemaTestPolicy()
+	p.Conditions.InsiderRiskLevels = []string{"elevated"}
+	p.Conditions.Applications.IncludeAuthenticationContext = []string{"c1"}
+
+	_, err := renderForVersion(t, p, "v2", "")
+	want := []string{
+		"conditions.insider_risk_levels",
+		"conditions.applications.included_authentication_context_class_references",
+	}
+	if got := unsupportedPaths(t, err); !reflect.DeepEqual(got, want) {
+		t.Errorf("unsupported paths = %v, want %v", got, want)
+	}
+
+	hcl, err := renderForVersion(t, p, "v3", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"insider_risk_levels", "included_authentication_context_class_references"} {
+		if !strings.Contains(hcl, name) {
+			t.Errorf("v3: %s missing from\n%s", name, hcl)
+		}
+	}
+
+	if _, err := renderForVersion(t, p, "v3", "~> 3.0"); err == nil {
+		t.Error("v3 ~> 3.0 accepted insider risk levels")
+	}
+}
+
+func TestLookupProviderSchemaRejectsMismatchedVersion(t *testing.T) {
+	if _, err := lookupProviderSchema("v2", "~> 3.0"); err == nil {
+		t.Error("v2 accepted a 3.x version constraint")
+	}
+}
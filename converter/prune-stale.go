@@ -0,0 +1,210 @@
+package converter
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// Prune modes for resources whose policy was deleted from the tenant.
+const (
+	// pruneReport only lists the stale resources.
+	pruneReport = ""
+	// pruneDelete removes the resources from the configuration. Applying
+	// afterwards destroys nothing, since the policies are already gone, but
+	// any state entries are dropped.
+	pruneDelete = "delete"
+	// pruneArchive moves the resources to an archive folder outside the root
+	// module, keeping a copy for reference.
+	pruneArchive = "archive"
+	// pruneRemoved replaces the resources with removed blocks so Terraform
+	// forgets them without trying to destroy anything.
+	pruneRemoved = "removed"
+)
+
+const archiveDirName = "archive"
+
+// staleResources returns the resources in existing whose policy no longer
+// exists in the tenant. Resources are matched by the policy ID in their import
+// block or the manifest, or by display name when neither has one.
+func staleResources(existing *existingConfig, policies []models.ConditionalAccessPolicy, manifest *policyManifest) []existingResource {
+	ids, names := map[string]bool{}, map[string]bool{}
+	for _, policy := range policies {
+		ids[stringValue(policy.GetId())] = true
+		names[stringValue(policy.GetDisplayName())] = true
+	}
+
+	var stale []existingResource
+	for _, resource := range existing.resources {
+		if resource.id == "" {
+			resource.id = manifest.idForLabel(resource.label)
+		}
+		if resource.id != "" && !ids[resource.id] || resource.id == "" && !names[resource.displayName] {
+			stale = append(stale, resource)
+		}
+	}
+	return stale
+}
+
+// pruneStalePolicies finds resources in dir for policies deleted from the
+// tenant and handles them according to mode.
+func pruneStalePolicies(out OutputWriter, log io.Writer, dir string, policies []models.ConditionalAccessPolicy, manifest *policyManifest, mode string) error {
+	switch mode {
+	case pruneReport, pruneDelete, pruneArchive, pruneRemoved:
+	default:
+		return fmt.Errorf("unknown prune mode %q, expected delete, archive or removed", mode)
+	}
+
+	existing, err := readExistingConfig(out, dir)
+	if err != nil {
+		return err
+	}
+
+	stale := staleResources(existing, policies, manifest)
+	if mode == pruneReport {
+		for _, resource := range stale {
+			fmt.Fprintf(log, "Stale resource %s in %s: policy %q no longer exists, use -prune to clean it up\n", resource.address(), resource.file, resource.displayName)
+		}
+		return nil
+	}
+
+	changed := map[string]bool{}
+	mapKeys := map[*hclwrite.Block]map[string]bool{}
+	for _, resource := range stale {
+		switch {
+		case resource.isDocument():
+			if mode == pruneArchive {
+				if err := archiveDocument(out, dir, resource); err != nil {
+					return err
+				}
+			}
+			if err := out.Remove(resource.file); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		case resource.inModule:
+			if mapKeys[resource.block] == nil {
+				mapKeys[resource.block] = map[string]bool{}
+			}
+			mapKeys[resource.block][resource.label] = true
+			changed[resource.file] = true
+		default:
+			body := existing.files[resource.file].Body()
+			if mode == pruneArchive {
+				if err := archiveBlock(out, dir, resource); err != nil {
+					return err
+				}
+			}
+			body.RemoveBlock(resource.block)
+			if mode == pruneRemoved {
+				body.AppendNewline()
+				appendRemovedBlock(body, resource.resourceType, resource.label)
+			}
+			changed[resource.file] = true
+		}
+		for path, f := range existing.files {
+			if importBlock := findImportBlock(f.Body(), resource.address()); importBlock != nil {
+				f.Body().RemoveBlock(importBlock)
+				changed[path] = true
+			}
+		}
+		fmt.Fprintf(log, "Pruned %s (%s): policy %q no longer exists\n", resource.address(), mode, resource.displayName)
+	}
+	for block, keys := range mapKeys {
+		if err := removeMapEntries(block, keys); err != nil {
+			return err
+		}
+	}
+
+	for path := range changed {
+		if err := writeOrRemoveFile(out, path, existing.files[path]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeMapEntries removes the entries with the given keys from the
+// ca_policies map of the locals block.
+func removeMapEntries(block *hclwrite.Block, keys map[string]bool) error {
+	src := block.Body().GetAttribute(policyModuleLocal).Expr().BuildTokens(nil).Bytes()
+	expr, diags := hclsyntax.ParseExpression(src, policyModuleLocal, hcl.InitialPos)
+	if diags.HasErrors() {
+		return fmt.Errorf("error parsing %s: %s", policyModuleLocal, diags.Error())
+	}
+	object, ok := expr.(*hclsyntax.ObjectConsExpr)
+	if !ok {
+		return nil
+	}
+
+	var entries []hclwrite.Tokens
+	for _, item := range object.Items {
+		key, diags := item.KeyExpr.Value(nil)
+		if !diags.HasErrors() && key.Type() == cty.String && keys[key.AsString()] {
+			continue
+		}
+		entryRange := hcl.RangeBetween(item.KeyExpr.Range(), item.ValueExpr.Range())
+		entries = append(entries, rawTokens(string(src[entryRange.Start.Byte:entryRange.End.Byte])))
+	}
+	block.Body().SetAttributeRaw(policyModuleLocal, objectTokens(entries))
+	return nil
+}
+
+// findImportBlock returns the import block for address, if any.
+func findImportBlock(body *hclwrite.Body, address string) *hclwrite.Block {
+	for _, block := range body.Blocks() {
+		if block.Type() == "import" && expressionText(block.Body().GetAttribute("to")) == address {
+			return block
+		}
+	}
+	return nil
+}
+
+// appendRemovedBlock tells Terraform to forget the policy resource without
+// destroying it.
+func appendRemovedBlock(body *hclwrite.Body, resourceType, resourceName string) {
+	removedBlock := body.AppendNewBlock("removed", nil)
+	removedBody := removedBlock.Body()
+	removedBody.SetAttributeTraversal("from", resourceAddress(resourceType, resourceName))
+	removedBody.AppendNewline()
+	lifecycleBlock := removedBody.AppendNewBlock("lifecycle", nil)
+	lifecycleBlock.Body().SetAttributeValue("destroy", cty.False)
+}
+
+// archiveBlock appends the resource block to the file of the same name in
+// the archive folder.
+func archiveBlock(out OutputWriter, dir string, resource existingResource) error {
+	archiveDir := filepath.Join(dir, archiveDirName)
+	path := filepath.Join(archiveDir, filepath.Base(resource.file))
+	f := hclwrite.NewEmptyFile()
+	if src, err := out.ReadFile(path); err == nil {
+		var diags hcl.Diagnostics
+		f, diags = hclwrite.ParseConfig(src, path, hcl.InitialPos)
+		if diags.HasErrors() {
+			return fmt.Errorf("error parsing %s: %s", path, diags.Error())
+		}
+		f.Body().AppendNewline()
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	f.Body().AppendUnstructuredTokens(resource.block.BuildTokens(nil))
+	return out.WriteFile(path, formatHCL(f.Bytes()))
+}
+
+// archiveDocument copies the YAML policy document to the policies folder of
+// the archive folder.
+func archiveDocument(out OutputWriter, dir string, resource existingResource) error {
+	src, err := out.ReadFile(resource.file)
+	if err != nil {
+		return err
+	}
+	return out.WriteFile(filepath.Join(dir, archiveDirName, policyDocumentDir, filepath.Base(resource.file)), src)
+}
@@ -0,0 +1,419 @@
+package converter
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/zclconf/go-cty/cty"
+	"gopkg.in/yaml.v3"
+)
+
+// existingConfig is the Terraform configuration already checked in to an
+// output directory.
+type existingConfig struct {
+	files     map[string]*hclwrite.File
+	resources []existingResource
+	// dataSources maps "type.label" of each data block to the value of the
+	// attribute it looks the object up by.
+	dataSources map[string]string
+}
+
+// existingResource is a policy found in the existing configuration: an
+// azuread_conditional_access_policy or msgraph_resource resource, an entry of
+// the ca_policies map of the module mode or a YAML document of the yaml mode.
+// id is taken from the import block for the policy and is empty when there is
+// none.
+type existingResource struct {
+	file         string
+	resourceType string
+	label        string
+	id           string
+	displayName  string
+
+	// block is the resource block, or for a map entry the locals block
+	// holding the map. It is nil for YAML documents.
+	block *hclwrite.Block
+
+	// body holds the attributes and nested blocks of the policy, built from
+	// the object or document for map entries and YAML documents.
+	body *hclwrite.Body
+
+	// inModule marks map entries and YAML documents, which are instances of
+	// the ca_policies module keyed by label.
+	inModule bool
+}
+
+func (r existingResource) address() string {
+	if r.inModule {
+		return moduleInstanceAddress(r.label)
+	}
+	return r.resourceType + "." + r.label
+}
+
+// isDocument reports whether r is a YAML document of the yaml mode.
+func (r existingResource) isDocument() bool {
+	return r.inModule && r.block == nil
+}
+
+// readExistingConfig parses every .tf file in dir and the YAML policy
+// documents below it. A missing directory is treated as empty configuration.
+func readExistingConfig(out OutputWriter, dir string) (*existingConfig, error) {
+	config := &existingConfig{
+		files:       map[string]*hclwrite.File{},
+		dataSources: map[string]string{},
+	}
+
+	paths, err := out.Glob(filepath.Join(dir, "*.tf"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	importIDs := map[string]string{}
+	for _, path := range paths {
+		src, err := out.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		f, diags := hclwrite.ParseConfig(src, path, hcl.InitialPos)
+		if diags.HasErrors() {
+			return nil, fmt.Errorf("error parsing %s: %s", path, diags.Error())
+		}
+		config.files[path] = f
+
+		for _, block := range f.Body().Blocks() {
+			labels := block.Labels()
+			switch {
+			case block.Type() == "resource" && len(labels) == 2 && labels[0] == azureADPolicyResourceType:
+				displayName, _ := literalString(block.Body().GetAttribute("display_name"))
+				config.resources = append(config.resources, existingResource{
+					file:         path,
+					resourceType: azureADPolicyResourceType,
+					label:        labels[1],
+					displayName:  displayName,
+					block:        block,
+					body:         block.Body(),
+				})
+			case block.Type() == "resource" && len(labels) == 2 && labels[0] == msgraphResourceType:
+				if url, _ := literalString(block.Body().GetAttribute("url")); url != msgraphPoliciesURL {
+					continue
+				}
+				config.resources = append(config.resources, existingResource{
+					file:         path,
+					resourceType: msgraphResourceType,
+					label:        labels[1],
+					displayName:  msgraphDisplayName(block.Body()),
+					block:        block,
+					body:         block.Body(),
+				})
+			case block.Type() == "locals":
+				entries, err := moduleMapEntries(path, block)
+				if err != nil {
+					return nil, err
+				}
+				config.resources = append(config.resources, entries...)
+			case block.Type() == "import":
+				if id, ok := literalString(block.Body().GetAttribute("id")); ok {
+					// msgraph_resource is imported by the URL of the policy
+					importIDs[expressionText(block.Body().GetAttribute("to"))] = strings.TrimPrefix(id, msgraphPoliciesURL+"/")
+				}
+			case block.Type() == "data" && len(labels) == 2:
+				for _, kind := range dataSourceKinds {
+					if kind.dataType != labels[0] {
+						continue
+					}
+					if value, ok := literalString(block.Body().GetAttribute(kind.attribute)); ok {
+						config.dataSources[labels[0]+"."+labels[1]] = value
+					}
+				}
+			}
+		}
+	}
+
+	documents, err := readPolicyDocuments(out, filepath.Join(dir, policyDocumentDir))
+	if err != nil {
+		return nil, err
+	}
+	config.resources = append(config.resources, documents...)
+
+	for i, resource := range config.resources {
+		config.resources[i].id = importIDs[resource.address()]
+	}
+
+	return config, nil
+}
+
+// msgraphDisplayName returns the displayName in the body attribute of a
+// msgraph_resource, or "" when it is not a literal.
+func msgraphDisplayName(body *hclwrite.Body) string {
+	attribute := body.GetAttribute("body")
+	if attribute == nil {
+		return ""
+	}
+	expr, diags := hclsyntax.ParseExpression(attribute.Expr().BuildTokens(nil).Bytes(), "", hcl.InitialPos)
+	if diags.HasErrors() {
+		return ""
+	}
+	object, ok := expr.(*hclsyntax.ObjectConsExpr)
+	if !ok {
+		return ""
+	}
+	for _, item := range object.Items {
+		if objectItemKey(item) != "displayName" {
+			continue
+		}
+		if value, diags := item.ValueExpr.Value(nil); !diags.HasErrors() && value.Type() == cty.String && !value.IsNull() {
+			return value.AsString()
+		}
+	}
+	return ""
+}
+
+// moduleMapEntries returns the entries of the ca_policies map if block is the
+// locals block of the module mode that defines it as an object.
+func moduleMapEntries(path string, block *hclwrite.Block) ([]existingResource, error) {
+	attribute := block.Body().GetAttribute(policyModuleLocal)
+	if attribute == nil {
+		return nil, nil
+	}
+	src := attribute.Expr().BuildTokens(nil).Bytes()
+	expr, diags := hclsyntax.ParseExpression(src, path, hcl.InitialPos)
+	if diags.HasErrors() {
+		return nil, fmt.Errorf("error parsing %s in %s: %s", policyModuleLocal, path, diags.Error())
+	}
+	object, ok := expr.(*hclsyntax.ObjectConsExpr)
+	if !ok {
+		// the yaml mode builds the map from the documents
+		return nil, nil
+	}
+
+	var entries []existingResource
+	for _, item := range object.Items {
+		key, diags := item.KeyExpr.Value(nil)
+		if diags.HasErrors() || key.Type() != cty.String {
+			return nil, fmt.Errorf("error parsing %s in %s: map keys must be strings", policyModuleLocal, path)
+		}
+		body := hclwrite.NewEmptyFile().Body()
+		objectBody(body, item.ValueExpr, src)
+		displayName, _ := literalString(body.GetAttribute("display_name"))
+		entries = append(entries, existingResource{
+			file:        path,
+			label:       key.AsString(),
+			displayName: displayName,
+			block:       block,
+			body:        body,
+			inModule:    true,
+		})
+	}
+	return entries, nil
+}
+
+// objectBody writes the items of an object expression to body, with nested
+// objects as nested blocks like the resource the map entry is passed to.
+func objectBody(body *hclwrite.Body, expr hclsyntax.Expression, src []byte) {
+	object, ok := expr.(*hclsyntax.ObjectConsExpr)
+	if !ok {
+		return
+	}
+	for _, item := range object.Items {
+		name := objectItemKey(item)
+		if nested, ok := item.ValueExpr.(*hclsyntax.ObjectConsExpr); ok {
+			objectBody(body.AppendNewBlock(name, nil).Body(), nested, src)
+			continue
+		}
+		valueRange := item.ValueExpr.Range()
+		body.SetAttributeRaw(name, rawTokens(string(src[valueRange.Start.Byte:valueRange.End.Byte])))
+	}
+}
+
+// objectItemKey returns the key of an object item written either as a name
+// or as a quoted string.
+func objectItemKey(item hclsyntax.ObjectConsItem) string {
+	if name := hcl.ExprAsKeyword(item.KeyExpr); name != "" {
+		return name
+	}
+	if key, diags := item.KeyExpr.Value(nil); !diags.HasErrors() && key.Type() == cty.String && !key.IsNull() {
+		return key.AsString()
+	}
+	return ""
+}
+
+// readPolicyDocuments reads the YAML policy documents of the yaml mode in
+// dir.
+func readPolicyDocuments(out OutputWriter, dir string) ([]existingResource, error) {
+	paths, err := out.Glob(filepath.Join(dir, "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	var documents []existingResource
+	for _, path := range paths {
+		src, err := out.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		body, err := documentBody(src)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %s: %v", path, err)
+		}
+		displayName, _ := literalString(body.GetAttribute("display_name"))
+		documents = append(documents, existingResource{
+			file:        path,
+			label:       strings.TrimSuffix(filepath.Base(path), ".yaml"),
+			displayName: displayName,
+			body:        body,
+			inModule:    true,
+		})
+	}
+	return documents, nil
+}
+
+// documentBody converts a YAML policy document into the attributes and
+// nested blocks of the resource it is passed to.
+func documentBody(src []byte) (*hclwrite.Body, error) {
+	var document map[string]any
+	if err := yaml.Unmarshal(src, &document); err != nil {
+		return nil, err
+	}
+	body := hclwrite.NewEmptyFile().Body()
+	if err := mappingBody(body, document); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+func mappingBody(body *hclwrite.Body, mapping map[string]any) error {
+	names := make([]string, 0, len(mapping))
+	for name := range mapping {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		switch value := mapping[name].(type) {
+		case nil:
+		case map[string]any:
+			if err := mappingBody(body.AppendNewBlock(name, nil).Body(), value); err != nil {
+				return err
+			}
+		default:
+			v, err := documentValue(value)
+			if err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+			body.SetAttributeValue(name, v)
+		}
+	}
+	return nil
+}
+
+// documentValue converts a scalar or list from a YAML document.
+func documentValue(value any) (cty.Value, error) {
+	switch v := value.(type) {
+	case string:
+		return cty.StringVal(v), nil
+	case bool:
+		return cty.BoolVal(v), nil
+	case int:
+		return cty.NumberIntVal(int64(v)), nil
+	case float64:
+		return cty.NumberFloatVal(v), nil
+	case []any:
+		if len(v) == 0 {
+			return cty.ListValEmpty(cty.String), nil
+		}
+		var elements []cty.Value
+		for _, element := range v {
+			converted, err := documentValue(element)
+			if err != nil {
+				return cty.NilVal, err
+			}
+			elements = append(elements, converted)
+		}
+		return cty.TupleVal(elements), nil
+	}
+	return cty.NilVal, fmt.Errorf("unsupported value %v", value)
+}
+
+// literalString returns the value of attribute if it is a string literal.
+func literalString(attribute *hclwrite.Attribute) (string, bool) {
+	if attribute == nil {
+		return "", false
+	}
+	value, ok := literalValue(attribute.Expr().BuildTokens(nil))
+	if !ok || value.Type() != cty.String || value.IsNull() {
+		return "", false
+	}
+	return value.AsString(), true
+}
+
+// literalValue evaluates tokens if they form an expression without any
+// references or function calls.
+func literalValue(tokens hclwrite.Tokens) (cty.Value, bool) {
+	expr, diags := hclsyntax.ParseExpression(tokens.Bytes(), "", hcl.InitialPos)
+	if diags.HasErrors() {
+		return cty.NilVal, false
+	}
+	value, diags := expr.Value(nil)
+	if diags.HasErrors() {
+		return cty.NilVal, false
+	}
+	return value, true
+}
+
+// normalizedExpr renders an attribute expression in a form that can be
+// compared across configurations: references to data sources are replaced by
+// the object they look up using dataSources, and lists are sorted because the
+// provider treats them as sets.
+func normalizedExpr(tokens hclwrite.Tokens, dataSources map[string]string) string {
+	src := tokens.Bytes()
+	expr, diags := hclsyntax.ParseExpression(src, "", hcl.InitialPos)
+	if diags.HasErrors() {
+		return strings.TrimSpace(string(src))
+	}
+	return normalizedSyntaxExpr(expr, src, dataSources)
+}
+
+func normalizedSyntaxExpr(expr hclsyntax.Expression, src []byte, dataSources map[string]string) string {
+	switch e := expr.(type) {
+	case *hclsyntax.TupleConsExpr:
+		var elements []string
+		for _, element := range e.Exprs {
+			elements = append(elements, normalizedSyntaxExpr(element, src, dataSources))
+		}
+		sort.Strings(elements)
+		return "[" + strings.Join(elements, ", ") + "]"
+	case *hclsyntax.ObjectConsExpr:
+		var items []string
+		for _, item := range e.Items {
+			key := objectItemKey(item)
+			if key == "" {
+				key = strings.TrimSpace(string(item.KeyExpr.Range().SliceBytes(src)))
+			}
+			items = append(items, fmt.Sprintf("%s = %s", key, normalizedSyntaxExpr(item.ValueExpr, src, dataSources)))
+		}
+		sort.Strings(items)
+		return "{" + strings.Join(items, ", ") + "}"
+	case *hclsyntax.ScopeTraversalExpr:
+		traversal := e.Traversal
+		if traversal.RootName() == "data" && len(traversal) == 4 {
+			dataType, typeOK := traversal[1].(hcl.TraverseAttr)
+			label, labelOK := traversal[2].(hcl.TraverseAttr)
+			if typeOK && labelOK {
+				if value, ok := dataSources[dataType.Name+"."+label.Name]; ok {
+					return fmt.Sprintf("%s(%q)", dataType.Name, value)
+				}
+			}
+		}
+	}
+
+	if value, diags := expr.Value(nil); !diags.HasErrors() {
+		return strings.TrimSpace(string(hclwrite.TokensForValue(value).Bytes()))
+	}
+	return strings.TrimSpace(string(expr.Range().SliceBytes(src)))
+}
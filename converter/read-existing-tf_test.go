@@ -0,0 +1,145 @@
+package converter
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+)
+
+func TestDriftReadsModuleAndYAMLLayouts(t *testing.T) {
+	for _, mode := range []string{modeResources, modeModule, modeYAML} {
+		t.Run(mode, func(t *testing.T) {
+			out := NewMemoryWriter()
+			policies := []models.ConditionalAccessPolicy{testPolicy("a1", "Require MFA"), testPolicy("b2", "Block legacy")}
+			if _, err := generate(out, testConfig(mode), policies...); err != nil {
+				t.Fatal(err)
+			}
+			existing, err := readExistingConfig(out, "gen")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(existing.resources) != 2 {
+				t.Fatalf("read %d policies, want 2", len(existing.resources))
+			}
+			for _, resource := range existing.resources {
+				if resource.id == "" {
+					t.Errorf("%s has no ID from its import block", resource.address())
+				}
+			}
+
+			schema, err := lookupProviderSchema(defaultProviderTarget, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			report := detectDrift(existing, policies, newDirectoryCache(context.Background(), testDirectory), schema)
+			if report.hasDrift() || len(report.Errors) > 0 {
+				t.Errorf("unexpected drift: %+v", report)
+			}
+
+			disabled := testPolicy("a1", "Require MFA")
+			state := models.DISABLED_CONDITIONALACCESSPOLICYSTATE
+			disabled.SetState(&state)
+			report = detectDrift(existing, []models.ConditionalAccessPolicy{disabled}, newDirectoryCache(context.Background(), testDirectory), schema)
+			if len(report.Deleted) != 1 || report.Deleted[0] != "Block legacy" {
+				t.Errorf("deleted = %v, want [Block legacy]", report.Deleted)
+			}
+			if len(report.Changed) != 1 || report.Changed[0].Differences[0].Path != "state" {
+				t.Errorf("changed = %+v, want the state of Require MFA", report.Changed)
+			}
+		})
+	}
+}
+
+func TestPruneDeletesModuleAndYAMLEntries(t *testing.T) {
+	for _, mode := range []string{modeModule, modeYAML} {
+		t.Run(mode, func(t *testing.T) {
+			out := NewMemoryWriter()
+			if _, err := generate(out, testConfig(mode), testPolicy("a1", "Require MFA"), testPolicy("b2", "Block legacy")); err != nil {
+				t.Fatal(err)
+			}
+			manifest, err := loadManifest(out, "gen")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := pruneStalePolicies(out, io.Discard, "gen", []models.ConditionalAccessPolicy{testPolicy("a1", "Require MFA")}, manifest, pruneDelete); err != nil {
+				t.Fatal(err)
+			}
+
+			existing, err := readExistingConfig(out, "gen")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(existing.resources) != 1 || existing.resources[0].displayName != "Require MFA" {
+				t.Fatalf("policies after prune = %+v, want only Require MFA", existing.resources)
+			}
+			src, _ := out.ReadFile("gen/" + policyModuleFileName)
+			if strings.Contains(string(src), `"b2"`) {
+				t.Errorf("import of the pruned policy was kept:\n%s", src)
+			}
+		})
+	}
+}
+
+func TestPruneRejectsModesTheLayoutCannotExpress(t *testing.T) {
+	for _, tc := range []struct{ mode, prune string }{
+		{modeModule, pruneArchive},
+		{modeModule, pruneRemoved},
+		{modeYAML, pruneRemoved},
+	} {
+		cfg := DefaultConfig()
+		cfg.Mode, cfg.Prune = tc.mode, tc.prune
+		if err := cfg.validate(); err == nil {
+			t.Errorf("prune %q accepted in the %s mode", tc.prune, tc.mode)
+		}
+	}
+}
+
+func TestDriftAndPruneReadMSGraphResources(t *testing.T) {
+	out := NewMemoryWriter()
+	cfg := testConfig(modeResources)
+	cfg.PolicyResource = policyResourceMSGraph
+	policies := []models.ConditionalAccessPolicy{testPolicy("a1", "Require MFA"), testPolicy("b2", "Block legacy")}
+	if _, err := generate(out, cfg, policies...); err != nil {
+		t.Fatal(err)
+	}
+	existing, err := readExistingConfig(out, "gen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := map[string]string{}
+	for _, resource := range existing.resources {
+		ids[resource.displayName] = resource.id
+		if !strings.HasPrefix(resource.address(), msgraphResourceType+".") {
+			t.Errorf("address = %s, want a %s", resource.address(), msgraphResourceType)
+		}
+	}
+	if ids["Require MFA"] != "a1" || ids["Block legacy"] != "b2" {
+		t.Fatalf("read policies %v, want both with their IDs", ids)
+	}
+
+	schema, err := lookupProviderSchema(defaultProviderTarget, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report := detectDrift(existing, policies, newDirectoryCache(context.Background(), testDirectory), schema); report.hasDrift() || len(report.Errors) > 0 {
+		t.Errorf("unexpected drift: %+v", report)
+	}
+
+	manifest, err := loadManifest(out, "gen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pruneStalePolicies(out, io.Discard, "gen", policies[:1], manifest, pruneRemoved); err != nil {
+		t.Fatal(err)
+	}
+	src, _ := out.ReadFile("gen/Block legacy.tf")
+	if !strings.Contains(string(src), "from = msgraph_resource.block_legacy") {
+		t.Errorf("no removed block for the pruned msgraph_resource:\n%s", src)
+	}
+	if strings.Contains(string(src), `resource "msgraph_resource"`) {
+		t.Errorf("pruned msgraph_resource was kept:\n%s", src)
+	}
+}
@@ -0,0 +1,419 @@
+package converter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	abstractions "github.com/microsoft/kiota-abstractions-go"
+	jsonserialization "github.com/microsoft/kiota-serialization-json-go"
+	msgraphsdk "github.com/microsoftgraph/msgraph-sdk-go"
+	"github.com/microsoftgraph/msgraph-sdk-go/groups"
+	"github.com/microsoftgraph/msgraph-sdk-go/identity"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	"github.com/microsoftgraph/msgraph-sdk-go/models/odataerrors"
+)
+
+const policiesPath = "/identity/conditionalAccess/policies"
+
+// RunRestore creates or updates policies from the JSON backups written by
+// generate -backup and returns the process exit code: 0 when every policy
+// was restored and 1 otherwise.
+func RunRestore(args []string) int {
+	ctx := context.Background()
+
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	common := addCommonFlags(fs)
+	dir := fs.String("dir", "", "directory containing the backups (default: the backup directory in the configured output directory)")
+	dryRun := fs.Bool("dry-run", false, "print the requests instead of sending them")
+	keepState := fs.Bool("keep-state", false, "restore policies in their backed up state instead of report-only")
+	fs.Parse(args)
+
+	cfg, _, err := common.load()
+	if err != nil {
+		log.Printf("error loading config: %v", err)
+		return 1
+	}
+
+	// restore the given backup files, or every backup in the directory
+	paths := fs.Args()
+	if len(paths) == 0 {
+		if *dir == "" {
+			*dir = filepath.Join(cfg.OutputDir, backupDirName)
+		}
+		if paths, err = backupFiles(*dir); err != nil {
+			log.Printf("error listing backups: %v", err)
+			return 1
+		}
+	}
+
+	if err := checkBackupIDs(paths); err != nil {
+		log.Printf("error reading backups: %v", err)
+		return 1
+	}
+
+	graphClient, err := NewGraphClient(ctx, cfg.GraphEndpoint)
+	if err != nil {
+		log.Printf("error configuring Graph client: %v", err)
+		return 1
+	}
+	existing, err := getExistingPolicies(ctx, graphClient)
+	if err != nil {
+		log.Printf("error getting existing policies: %v", err)
+		return 1
+	}
+
+	r := &policyRestorer{
+		client:    graphClient,
+		existing:  existing,
+		resolver:  newPrincipalResolver(graphClient, os.Stdout),
+		log:       os.Stdout,
+		dryRun:    *dryRun,
+		keepState: *keepState,
+	}
+	failed := 0
+	for _, path := range paths {
+		if err := r.restore(ctx, path); err != nil {
+			log.Printf("error restoring %s: %v", path, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		log.Printf("%d of %d policies could not be restored", failed, len(paths))
+		return 1
+	}
+	return 0
+}
+
+// backupFiles returns the policy backups in dir, skipping the sidecars.
+func backupFiles(dir string) ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var backups []string
+	for _, path := range paths {
+		if !strings.HasSuffix(path, ".refs.json") {
+			backups = append(backups, path)
+		}
+	}
+	sort.Strings(backups)
+	return backups, nil
+}
+
+// policyRestorer sends the backups to the tenant. A backup updates the
+// policy with the ID recorded in its sidecar or, failing that, the policy
+// with the same display name, and creates a new policy otherwise.
+type policyRestorer struct {
+	client    *msgraphsdk.GraphServiceClient
+	existing  []models.ConditionalAccessPolicy
+	resolver  *principalResolver
+	log       io.Writer
+	dryRun    bool
+	keepState bool
+}
+
+func (r *policyRestorer) restore(ctx context.Context, path string) error {
+	policy, refs, err := readBackup(path)
+	if err != nil {
+		return err
+	}
+	name := stringValue(policy.GetDisplayName())
+
+	if err := r.resolver.resolvePolicy(ctx, policy, refs); err != nil {
+		return fmt.Errorf("policy %q: %v", name, err)
+	}
+	if !r.keepState {
+		state := models.ENABLEDFORREPORTINGBUTNOTENFORCED_CONDITIONALACCESSPOLICYSTATE
+		policy.SetState(&state)
+	}
+
+	target := r.match(refs.ID, name)
+	if r.dryRun {
+		body, err := policyBackupJSON(policy)
+		if err != nil {
+			return err
+		}
+		method, url := "POST", r.client.GetAdapter().GetBaseUrl()+policiesPath
+		if target != "" {
+			method, url = "PATCH", url+"/"+target
+		}
+		fmt.Fprintf(r.log, "%s %s\n%s\n", method, url, body)
+		return nil
+	}
+
+	if target != "" {
+		if _, err := r.client.Identity().ConditionalAccess().Policies().ByConditionalAccessPolicyId(target).Patch(ctx, policy, nil); err != nil {
+			return fmt.Errorf("error updating policy %q: %v", name, err)
+		}
+		fmt.Fprintf(r.log, "Updated policy: %s (%s)\n", name, target)
+		return nil
+	}
+	created, err := r.client.Identity().ConditionalAccess().Policies().Post(ctx, policy, nil)
+	if err != nil {
+		return fmt.Errorf("error creating policy %q: %v", name, err)
+	}
+	fmt.Fprintf(r.log, "Created policy: %s (%s)\n", name, stringValue(created.GetId()))
+	return nil
+}
+
+// match returns the ID of the existing policy a backup applies to, or "" if
+// it has to be created.
+func (r *policyRestorer) match(id, displayName string) string {
+	for _, policy := range r.existing {
+		if id != "" && stringValue(policy.GetId()) == id {
+			return id
+		}
+	}
+	for _, policy := range r.existing {
+		if stringValue(policy.GetDisplayName()) == displayName {
+			return stringValue(policy.GetId())
+		}
+	}
+	return ""
+}
+
+// readBackup parses the backup at path and its sidecar, if there is one.
+func readBackup(path string) (*models.ConditionalAccessPolicy, *backupRefs, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	node, err := jsonserialization.NewJsonParseNode(content)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing %s: %v", path, err)
+	}
+	value, err := node.GetObjectValue(models.CreateConditionalAccessPolicyFromDiscriminatorValue)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing %s: %v", path, err)
+	}
+	policy, ok := value.(*models.ConditionalAccessPolicy)
+	if !ok || policy.GetDisplayName() == nil {
+		return nil, nil, fmt.Errorf("%s is not a conditional access policy", path)
+	}
+
+	refs, err := readBackupRefs(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return policy, refs, nil
+}
+
+// readBackupRefs reads the sidecar of the backup at path. A missing sidecar
+// is empty.
+func readBackupRefs(path string) (*backupRefs, error) {
+	refs := &backupRefs{}
+	refsPath := strings.TrimSuffix(path, ".json") + ".refs.json"
+	if content, err := os.ReadFile(refsPath); err == nil {
+		if err := json.Unmarshal(content, refs); err != nil {
+			return nil, fmt.Errorf("error parsing %s: %v", refsPath, err)
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return refs, nil
+}
+
+// checkBackupIDs returns an error if two of the backups at paths are of the
+// same policy, as restoring both would apply them in turn.
+func checkBackupIDs(paths []string) error {
+	seen := map[string]string{}
+	for _, path := range paths {
+		refs, err := readBackupRefs(path)
+		if err != nil {
+			return err
+		}
+		if refs.ID == "" {
+			continue
+		}
+		if previous, ok := seen[refs.ID]; ok {
+			return fmt.Errorf("%s and %s are both backups of policy %s", previous, path, refs.ID)
+		}
+		seen[refs.ID] = path
+	}
+	return nil
+}
+
+// principalKind describes how to check and find one kind of object a policy
+// refers to.
+type principalKind struct {
+	name string
+	// exists reports whether the object with the given ID exists.
+	exists func(ctx context.Context, id string) (bool, error)
+	// find returns the ID of the object with the given name.
+	find func(ctx context.Context, name string) (string, error)
+}
+
+// principalResolver maps the object IDs in a backup to the IDs of the same
+// objects in the tenant. IDs that exist are kept; others are looked up by the
+// name recorded in the sidecar.
+type principalResolver struct {
+	users, groups, namedLocations principalKind
+	cache                         map[string]string
+	log                           io.Writer
+}
+
+func newPrincipalResolver(client *msgraphsdk.GraphServiceClient, log io.Writer) *principalResolver {
+	return &principalResolver{
+		users: principalKind{
+			name: "user",
+			exists: func(ctx context.Context, id string) (bool, error) {
+				_, err := client.Users().ByUserId(id).Get(ctx, nil)
+				return graphExists(err)
+			},
+			find: func(ctx context.Context, upn string) (string, error) {
+				user, err := client.Users().ByUserId(upn).Get(ctx, nil)
+				if err != nil {
+					return "", err
+				}
+				return stringValue(user.GetId()), nil
+			},
+		},
+		groups: principalKind{
+			name: "group",
+			exists: func(ctx context.Context, id string) (bool, error) {
+				_, err := client.Groups().ByGroupId(id).Get(ctx, nil)
+				return graphExists(err)
+			},
+			find: func(ctx context.Context, name string) (string, error) {
+				filter := fmt.Sprintf("displayName eq '%s'", strings.ReplaceAll(name, "'", "''"))
+				result, err := client.Groups().Get(ctx, &groups.GroupsRequestBuilderGetRequestConfiguration{
+					QueryParameters: &groups.GroupsRequestBuilderGetQueryParameters{Filter: &filter},
+				})
+				if err != nil {
+					return "", err
+				}
+				return singleID(result.GetValue())
+			},
+		},
+		namedLocations: principalKind{
+			name: "named location",
+			exists: func(ctx context.Context, id string) (bool, error) {
+				_, err := client.Identity().ConditionalAccess().NamedLocations().ByNamedLocationId(id).Get(ctx, nil)
+				return graphExists(err)
+			},
+			find: func(ctx context.Context, name string) (string, error) {
+				filter := fmt.Sprintf("displayName eq '%s'", strings.ReplaceAll(name, "'", "''"))
+				result, err := client.Identity().ConditionalAccess().NamedLocations().Get(ctx, &identity.ConditionalAccessNamedLocationsRequestBuilderGetRequestConfiguration{
+					QueryParameters: &identity.ConditionalAccessNamedLocationsRequestBuilderGetQueryParameters{Filter: &filter},
+				})
+				if err != nil {
+					return "", err
+				}
+				return singleID(result.GetValue())
+			},
+		},
+		cache: map[string]string{},
+		log:   log,
+	}
+}
+
+// resolvePolicy replaces the user, group and named location IDs in policy
+// with their IDs in the tenant.
+func (r *principalResolver) resolvePolicy(ctx context.Context, policy *models.ConditionalAccessPolicy, refs *backupRefs) error {
+	conditions := policy.GetConditions()
+	if conditions == nil {
+		return nil
+	}
+	var errs []error
+	resolve := func(kind principalKind, names map[string]string, ids []string, set func([]string)) {
+		if ids == nil {
+			return
+		}
+		resolved, err := r.resolveAll(ctx, kind, names, ids)
+		if err != nil {
+			errs = append(errs, err)
+			return
+		}
+		set(resolved)
+	}
+
+	if users := conditions.GetUsers(); users != nil {
+		resolve(r.users, refs.Users, users.GetIncludeUsers(), users.SetIncludeUsers)
+		resolve(r.users, refs.Users, users.GetExcludeUsers(), users.SetExcludeUsers)
+		resolve(r.groups, refs.Groups, users.GetIncludeGroups(), users.SetIncludeGroups)
+		resolve(r.groups, refs.Groups, users.GetExcludeGroups(), users.SetExcludeGroups)
+	}
+	if locations := conditions.GetLocations(); locations != nil {
+		resolve(r.namedLocations, refs.NamedLocations, locations.GetIncludeLocations(), locations.SetIncludeLocations)
+		resolve(r.namedLocations, refs.NamedLocations, locations.GetExcludeLocations(), locations.SetExcludeLocations)
+	}
+	return errors.Join(errs...)
+}
+
+func (r *principalResolver) resolveAll(ctx context.Context, kind principalKind, names map[string]string, ids []string) ([]string, error) {
+	resolved := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if isReferenceKeyword(id) {
+			resolved = append(resolved, id)
+			continue
+		}
+		newID, err := r.resolve(ctx, kind, id, names[id])
+		if err != nil {
+			return nil, err
+		}
+		resolved = append(resolved, newID)
+	}
+	return resolved, nil
+}
+
+func (r *principalResolver) resolve(ctx context.Context, kind principalKind, id, name string) (string, error) {
+	key := kind.name + "/" + id
+	if resolved, ok := r.cache[key]; ok {
+		return resolved, nil
+	}
+
+	exists, err := kind.exists(ctx, id)
+	if err != nil {
+		return "", fmt.Errorf("error looking up %s %s: %v", kind.name, id, err)
+	}
+	resolved := id
+	if !exists {
+		if name == "" {
+			return "", fmt.Errorf("%s %s does not exist and the backup has no name for it", kind.name, id)
+		}
+		if resolved, err = kind.find(ctx, name); err != nil {
+			return "", fmt.Errorf("%s %s does not exist and %q could not be found: %v", kind.name, id, name, err)
+		}
+		fmt.Fprintf(r.log, "Resolved %s %q: %s -> %s\n", kind.name, name, id, resolved)
+	}
+	r.cache[key] = resolved
+	return resolved, nil
+}
+
+// graphExists turns the error of a Graph request for a single object into
+// whether the object exists.
+func graphExists(err error) (bool, error) {
+	if err == nil {
+		return true, nil
+	}
+	var odataErr *odataerrors.ODataError
+	if errors.As(err, &odataErr) && odataErr.ResponseStatusCode == 404 {
+		return false, nil
+	}
+	var apiErr *abstractions.ApiError
+	if errors.As(err, &apiErr) && apiErr.ResponseStatusCode == 404 {
+		return false, nil
+	}
+	return false, err
+}
+
+// singleID returns the ID of the only object in a filtered list.
+func singleID[T interface{ GetId() *string }](objects []T) (string, error) {
+	switch len(objects) {
+	case 0:
+		return "", fmt.Errorf("no match")
+	case 1:
+		return stringValue(objects[0].GetId()), nil
+	}
+	return "", fmt.Errorf("%d objects match", len(objects))
+}
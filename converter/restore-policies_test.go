@@ -0,0 +1,256 @@
+package converter
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+)
+
+// fakeGraph serves the Graph requests of restore from a fixed set of
+// policies and objects and records every request it receives.
+type fakeGraph struct {
+	policies []map[string]any
+	// objects maps the paths of the users and groups that exist to the
+	// objects returned for them.
+	objects map[string]map[string]any
+
+	mu       sync.Mutex
+	requests []graphRequest
+}
+
+type graphRequest struct {
+	method string
+	path   string
+	body   map[string]any
+}
+
+func (g *fakeGraph) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	request := graphRequest{method: r.Method, path: r.URL.Path}
+	body := io.Reader(r.Body)
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		// the SDK compresses request bodies
+		reader, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		body = reader
+	}
+	if content, _ := io.ReadAll(body); len(content) > 0 {
+		json.Unmarshal(content, &request.body)
+	}
+	g.mu.Lock()
+	g.requests = append(g.requests, request)
+	g.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == policiesPath:
+		json.NewEncoder(w).Encode(map[string]any{"value": g.policies})
+	case r.Method == http.MethodGet:
+		object, ok := g.objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":{"code":"Request_ResourceNotFound","message":"not found"}}`))
+			return
+		}
+		json.NewEncoder(w).Encode(object)
+	case r.Method == http.MethodPost && r.URL.Path == policiesPath:
+		request.body["id"] = "created-id"
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(request.body)
+	case r.Method == http.MethodPatch && strings.HasPrefix(r.URL.Path, policiesPath+"/"):
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// writes returns the requests that change the tenant.
+func (g *fakeGraph) writes() []graphRequest {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var writes []graphRequest
+	for _, request := range g.requests {
+		if request.method != http.MethodGet {
+			writes = append(writes, request)
+		}
+	}
+	return writes
+}
+
+// restoreBackups backs up policies to a temporary directory and runs the
+// restore command with args against graph.
+func restoreBackups(t *testing.T, graph *fakeGraph, policies []models.ConditionalAccessPolicy, args ...string) int {
+	t.Helper()
+	server := httptest.NewServer(graph)
+	t.Cleanup(server.Close)
+
+	dir := t.TempDir()
+	if err := writePolicyBackups(DiskWriter{}, io.Discard, dir, policies, newDirectoryCache(context.Background(), testDirectory)); err != nil {
+		t.Fatal(err)
+	}
+	cfg := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(cfg, []byte(`{"graph_endpoint": "`+server.URL+`"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return RunRestore(append([]string{"-config", cfg, "-dir", dir}, args...))
+}
+
+// restoreTestObjects are the user and group of testPolicy, existing under
+// their backed up IDs.
+func restoreTestObjects() map[string]map[string]any {
+	return map[string]map[string]any{
+		"/users/" + testUserID:   {"id": testUserID},
+		"/groups/" + testGroupID: {"id": testGroupID},
+	}
+}
+
+func stringsIn(value any) []string {
+	var values []string
+	items, _ := value.([]any)
+	for _, item := range items {
+		if s, ok := item.(string); ok {
+			values = append(values, s)
+		}
+	}
+	return values
+}
+
+func TestRestoreCreatesMissingPolicyAsReportOnly(t *testing.T) {
+	graph := &fakeGraph{objects: restoreTestObjects()}
+	if code := restoreBackups(t, graph, []models.ConditionalAccessPolicy{testPolicy("a1", "Require MFA")}); code != 0 {
+		t.Fatalf("exit code %d", code)
+	}
+
+	writes := graph.writes()
+	if len(writes) != 1 || writes[0].method != http.MethodPost || writes[0].path != policiesPath {
+		t.Fatalf("requests = %+v, want one POST to %s", writes, policiesPath)
+	}
+	if state := writes[0].body["state"]; state != "enabledForReportingButNotEnforced" {
+		t.Errorf("state = %v, want report-only", state)
+	}
+	if name := writes[0].body["displayName"]; name != "Require MFA" {
+		t.Errorf("displayName = %v", name)
+	}
+}
+
+func TestRestoreKeepsStateOnRequest(t *testing.T) {
+	graph := &fakeGraph{objects: restoreTestObjects()}
+	if code := restoreBackups(t, graph, []models.ConditionalAccessPolicy{testPolicy("a1", "Require MFA")}, "-keep-state"); code != 0 {
+		t.Fatalf("exit code %d", code)
+	}
+	if writes := graph.writes(); len(writes) != 1 || writes[0].body["state"] != "enabled" {
+		t.Errorf("requests = %+v, want the backed up state", writes)
+	}
+}
+
+func TestRestoreUpdatesExistingPolicy(t *testing.T) {
+	graph := &fakeGraph{
+		policies: []map[string]any{
+			{"id": "a1", "displayName": "Require MFA (old name)"},
+			{"id": "b2", "displayName": "Block legacy"},
+		},
+		objects: restoreTestObjects(),
+	}
+	policies := []models.ConditionalAccessPolicy{testPolicy("a1", "Require MFA"), testPolicy("gone", "Block legacy")}
+	if code := restoreBackups(t, graph, policies); code != 0 {
+		t.Fatalf("exit code %d", code)
+	}
+
+	paths := map[string]bool{}
+	for _, request := range graph.writes() {
+		if request.method != http.MethodPatch {
+			t.Errorf("unexpected %s %s", request.method, request.path)
+		}
+		paths[request.path] = true
+	}
+	// a1 is matched by ID, the other backup by its display name
+	for _, path := range []string{policiesPath + "/a1", policiesPath + "/b2"} {
+		if !paths[path] {
+			t.Errorf("no PATCH to %s in %v", path, paths)
+		}
+	}
+}
+
+func TestRestoreResolvesMissingPrincipalByName(t *testing.T) {
+	objects := restoreTestObjects()
+	delete(objects, "/users/"+testUserID)
+	objects["/users/breakglass@contoso.com"] = map[string]any{"id": "44444444-4444-4444-4444-444444444444"}
+	graph := &fakeGraph{objects: objects}
+	if code := restoreBackups(t, graph, []models.ConditionalAccessPolicy{testPolicy("a1", "Require MFA")}); code != 0 {
+		t.Fatalf("exit code %d", code)
+	}
+
+	writes := graph.writes()
+	if len(writes) != 1 {
+		t.Fatalf("requests = %+v, want one POST", writes)
+	}
+	conditions, _ := writes[0].body["conditions"].(map[string]any)
+	users, _ := conditions["users"].(map[string]any)
+	if excluded := stringsIn(users["excludeUsers"]); len(excluded) != 1 || excluded[0] != "44444444-4444-4444-4444-444444444444" {
+		t.Errorf("excludeUsers = %v, want the ID of breakglass@contoso.com in the tenant", excluded)
+	}
+	if included := stringsIn(users["includeGroups"]); len(included) != 1 || included[0] != testGroupID {
+		t.Errorf("includeGroups = %v, want the existing group kept", included)
+	}
+}
+
+func TestRestoreDryRunSendsNoChanges(t *testing.T) {
+	graph := &fakeGraph{
+		policies: []map[string]any{{"id": "a1", "displayName": "Require MFA"}},
+		objects:  restoreTestObjects(),
+	}
+	policies := []models.ConditionalAccessPolicy{testPolicy("a1", "Require MFA"), testPolicy("c3", "New policy")}
+	if code := restoreBackups(t, graph, policies, "-dry-run"); code != 0 {
+		t.Fatalf("exit code %d", code)
+	}
+	if writes := graph.writes(); len(writes) != 0 {
+		t.Errorf("dry run sent %+v", writes)
+	}
+}
+
+func TestRestoreRefusesTwoBackupsOfOnePolicy(t *testing.T) {
+	graph := &fakeGraph{objects: restoreTestObjects()}
+	server := httptest.NewServer(graph)
+	t.Cleanup(server.Close)
+
+	dir := t.TempDir()
+	if err := writePolicyBackups(DiskWriter{}, io.Discard, dir, []models.ConditionalAccessPolicy{testPolicy("a1", "Require MFA")}, newDirectoryCache(context.Background(), testDirectory)); err != nil {
+		t.Fatal(err)
+	}
+	// a copy left behind under another name, e.g. by an older version
+	for _, suffix := range []string{".json", ".refs.json"} {
+		content, err := os.ReadFile(filepath.Join(dir, "require_mfa_a1"+suffix))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "old_name_a1"+suffix), content, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(cfg, []byte(`{"graph_endpoint": "`+server.URL+`"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if code := RunRestore([]string{"-config", cfg, "-dir", dir}); code == 0 {
+		t.Error("restore of two backups of one policy succeeded")
+	}
+	if writes := graph.writes(); len(writes) != 0 {
+		t.Errorf("restore sent %+v", writes)
+	}
+	if _, err := NewBackupSource([]string{filepath.Join(dir, "old_name_a1.json"), filepath.Join(dir, "require_mfa_a1.json")}); err == nil {
+		t.Error("backup source accepted two backups of one policy")
+	}
+}
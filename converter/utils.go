@@ -0,0 +1,46 @@
+package converter
+
+import (
+	"context"
+	"fmt"
+
+	azidentity "github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	msgraphsdk "github.com/microsoftgraph/msgraph-sdk-go"
+)
+
+func get_aad_upn_from_id(ctx context.Context, id string, client *msgraphsdk.GraphServiceClient) (string, error) {
+	result, err := client.Users().ByUserId(id).Get(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return *result.GetUserPrincipalName(), nil
+}
+
+func get_aad_display_name_from_id(ctx context.Context, id string, client *msgraphsdk.GraphServiceClient) (string, error) {
+	result, err := client.Groups().ByGroupId(id).Get(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return *result.GetDisplayName(), nil
+}
+
+func get_aad_ca_named_location_from_id(ctx context.Context, id string, client *msgraphsdk.GraphServiceClient) (string, error) {
+	result, err := client.Identity().ConditionalAccess().NamedLocations().ByNamedLocationId(id).Get(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return *result.GetDisplayName(), nil
+}
+
+// configureCredentials configures Azure credentials. An empty tenantID uses
+// the tenant the Azure CLI is signed in to.
+func configureCredentials(ctx context.Context, tenantID string) (*azidentity.AzureCLICredential, error) {
+	cred, err := azidentity.NewAzureCLICredential(&azidentity.AzureCLICredentialOptions{TenantID: tenantID})
+	if err != nil {
+		return nil, fmt.Errorf("error creating credentials: %v", err)
+	}
+	return cred, nil
+}
@@ -0,0 +1,284 @@
+package converter
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Access outcomes a scenario can expect.
+const (
+	accessBlocked = "blocked"
+	// accessGranted is access without any grant requirement.
+	accessGranted = "granted"
+	// accessConditional is access once the grant requirements are met.
+	accessConditional = "conditional"
+)
+
+// whatIfScenario is a simulated sign-in with its expected outcome, read from
+// a scenario file.
+type whatIfScenario struct {
+	Name   string              `json:"name" yaml:"name"`
+	SignIn signIn              `json:"sign_in" yaml:",inline"`
+	Expect scenarioExpectation `json:"expect" yaml:"expect"`
+}
+
+// scenarioExpectation is the expected outcome of a scenario. Fields left
+// empty are not checked; an empty list in YAML expects none.
+type scenarioExpectation struct {
+	// Access is blocked, granted or conditional.
+	Access string `json:"access,omitempty" yaml:"access,omitempty"`
+	// Applied are the display names of the enforced policies that apply.
+	Applied []string `json:"applied,omitempty" yaml:"applied,omitempty"`
+	// Controls are the grant controls required, as in whatIfResult.Controls.
+	Controls []string `json:"controls,omitempty" yaml:"controls,omitempty"`
+}
+
+// scenarioOutcome is the result of checking one scenario.
+type scenarioOutcome struct {
+	Name       string        `json:"name"`
+	Passed     bool          `json:"passed"`
+	Mismatches []string      `json:"mismatches,omitempty"`
+	Result     *whatIfResult `json:"result"`
+}
+
+// access returns the access outcome of r.
+func (r *whatIfResult) access() string {
+	switch {
+	case r.Block:
+		return accessBlocked
+	case len(r.Grant) == 0:
+		return accessGranted
+	}
+	return accessConditional
+}
+
+// check evaluates the scenario and compares the result with its expectation.
+func (sc *whatIfScenario) check(e *whatIfEvaluator) scenarioOutcome {
+	result := e.evaluate(sc.SignIn)
+	outcome := scenarioOutcome{Name: sc.Name, Result: result}
+	if sc.Expect.Access != "" && !strings.EqualFold(sc.Expect.Access, result.access()) {
+		outcome.Mismatches = append(outcome.Mismatches, fmt.Sprintf("access: expected %s, got %s", sc.Expect.Access, result.access()))
+	}
+	if sc.Expect.Applied != nil {
+		var applied []string
+		for _, p := range result.Applied {
+			applied = append(applied, p.Policy)
+		}
+		if !sameSet(sc.Expect.Applied, applied) {
+			outcome.Mismatches = append(outcome.Mismatches, fmt.Sprintf("applied: expected %s, got %s", listText(sc.Expect.Applied), listText(applied)))
+		}
+	}
+	if sc.Expect.Controls != nil && !sameSet(sc.Expect.Controls, result.Controls) {
+		outcome.Mismatches = append(outcome.Mismatches, fmt.Sprintf("controls: expected %s, got %s", listText(sc.Expect.Controls), listText(result.Controls)))
+	}
+	outcome.Passed = len(outcome.Mismatches) == 0
+	return outcome
+}
+
+// sameSet reports whether a and b hold the same values, in any order and
+// without regard to case.
+func sameSet(a, b []string) bool {
+	normalize := func(values []string) []string {
+		normalized := make([]string, len(values))
+		for i, value := range values {
+			normalized[i] = strings.ToLower(value)
+		}
+		sort.Strings(normalized)
+		return normalized
+	}
+	return strings.Join(normalize(a), "\n") == strings.Join(normalize(b), "\n")
+}
+
+func listText(values []string) string {
+	if len(values) == 0 {
+		return "none"
+	}
+	return strings.Join(values, ", ")
+}
+
+// readScenarios reads the scenarios of a .yaml, .yml or .csv file. A file
+// without scenarios or with an unknown expected access is an error.
+func readScenarios(path string) ([]whatIfScenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var scenarios []whatIfScenario
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(strings.NewReader(string(data)))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&scenarios); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("error parsing %s: %v", path, err)
+		}
+	case ".csv":
+		if scenarios, err = parseScenarioCSV(string(data)); err != nil {
+			return nil, fmt.Errorf("error parsing %s: %v", path, err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown scenario file type %q, expected .yaml, .yml or .csv", filepath.Ext(path))
+	}
+	if len(scenarios) == 0 {
+		// a gate that checks nothing must not pass
+		return nil, fmt.Errorf("%s has no scenarios", path)
+	}
+	for i := range scenarios {
+		sc := &scenarios[i]
+		if sc.Name == "" {
+			sc.Name = fmt.Sprintf("scenario %d", i+1)
+		}
+		switch strings.ToLower(sc.Expect.Access) {
+		case "", accessBlocked, accessGranted, accessConditional:
+			sc.Expect.Access = strings.ToLower(sc.Expect.Access)
+		default:
+			return nil, fmt.Errorf("%s: unknown expected access %q, expected %s, %s or %s", sc.Name, sc.Expect.Access, accessBlocked, accessGranted, accessConditional)
+		}
+	}
+	return scenarios, nil
+}
+
+// scenarioColumns sets the scenario field of each CSV column from the cell
+// value. List cells are separated by semicolons.
+var scenarioColumns = map[string]func(sc *whatIfScenario, value string) error{
+	"name":        func(sc *whatIfScenario, v string) error { sc.Name = v; return nil },
+	"user":        func(sc *whatIfScenario, v string) error { sc.SignIn.User = v; return nil },
+	"groups":      func(sc *whatIfScenario, v string) error { sc.SignIn.Groups = splitListOn(v, ";"); return nil },
+	"roles":       func(sc *whatIfScenario, v string) error { sc.SignIn.Roles = splitListOn(v, ";"); return nil },
+	"guest_type":  func(sc *whatIfScenario, v string) error { sc.SignIn.GuestType = v; return nil },
+	"app":         func(sc *whatIfScenario, v string) error { sc.SignIn.App = v; return nil },
+	"user_action": func(sc *whatIfScenario, v string) error { sc.SignIn.UserAction = v; return nil },
+	"client_app":  func(sc *whatIfScenario, v string) error { sc.SignIn.ClientApp = v; return nil },
+	"platform":    func(sc *whatIfScenario, v string) error { sc.SignIn.Platform = v; return nil },
+	"location":    func(sc *whatIfScenario, v string) error { sc.SignIn.Location = v; return nil },
+	"trusted_location": func(sc *whatIfScenario, v string) (err error) {
+		if v != "" {
+			sc.SignIn.TrustedLocation, err = strconv.ParseBool(v)
+		}
+		return err
+	},
+	"sign_in_risk": func(sc *whatIfScenario, v string) error { sc.SignIn.SignInRisk = v; return nil },
+	"user_risk":    func(sc *whatIfScenario, v string) error { sc.SignIn.UserRisk = v; return nil },
+	"insider_risk": func(sc *whatIfScenario, v string) error { sc.SignIn.InsiderRisk = v; return nil },
+	"device": func(sc *whatIfScenario, v string) (err error) {
+		sc.SignIn.Device, err = parseDeviceProperties(splitListOn(v, ";"))
+		return err
+	},
+	"expect_access": func(sc *whatIfScenario, v string) error { sc.Expect.Access = v; return nil },
+	"expect_applied": func(sc *whatIfScenario, v string) error {
+		if v != "" {
+			sc.Expect.Applied = expectedList(v)
+		}
+		return nil
+	},
+	"expect_controls": func(sc *whatIfScenario, v string) error {
+		if v != "" {
+			sc.Expect.Controls = expectedList(v)
+		}
+		return nil
+	},
+}
+
+// expectedList parses an expected list cell, where "none" expects an empty
+// list.
+func expectedList(value string) []string {
+	if strings.EqualFold(strings.TrimSpace(value), "none") {
+		return []string{}
+	}
+	return splitListOn(value, ";")
+}
+
+// parseScenarioCSV parses scenarios from CSV whose header row names the
+// columns of scenarioColumns.
+func parseScenarioCSV(data string) ([]whatIfScenario, error) {
+	reader := csv.NewReader(strings.NewReader(data))
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	header := records[0]
+	for _, column := range header {
+		if scenarioColumns[strings.ToLower(strings.TrimSpace(column))] == nil {
+			return nil, fmt.Errorf("unknown column %q", column)
+		}
+	}
+	var scenarios []whatIfScenario
+	for line, record := range records[1:] {
+		var sc whatIfScenario
+		for i, column := range header {
+			set := scenarioColumns[strings.ToLower(strings.TrimSpace(column))]
+			if err := set(&sc, strings.TrimSpace(record[i])); err != nil {
+				return nil, fmt.Errorf("row %d, column %s: %v", line+2, column, err)
+			}
+		}
+		scenarios = append(scenarios, sc)
+	}
+	return scenarios, nil
+}
+
+// runWhatIfScenarios checks every scenario of the file at path and returns
+// the process exit code: 0 when all pass, 2 on any mismatch and 1 on errors.
+func runWhatIfScenarios(e *whatIfEvaluator, path, format string) int {
+	scenarios, err := readScenarios(path)
+	if err != nil {
+		log.Printf("error reading scenarios: %v", err)
+		return 1
+	}
+	outcomes := []scenarioOutcome{}
+	failed := 0
+	for i := range scenarios {
+		outcome := scenarios[i].check(e)
+		if !outcome.Passed {
+			failed++
+		}
+		outcomes = append(outcomes, outcome)
+	}
+
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(outcomes)
+	case "text":
+		writeScenarioText(os.Stdout, outcomes, failed)
+	default:
+		log.Printf("unknown format %q, expected text or json", format)
+		return 1
+	}
+	if err != nil {
+		log.Printf("error writing report: %v", err)
+		return 1
+	}
+	if failed > 0 {
+		return 2
+	}
+	return 0
+}
+
+func writeScenarioText(w io.Writer, outcomes []scenarioOutcome, failed int) {
+	for _, outcome := range outcomes {
+		if outcome.Passed {
+			fmt.Fprintf(w, "PASS %s\n", outcome.Name)
+			continue
+		}
+		fmt.Fprintf(w, "FAIL %s\n", outcome.Name)
+		for _, mismatch := range outcome.Mismatches {
+			fmt.Fprintf(w, "  %s\n", mismatch)
+		}
+	}
+	fmt.Fprintf(w, "\n%d scenarios, %d failed\n", len(outcomes), failed)
+}
@@ -0,0 +1,68 @@
+package converter
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+)
+
+// writeScenarioFile writes content to a scenario file with the given name in
+// a temporary directory and returns its path.
+func writeScenarioFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadScenariosRejectsFilesThatCheckNothing(t *testing.T) {
+	for _, tc := range []struct{ name, file, content, err string }{
+		{"empty YAML", "s.yaml", "", "no scenarios"},
+		{"comments only", "s.yaml", "# TODO: add scenarios\n", "no scenarios"},
+		{"empty list", "s.yaml", "[]\n", "no scenarios"},
+		{"header only", "s.csv", "name,user,expect_access\n", "no scenarios"},
+		{"unknown YAML access", "s.yaml", "- name: pilot\n  user: alice\n  expect:\n    access: allowed\n", `unknown expected access "allowed"`},
+		{"unknown CSV access", "s.csv", "name,user,expect_access\npilot,alice,deny\n", `unknown expected access "deny"`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := readScenarios(writeScenarioFile(t, tc.file, tc.content))
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("error = %v, want %q", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestScenarioCheck(t *testing.T) {
+	path := writeScenarioFile(t, "s.csv", `name,user,groups,app,expect_access,expect_applied,expect_controls
+pilot member,alice@contoso.com,CA Pilot,Office 365,Conditional,Require MFA,mfa
+break glass,breakglass@contoso.com,CA Pilot,Office 365,conditional,,
+not in pilot,bob@contoso.com,,Office 365,granted,none,none
+`)
+	scenarios, err := readScenarios(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := newWhatIfEvaluator([]models.ConditionalAccessPolicy{testPolicy("a1", "Require MFA")}, newDirectoryCache(context.Background(), testDirectory))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	passed := map[string]bool{}
+	for i := range scenarios {
+		outcome := scenarios[i].check(e)
+		passed[outcome.Name] = outcome.Passed
+		if outcome.Name == "break glass" && (len(outcome.Mismatches) != 1 || !strings.HasPrefix(outcome.Mismatches[0], "access: expected conditional, got granted")) {
+			t.Errorf("mismatches = %v, want the access", outcome.Mismatches)
+		}
+	}
+	if !passed["pilot member"] || !passed["not in pilot"] || passed["break glass"] {
+		t.Errorf("passed = %v, want only break glass to fail", passed)
+	}
+}
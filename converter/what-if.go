@@ -0,0 +1,529 @@
+package converter
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+)
+
+// office365Apps are the applications the Office365 keyword stands for, by
+// application ID, with the names a sign-in may use for them.
+var office365Apps = map[string]string{
+	"00000002-0000-0ff1-ce00-000000000000": "Exchange Online",
+	"00000003-0000-0ff1-ce00-000000000000": "SharePoint Online",
+	"cc15fd57-2c6c-4117-a88c-83b1d56b4bbe": "Microsoft Teams Services",
+	"1fec8e78-bce4-4aaf-ab1b-5451cc387264": "Microsoft Teams",
+	"4765445b-32c6-49b0-83e6-1d93765276ca": "OfficeHome",
+}
+
+// signIn describes a simulated sign-in. Users, groups, applications and
+// locations may be given by ID or by name.
+type signIn struct {
+	User   string   `json:"user" yaml:"user"`
+	Groups []string `json:"groups,omitempty" yaml:"groups,omitempty"`
+	// Roles are directory role template IDs.
+	Roles []string `json:"roles,omitempty" yaml:"roles,omitempty"`
+	// GuestType is the guest or external user type of the user, e.g.
+	// "b2bCollaborationGuest", or empty for members.
+	GuestType string `json:"guest_type,omitempty" yaml:"guest_type,omitempty"`
+
+	App        string `json:"app,omitempty" yaml:"app,omitempty"`
+	UserAction string `json:"user_action,omitempty" yaml:"user_action,omitempty"`
+	// ClientApp is the client app type and defaults to "browser".
+	ClientApp string `json:"client_app,omitempty" yaml:"client_app,omitempty"`
+	Platform  string `json:"platform,omitempty" yaml:"platform,omitempty"`
+
+	Location        string `json:"location,omitempty" yaml:"location,omitempty"`
+	TrustedLocation bool   `json:"trusted_location,omitempty" yaml:"trusted_location,omitempty"`
+
+	// The risk levels default to "none".
+	SignInRisk  string `json:"sign_in_risk,omitempty" yaml:"sign_in_risk,omitempty"`
+	UserRisk    string `json:"user_risk,omitempty" yaml:"user_risk,omitempty"`
+	InsiderRisk string `json:"insider_risk,omitempty" yaml:"insider_risk,omitempty"`
+
+	// Device holds the device properties device filters test, such as
+	// "trustType" or "isCompliant".
+	Device map[string]string `json:"device,omitempty" yaml:"device,omitempty"`
+}
+
+// whatIfPolicy is the outcome for one policy. Reason says why a skipped
+// policy does not apply.
+type whatIfPolicy struct {
+	Policy string `json:"policy"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// whatIfResult is the outcome of a simulated sign-in: the enforced policies
+// that apply, the report-only policies that would apply, the skipped
+// policies, and the combined requirements of the enforced policies.
+type whatIfResult struct {
+	Applied    []whatIfPolicy `json:"applied"`
+	ReportOnly []whatIfPolicy `json:"report_only"`
+	Skipped    []whatIfPolicy `json:"skipped"`
+
+	// Block is set when an applied policy blocks access.
+	Block bool `json:"block"`
+	// Grant lists the controls required by each applied policy; all of them
+	// have to be satisfied.
+	Grant []string `json:"grant,omitempty"`
+	// Controls are the built-in grant controls the applied policies use,
+	// with "authenticationStrength" for policies requiring one.
+	Controls []string `json:"controls,omitempty"`
+	// Session lists the session controls in effect, with the shortest
+	// sign-in frequency.
+	Session []string `json:"session,omitempty"`
+}
+
+// whatIfEvaluator evaluates sign-ins against a set of policies.
+type whatIfEvaluator struct {
+	policies []*caPolicy
+	filters  map[*caPolicy]deviceFilter
+	errors   map[*caPolicy]error
+}
+
+// newWhatIfEvaluator normalizes policies once so many sign-ins can be
+// evaluated against them.
+func newWhatIfEvaluator(policies []models.ConditionalAccessPolicy, directory *directoryCache) (*whatIfEvaluator, error) {
+	e := &whatIfEvaluator{filters: map[*caPolicy]deviceFilter{}, errors: map[*caPolicy]error{}}
+	for _, policy := range policies {
+		p, err := newCAPolicy(policy, directory)
+		if err != nil {
+			return nil, err
+		}
+		if devices := p.Conditions.Devices; devices != nil && devices.Filter != nil {
+			if filter, err := parseDeviceFilter(devices.Filter.Rule); err != nil {
+				e.errors[p] = err
+			} else {
+				e.filters[p] = filter
+			}
+		}
+		e.policies = append(e.policies, p)
+	}
+	sort.Slice(e.policies, func(i, j int) bool { return e.policies[i].DisplayName < e.policies[j].DisplayName })
+	return e, nil
+}
+
+// evaluate returns the outcome of s.
+func (e *whatIfEvaluator) evaluate(s signIn) *whatIfResult {
+	if s.ClientApp == "" {
+		s.ClientApp = "browser"
+	}
+	result := &whatIfResult{Applied: []whatIfPolicy{}, ReportOnly: []whatIfPolicy{}, Skipped: []whatIfPolicy{}}
+	var applied []*caPolicy
+	for _, p := range e.policies {
+		if p.State == "disabled" {
+			result.Skipped = append(result.Skipped, whatIfPolicy{p.DisplayName, "policy is disabled"})
+			continue
+		}
+		if reason := e.skipReason(p, s); reason != "" {
+			result.Skipped = append(result.Skipped, whatIfPolicy{p.DisplayName, reason})
+			continue
+		}
+		if p.State == "enabledForReportingButNotEnforced" {
+			result.ReportOnly = append(result.ReportOnly, whatIfPolicy{Policy: p.DisplayName})
+			continue
+		}
+		result.Applied = append(result.Applied, whatIfPolicy{Policy: p.DisplayName})
+		applied = append(applied, p)
+	}
+	result.combine(applied)
+	return result
+}
+
+// skipReason returns why p does not apply to s, or "" if it applies.
+func (e *whatIfEvaluator) skipReason(p *caPolicy, s signIn) string {
+	c := &p.Conditions
+	if reason := usersSkipReason(&c.Users, s); reason != "" {
+		return reason
+	}
+	if reason := appsSkipReason(c.Applications, s); reason != "" {
+		return reason
+	}
+	if len(c.ClientAppTypes) > 0 && !contains(c.ClientAppTypes, "all") && !containsFold(c.ClientAppTypes, s.ClientApp) {
+		return fmt.Sprintf("client app %s is not included", s.ClientApp)
+	}
+	if platforms := c.Platforms; platforms != nil {
+		if !contains(platforms.IncludePlatforms, "all") && !containsFold(platforms.IncludePlatforms, s.Platform) {
+			return fmt.Sprintf("platform %q is not included", s.Platform)
+		}
+		if containsFold(platforms.ExcludePlatforms, s.Platform) {
+			return fmt.Sprintf("platform %s is excluded", s.Platform)
+		}
+	}
+	if locations := c.Locations; locations != nil {
+		if !locationMatches(locations.IncludeLocations, s) {
+			return fmt.Sprintf("location %q is not included", s.Location)
+		}
+		if locationMatches(locations.ExcludeLocations, s) {
+			return fmt.Sprintf("location %q is excluded", s.Location)
+		}
+	}
+	for _, risk := range []struct {
+		name   string
+		levels []string
+		value  string
+	}{
+		{"sign-in risk", c.SignInRiskLevels, s.SignInRisk},
+		{"user risk", c.UserRiskLevels, s.UserRisk},
+		{"insider risk", c.InsiderRiskLevels, s.InsiderRisk},
+	} {
+		value := risk.value
+		if value == "" {
+			value = "none"
+		}
+		if len(risk.levels) > 0 && !containsFold(risk.levels, value) {
+			return fmt.Sprintf("%s %s is not included", risk.name, value)
+		}
+	}
+	if err := e.errors[p]; err != nil {
+		return fmt.Sprintf("device filter could not be evaluated: %v", err)
+	}
+	if filter := e.filters[p]; filter != nil {
+		matches := filter.matches(s.Device)
+		if c.Devices.Filter.Mode == "include" && !matches {
+			return "device does not match the device filter"
+		}
+		if c.Devices.Filter.Mode == "exclude" && matches {
+			return "device is excluded by the device filter"
+		}
+	}
+	return ""
+}
+
+func usersSkipReason(users *caUsers, s signIn) string {
+	included := includesAll(users.IncludeUsers) || refsMatch(users.IncludeUsers, s.User) ||
+		refsMatchAny(users.IncludeGroups, s.Groups) || anyIn(users.IncludeRoles, s.Roles) ||
+		guestsMatch(users.IncludeGuests, s.GuestType)
+	if !included {
+		return "user is not included"
+	}
+	switch {
+	case refsMatch(users.ExcludeUsers, s.User):
+		return "user is excluded"
+	case refsMatchAny(users.ExcludeGroups, s.Groups):
+		return "user is excluded through a group"
+	case anyIn(users.ExcludeRoles, s.Roles):
+		return "user is excluded through a role"
+	case guestsMatch(users.ExcludeGuests, s.GuestType):
+		return "guest or external user type is excluded"
+	}
+	return ""
+}
+
+func appsSkipReason(apps *caApplications, s signIn) string {
+	if apps == nil {
+		return ""
+	}
+	if len(apps.IncludeUserActions) > 0 {
+		if !containsFold(apps.IncludeUserActions, s.UserAction) {
+			return "user action is not included"
+		}
+		return ""
+	}
+	if s.App == "" {
+		return "policy targets applications and the sign-in has none"
+	}
+	if !appMatches(apps.IncludeApplications, s.App) {
+		return fmt.Sprintf("application %q is not included", s.App)
+	}
+	if appMatches(apps.ExcludeApplications, s.App) && !contains(apps.ExcludeApplications, "All") {
+		return fmt.Sprintf("application %q is excluded", s.App)
+	}
+	return ""
+}
+
+// appMatches reports whether app, an application ID or name, is one of ids,
+// which may use the All and Office365 keywords.
+func appMatches(ids []string, app string) bool {
+	for _, id := range ids {
+		switch {
+		case id == "All", strings.EqualFold(id, app):
+			return true
+		case id == "Office365":
+			for officeID, name := range office365Apps {
+				if strings.EqualFold(officeID, app) || strings.EqualFold(name, app) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// locationMatches reports whether the sign-in location is one of refs, which
+// may use the All and AllTrusted keywords.
+func locationMatches(refs []principalRef, s signIn) bool {
+	for _, ref := range refs {
+		switch {
+		case ref.ID == "All":
+			return true
+		case ref.ID == "AllTrusted":
+			if s.TrustedLocation {
+				return true
+			}
+		case s.Location != "" && (strings.EqualFold(ref.ID, s.Location) || strings.EqualFold(ref.Name, s.Location)):
+			return true
+		}
+	}
+	return false
+}
+
+func guestsMatch(guests *caGuestsOrExternalUsers, guestType string) bool {
+	return guests != nil && guestType != "" && containsFold(guests.GuestOrExternalUserTypes, guestType)
+}
+
+// refsMatch reports whether value is the ID or name of one of refs.
+func refsMatch(refs []principalRef, value string) bool {
+	if value == "" {
+		return false
+	}
+	for _, ref := range refs {
+		if strings.EqualFold(ref.ID, value) || ref.Name != "" && strings.EqualFold(ref.Name, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func refsMatchAny(refs []principalRef, values []string) bool {
+	for _, value := range values {
+		if refsMatch(refs, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func anyIn(values, candidates []string) bool {
+	for _, candidate := range candidates {
+		if containsFold(values, candidate) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// combine sets the grant and session requirements of the applied policies.
+func (r *whatIfResult) combine(applied []*caPolicy) {
+	var shortest *caSessionControls
+	sessions := map[string]bool{}
+	controls := map[string]bool{}
+	for _, p := range applied {
+		if grant := p.Grant; grant != nil {
+			for _, control := range grant.BuiltInControls {
+				controls[control] = true
+			}
+			if grant.AuthenticationStrengthPolicyID != "" {
+				controls["authenticationStrength"] = true
+			}
+			if contains(grant.BuiltInControls, "block") {
+				r.Block = true
+			}
+			if labels := grantControlLabels(grant); len(labels) > 0 {
+				r.Grant = append(r.Grant, fmt.Sprintf("%s: %s", p.DisplayName, strings.Join(labels, joinWord(grant.Operator))))
+			}
+		}
+		if session := p.Session; session != nil {
+			if session.SignInFrequency != nil && (shortest == nil || frequencyHours(session) < frequencyHours(shortest)) {
+				shortest = session
+			}
+			// the shortest sign-in frequency is added once below
+			copied := *session
+			if copied.SignInFrequency != nil {
+				copied.SignInFrequency = nil
+				copied.SignInFrequencyInterval = ""
+			}
+			for _, control := range sessionControlSummary(&copied) {
+				sessions[control] = true
+			}
+		}
+	}
+	for control := range controls {
+		r.Controls = append(r.Controls, control)
+	}
+	sort.Strings(r.Controls)
+	for control := range sessions {
+		r.Session = append(r.Session, control)
+	}
+	if shortest != nil {
+		r.Session = append(r.Session, fmt.Sprintf("signInFrequency: %d %s", *shortest.SignInFrequency, shortest.SignInFrequencyPeriod))
+	}
+	sort.Strings(r.Session)
+}
+
+func joinWord(operator string) string {
+	if operator == "AND" {
+		return " and "
+	}
+	return " or "
+}
+
+func frequencyHours(session *caSessionControls) int64 {
+	if session.SignInFrequencyPeriod == "days" {
+		return *session.SignInFrequency * 24
+	}
+	return *session.SignInFrequency
+}
+
+// RunWhatIf evaluates one simulated sign-in against the policies from the
+// tenant or from backups and returns the process exit code.
+func RunWhatIf(args []string) int {
+	ctx := context.Background()
+
+	fs := flag.NewFlagSet("whatif", flag.ExitOnError)
+	common := addCommonFlags(fs)
+	source := addPolicySourceFlags(fs)
+	format := fs.String("format", "text", "output format: text or json")
+	var s signIn
+	var groups, roles, device string
+	fs.StringVar(&s.User, "user", "", "user ID or user principal name")
+	fs.StringVar(&groups, "groups", "", "comma-separated IDs or names of the groups the user is a member of")
+	fs.StringVar(&roles, "roles", "", "comma-separated directory role template IDs the user holds")
+	fs.StringVar(&s.GuestType, "guest-type", "", "guest or external user type of the user, e.g. b2bCollaborationGuest")
+	fs.StringVar(&s.App, "app", "", "application ID or name signed in to")
+	fs.StringVar(&s.UserAction, "user-action", "", "user action instead of an application, e.g. urn:user:registersecurityinfo")
+	fs.StringVar(&s.ClientApp, "client-app", "browser", "client app type: browser, mobileAppsAndDesktopClients, exchangeActiveSync or other")
+	fs.StringVar(&s.Platform, "platform", "", "device platform, e.g. windows, iOS or android")
+	fs.StringVar(&s.Location, "location", "", "named location ID or name the sign-in comes from")
+	fs.BoolVar(&s.TrustedLocation, "trusted-location", false, "the location is marked as trusted")
+	fs.StringVar(&s.SignInRisk, "sign-in-risk", "none", "sign-in risk level")
+	fs.StringVar(&s.UserRisk, "user-risk", "none", "user risk level")
+	fs.StringVar(&s.InsiderRisk, "insider-risk", "", "insider risk level")
+	fs.StringVar(&device, "device", "", "comma-separated device properties as name=value, e.g. trustType=AzureAD,isCompliant=True")
+	scenarios := fs.String("scenarios", "", "CSV or YAML file of sign-ins with expected outcomes to check instead of a single sign-in")
+	fs.Parse(args)
+
+	s.Groups = splitList(groups)
+	s.Roles = splitList(roles)
+	var err error
+	if s.Device, err = parseDeviceProperties(splitList(device)); err != nil {
+		log.Print(err)
+		return 1
+	}
+
+	cfg, _, err := common.load()
+	if err != nil {
+		log.Printf("error loading config: %v", err)
+		return 1
+	}
+	policies, directory, err := source.load(ctx, cfg, fs.Args())
+	if err != nil {
+		log.Printf("error getting policies: %v", err)
+		return 1
+	}
+	evaluator, err := newWhatIfEvaluator(policies, directory)
+	if err != nil {
+		log.Printf("error reading policies: %v", err)
+		return 1
+	}
+	if *scenarios != "" {
+		return runWhatIfScenarios(evaluator, *scenarios, *format)
+	}
+	result := evaluator.evaluate(s)
+
+	switch *format {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(result)
+	case "text":
+		writeWhatIfText(os.Stdout, result)
+	default:
+		log.Printf("unknown format %q, expected text or json", *format)
+		return 1
+	}
+	if err != nil {
+		log.Printf("error writing result: %v", err)
+		return 1
+	}
+	return 0
+}
+
+func writeWhatIfText(w io.Writer, result *whatIfResult) {
+	fmt.Fprintln(w, "Applied policies:")
+	writeWhatIfPolicies(w, result.Applied)
+	fmt.Fprintln(w, "Report-only policies that would apply:")
+	writeWhatIfPolicies(w, result.ReportOnly)
+	fmt.Fprintln(w, "Skipped policies:")
+	writeWhatIfPolicies(w, result.Skipped)
+
+	fmt.Fprintln(w)
+	switch {
+	case result.Block:
+		fmt.Fprintln(w, "Access: blocked")
+	case len(result.Grant) == 0:
+		fmt.Fprintln(w, "Access: granted without further requirements")
+	default:
+		fmt.Fprintln(w, "Access: granted when all of these are satisfied")
+		for _, grant := range result.Grant {
+			fmt.Fprintf(w, "  - %s\n", grant)
+		}
+	}
+	if len(result.Session) > 0 {
+		fmt.Fprintln(w, "Session controls:")
+		for _, session := range result.Session {
+			fmt.Fprintf(w, "  - %s\n", session)
+		}
+	}
+}
+
+func writeWhatIfPolicies(w io.Writer, policies []whatIfPolicy) {
+	if len(policies) == 0 {
+		fmt.Fprintln(w, "  (none)")
+	}
+	for _, p := range policies {
+		if p.Reason != "" {
+			fmt.Fprintf(w, "  - %s: %s\n", p.Policy, p.Reason)
+		} else {
+			fmt.Fprintf(w, "  - %s\n", p.Policy)
+		}
+	}
+}
+
+// parseDeviceProperties parses name=value pairs into device properties.
+func parseDeviceProperties(pairs []string) (map[string]string, error) {
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+	device := map[string]string{}
+	for _, pair := range pairs {
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid device property %q, expected name=value", pair)
+		}
+		device[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	return device, nil
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries.
+func splitList(value string) []string {
+	return splitListOn(value, ",")
+}
+
+func splitListOn(value, separator string) []string {
+	var values []string
+	for _, v := range strings.Split(value, separator) {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
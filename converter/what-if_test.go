@@ -0,0 +1,228 @@
+package converter
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWhatIfSkipReason(t *testing.T) {
+	const globalAdmin = "62e90394-69f5-4237-9190-012177145e10"
+	filter := func(mode, rule string) func(p *caPolicy) {
+		return func(p *caPolicy) { p.Conditions.Devices = &caDevices{Filter: &caFilter{Mode: mode, Rule: rule}} }
+	}
+	for _, tc := range []struct {
+		name string
+		// edits change the policy, a copy of testPolicy; signIn changes a
+		// browser sign-in of a pilot group member
+		edits  []func(p *caPolicy)
+		signIn func(s *signIn)
+		reason string
+	}{
+		{name: "applies"},
+		{name: "not in an included group", signIn: func(s *signIn) { s.Groups = nil }, reason: "user is not included"},
+		{name: "excluded user", signIn: func(s *signIn) { s.User = "breakglass@contoso.com" }, reason: "user is excluded"},
+		{name: "excluded user by ID", signIn: func(s *signIn) { s.User = testUserID }, reason: "user is excluded"},
+		{
+			name:   "excluded group",
+			edits:  []func(p *caPolicy){func(p *caPolicy) { p.Conditions.Users.ExcludeGroups = []principalRef{{ID: "g2", Name: "Contractors"}} }},
+			signIn: func(s *signIn) { s.Groups = append(s.Groups, "contractors") },
+			reason: "user is excluded through a group",
+		},
+		{
+			name:   "included role",
+			edits:  []func(p *caPolicy){func(p *caPolicy) { p.Conditions.Users.IncludeRoles = []string{globalAdmin} }},
+			signIn: func(s *signIn) { s.Groups, s.Roles = nil, []string{globalAdmin} },
+		},
+		{
+			name: "included guest type",
+			edits: []func(p *caPolicy){func(p *caPolicy) {
+				p.Conditions.Users.IncludeGuests = &caGuestsOrExternalUsers{GuestOrExternalUserTypes: []string{"b2bCollaborationGuest"}}
+			}},
+			signIn: func(s *signIn) { s.Groups, s.GuestType = nil, "b2bCollaborationGuest" },
+		},
+		{
+			name: "user action",
+			edits: []func(p *caPolicy){func(p *caPolicy) {
+				p.Conditions.Applications = &caApplications{IncludeUserActions: []string{"urn:user:registersecurityinfo"}}
+			}},
+			reason: "user action is not included",
+		},
+		{name: "no application", signIn: func(s *signIn) { s.App = "" }, reason: "policy targets applications and the sign-in has none"},
+		{
+			name:   "excluded application",
+			edits:  []func(p *caPolicy){func(p *caPolicy) { p.Conditions.Applications.ExcludeApplications = []string{"Azure Portal"} }},
+			reason: `application "Azure Portal" is excluded`,
+		},
+		{
+			name:   "client app",
+			edits:  []func(p *caPolicy){func(p *caPolicy) { p.Conditions.ClientAppTypes = []string{"exchangeActiveSync", "other"} }},
+			reason: "client app browser is not included",
+		},
+		{
+			name:   "platform",
+			edits:  []func(p *caPolicy){func(p *caPolicy) { p.Conditions.Platforms = &caPlatforms{IncludePlatforms: []string{"android"}} }},
+			reason: `platform "iOS" is not included`,
+		},
+		{
+			name: "excluded platform",
+			edits: []func(p *caPolicy){func(p *caPolicy) {
+				p.Conditions.Platforms = &caPlatforms{IncludePlatforms: []string{"all"}, ExcludePlatforms: []string{"ios"}}
+			}},
+			reason: "platform iOS is excluded",
+		},
+		{
+			name: "trusted location",
+			edits: []func(p *caPolicy){func(p *caPolicy) {
+				p.Conditions.Locations = &caLocations{IncludeLocations: []principalRef{{ID: "All"}}, ExcludeLocations: []principalRef{{ID: "AllTrusted"}}}
+			}},
+			signIn: func(s *signIn) { s.Location, s.TrustedLocation = "HQ", true },
+			reason: `location "HQ" is excluded`,
+		},
+		{
+			name: "named location",
+			edits: []func(p *caPolicy){func(p *caPolicy) {
+				p.Conditions.Locations = &caLocations{IncludeLocations: []principalRef{{ID: "l1", Name: "Branch"}}}
+			}},
+			signIn: func(s *signIn) { s.Location = "HQ" },
+			reason: `location "HQ" is not included`,
+		},
+		{
+			name:   "sign-in risk defaults to none",
+			edits:  []func(p *caPolicy){func(p *caPolicy) { p.Conditions.SignInRiskLevels = []string{"high", "medium"} }},
+			reason: "sign-in risk none is not included",
+		},
+		{
+			name:   "user risk",
+			edits:  []func(p *caPolicy){func(p *caPolicy) { p.Conditions.UserRiskLevels = []string{"high"} }},
+			signIn: func(s *signIn) { s.UserRisk = "High" },
+		},
+		{
+			name:   "device not matching an include filter",
+			edits:  []func(p *caPolicy){filter("include", `device.isCompliant -eq True`)},
+			reason: "device does not match the device filter",
+		},
+		{
+			name:   "device matching an exclude filter",
+			edits:  []func(p *caPolicy){filter("exclude", `device.trustType -eq "Workplace"`)},
+			reason: "device is excluded by the device filter",
+		},
+		{
+			name:  "device not matching an exclude filter",
+			edits: []func(p *caPolicy){filter("exclude", `device.isCompliant -eq True`)},
+		},
+		{
+			name:   "invalid device filter",
+			edits:  []func(p *caPolicy){filter("include", `device.isCompliant -is True`)},
+			reason: `device filter could not be evaluated: unsupported operator "-is" in device filter`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := testCAPolicy("a1", "Require MFA")
+			for _, edit := range tc.edits {
+				edit(p)
+			}
+			// the filters are parsed as newWhatIfEvaluator does
+			e := &whatIfEvaluator{policies: []*caPolicy{p}, filters: map[*caPolicy]deviceFilter{}, errors: map[*caPolicy]error{}}
+			if devices := p.Conditions.Devices; devices != nil {
+				if parsed, err := parseDeviceFilter(devices.Filter.Rule); err != nil {
+					e.errors[p] = err
+				} else {
+					e.filters[p] = parsed
+				}
+			}
+			s := signIn{
+				User:      "alice@contoso.com",
+				Groups:    []string{"CA Pilot"},
+				App:       "Azure Portal",
+				ClientApp: "browser",
+				Platform:  "iOS",
+				Device:    map[string]string{"trustType": "Workplace", "isCompliant": "False"},
+			}
+			if tc.signIn != nil {
+				tc.signIn(&s)
+			}
+			if reason := e.skipReason(p, s); reason != tc.reason {
+				t.Errorf("reason = %q, want %q", reason, tc.reason)
+			}
+		})
+	}
+}
+
+func TestWhatIfCombine(t *testing.T) {
+	frequency := func(value int64, period string) *caSessionControls {
+		return &caSessionControls{SignInFrequency: &value, SignInFrequencyPeriod: period}
+	}
+	mfa := testCAPolicy("a1", "Require MFA")
+	mfa.Session = frequency(1, "days")
+	compliant := testCAPolicy("b2", "Require compliant device")
+	compliant.Grant = &caGrantControls{Operator: "AND", BuiltInControls: []string{"compliantDevice"}, AuthenticationStrengthPolicyID: phishingResistantStrengthID}
+	compliant.Session = frequency(8, "hours")
+	compliant.Session.PersistentBrowserMode = "never"
+	block := testCAPolicy("c3", "Block legacy")
+	block.Grant = &caGrantControls{Operator: "OR", BuiltInControls: []string{"block"}}
+
+	for _, tc := range []struct {
+		name    string
+		applied []*caPolicy
+		want    whatIfResult
+	}{
+		{name: "nothing applied"},
+		{
+			name:    "one policy",
+			applied: []*caPolicy{mfa},
+			want: whatIfResult{
+				Grant:    []string{"Require MFA: Require multifactor authentication"},
+				Controls: []string{"mfa"},
+				Session:  []string{"signInFrequency: 1 days"},
+			},
+		},
+		{
+			name:    "shortest sign-in frequency",
+			applied: []*caPolicy{mfa, compliant},
+			want: whatIfResult{
+				Grant: []string{
+					"Require MFA: Require multifactor authentication",
+					"Require compliant device: Require device to be marked as compliant and Require authentication strength `" + phishingResistantStrengthID + "`",
+				},
+				Controls: []string{"authenticationStrength", "compliantDevice", "mfa"},
+				Session:  []string{"persistentBrowser: never", "signInFrequency: 8 hours"},
+			},
+		},
+		{
+			name:    "block",
+			applied: []*caPolicy{block, mfa},
+			want: whatIfResult{
+				Block:    true,
+				Grant:    []string{"Block legacy: Block access", "Require MFA: Require multifactor authentication"},
+				Controls: []string{"block", "mfa"},
+				Session:  []string{"signInFrequency: 1 days"},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var result whatIfResult
+			result.combine(tc.applied)
+			if !reflect.DeepEqual(result, tc.want) {
+				t.Errorf("combined =\n%+v\nwant\n%+v", result, tc.want)
+			}
+		})
+	}
+}
+
+func TestWhatIfEvaluateByState(t *testing.T) {
+	reportOnly, disabled := testCAPolicy("b2", "Report MFA"), testCAPolicy("c3", "Disabled MFA")
+	reportOnly.State, disabled.State = "enabledForReportingButNotEnforced", "disabled"
+	e := &whatIfEvaluator{policies: []*caPolicy{disabled, testCAPolicy("a1", "Require MFA"), reportOnly}}
+
+	result := e.evaluate(signIn{User: "alice@contoso.com", Groups: []string{"CA Pilot"}, App: "Azure Portal"})
+	want := &whatIfResult{
+		Applied:    []whatIfPolicy{{Policy: "Require MFA"}},
+		ReportOnly: []whatIfPolicy{{Policy: "Report MFA"}},
+		Skipped:    []whatIfPolicy{{"Disabled MFA", "policy is disabled"}},
+		Grant:      []string{"Require MFA: Require multifactor authentication"},
+		Controls:   []string{"mfa"},
+	}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("result =\n%+v\nwant\n%+v", result, want)
+	}
+}
@@ -0,0 +1,345 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"gopkg.in/yaml.v3"
+)
+
+// policyDocumentDir is the directory below the output directory holding one
+// YAML file per policy in the yaml mode.
+const policyDocumentDir = "policies"
+
+// yamlPolicyLoader reads the YAML policy files, resolves the users, groups and
+// named locations they name to object IDs and exposes the result as
+// local.ca_policies for the ca_policy module. Keywords such as "All" and
+// object IDs are passed through as they are.
+const yamlPolicyLoader = `locals {
+  ca_policy_documents = {
+    for file in fileset("${path.module}/policies", "*.yaml") :
+    trimsuffix(file, ".yaml") => yamldecode(file("${path.module}/policies/${file}"))
+  }
+
+  ca_policy_passthrough = "^(All|None|GuestsOrExternalUsers|AllTrusted|[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12})$"
+
+  ca_policy_user_names = toset(flatten([
+    for policy in values(local.ca_policy_documents) : [
+      for name in concat(try(policy.conditions.users.included_users, []), try(policy.conditions.users.excluded_users, [])) :
+      name if !can(regex(local.ca_policy_passthrough, name))
+    ]
+  ]))
+  ca_policy_group_names = toset(flatten([
+    for policy in values(local.ca_policy_documents) : [
+      for name in concat(try(policy.conditions.users.included_groups, []), try(policy.conditions.users.excluded_groups, [])) :
+      name if !can(regex(local.ca_policy_passthrough, name))
+    ]
+  ]))
+  ca_policy_location_names = toset(flatten([
+    for policy in values(local.ca_policy_documents) : [
+      for name in concat(try(policy.conditions.locations.included_locations, []), try(policy.conditions.locations.excluded_locations, [])) :
+      name if !can(regex(local.ca_policy_passthrough, name))
+    ]
+  ]))
+
+  ca_policies = {
+    for key, policy in local.ca_policy_documents : key => merge(policy, {
+      conditions = merge(policy.conditions, {
+        users = merge(policy.conditions.users, {
+          included_users  = try([for name in policy.conditions.users.included_users : try(data.azuread_user.ca_policies[name].id, name)], null)
+          excluded_users  = try([for name in policy.conditions.users.excluded_users : try(data.azuread_user.ca_policies[name].id, name)], null)
+          included_groups = try([for name in policy.conditions.users.included_groups : try(data.azuread_group.ca_policies[name].id, name)], null)
+          excluded_groups = try([for name in policy.conditions.users.excluded_groups : try(data.azuread_group.ca_policies[name].id, name)], null)
+        })
+        locations = try(merge(policy.conditions.locations, {
+          included_locations = try([for name in policy.conditions.locations.included_locations : try(data.azuread_named_location.ca_policies[name].id, name)], null)
+          excluded_locations = try([for name in policy.conditions.locations.excluded_locations : try(data.azuread_named_location.ca_policies[name].id, name)], null)
+        }), null)
+      })
+    })
+  }
+}
+
+data "azuread_user" "ca_policies" {
+  for_each            = local.ca_policy_user_names
+  user_principal_name = each.key
+}
+
+data "azuread_group" "ca_policies" {
+  for_each     = local.ca_policy_group_names
+  display_name = each.key
+}
+
+data "azuread_named_location" "ca_policies" {
+  for_each     = local.ca_policy_location_names
+  display_name = each.key
+}
+`
+
+// yamlDocumentComment starts the comment heading each YAML document.
+const yamlDocumentComment = "Conditional access policy "
+
+// yamlPolicyDocument renders p as YAML using the attribute names of the
+// provider schema. Referenced objects are written by name, or by ID when they
+// could not be resolved.
+func yamlPolicyDocument(p *caPolicy) ([]byte, error) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+	simplifyYAMLNode(&document)
+	document.HeadComment = fmt.Sprintf(yamlDocumentComment+"%q", p.DisplayName)
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&document); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// simplifyYAMLNode drops the JSON flow style and quoting from node and
+// replaces each principalRef with the name it was resolved to. Strings that a
+// YAML 1.1 parser such as yamldecode would read as another type, e.g. "yes",
+// stay quoted.
+func simplifyYAMLNode(node *yaml.Node) {
+	node.Style = 0
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!str" && yaml11NonString.MatchString(node.Value) {
+		node.Style = yaml.DoubleQuotedStyle
+	}
+	if node.Kind == yaml.SequenceNode {
+		for i, item := range node.Content {
+			if ref, ok := principalRefNode(item); ok {
+				node.Content[i] = ref
+			}
+		}
+	}
+	for _, child := range node.Content {
+		simplifyYAMLNode(child)
+	}
+}
+
+// yaml11NonString matches plain scalars YAML 1.1 resolves to booleans, null
+// or numbers.
+var yaml11NonString = regexp.MustCompile(`^(?i:y|yes|n|no|true|false|on|off|null|~|)$|^[-+]?(\.?[0-9][0-9_.:eE+-]*|0x[0-9a-fA-F_]+|\.inf|\.nan)$`)
+
+// principalRefNode returns the scalar standing for node if node is an
+// encoded principalRef.
+func principalRefNode(node *yaml.Node) (*yaml.Node, bool) {
+	if node.Kind != yaml.MappingNode {
+		return nil, false
+	}
+	values := map[string]*yaml.Node{}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		values[node.Content[i].Value] = node.Content[i+1]
+	}
+	id, ok := values["id"]
+	if !ok || len(values) > 2 {
+		return nil, false
+	}
+	if name, ok := values["name"]; ok {
+		return name, true
+	}
+	if len(values) > 1 {
+		return nil, false
+	}
+	return id, true
+}
+
+// writeYAMLPolicies writes a YAML file per policy and returns the
+// configuration that loads them. Existing files are merged like the HCL
+// files, keeping comments and hand edits. The file of a policy renamed since
+// the last run is moved to its new name first; files that no policy was
+// generated to are left alone, as they may be policies not yet applied.
+func (g *policyGenerator) writeYAMLPolicies() (*hclwrite.File, error) {
+	dir := filepath.Join(g.outputDir, policyDocumentDir)
+	for _, policy := range g.modulePolicies {
+		path := filepath.Join(dir, policy.key+".yaml")
+		if previous, ok := g.manifest.Policies[policy.id]; ok && previous.Label != policy.key {
+			if err := moveYAMLDocument(g.out, filepath.Join(dir, previous.Label+".yaml"), path, g.values); err != nil {
+				return nil, err
+			}
+		}
+
+		change, err := writeMergedYAML(g.out, path, policy.document, g.values)
+		if err != nil {
+			return nil, err
+		}
+		if change != fileUnchanged {
+			fmt.Fprintf(g.log, "%s YAML file for policy: %s\n", change, policy.displayName)
+		}
+	}
+
+	f, diags := hclwrite.ParseConfig([]byte(yamlPolicyLoader), policyModuleFileName, hcl.InitialPos)
+	if diags.HasErrors() {
+		return nil, fmt.Errorf("error parsing YAML loader: %s", diags.Error())
+	}
+	return f, nil
+}
+
+// moveYAMLDocument renames the YAML document at oldPath to newPath, unless
+// newPath already exists, in which case the old document is only removed. A
+// missing document is ignored.
+func moveYAMLDocument(out OutputWriter, oldPath, newPath string, values *attributeValues) error {
+	content, err := out.ReadFile(oldPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := out.ReadFile(newPath); errors.Is(err, fs.ErrNotExist) {
+		if err := out.WriteFile(newPath, content); err != nil {
+			return err
+		}
+		values.move(oldPath, newPath)
+	} else if err != nil {
+		return err
+	}
+	return out.Remove(oldPath)
+}
+
+// writeMergedYAML writes the YAML document desired to path. An existing
+// document is merged with it instead: a value is replaced unless it was edited
+// by hand and the generated value is unchanged since values recorded it, and
+// entries the generator did not write are kept, as are comments.
+func writeMergedYAML(out OutputWriter, path string, desired []byte, values *attributeValues) (fileChange, error) {
+	var desiredNode yaml.Node
+	if err := yaml.Unmarshal(desired, &desiredNode); err != nil {
+		return fileUnchanged, err
+	}
+	m := &yamlMerger{previous: values.previous(path)}
+	generated := map[string]string{}
+	recordYAMLValues(&desiredNode, "", generated)
+	values.record(path, generated)
+
+	existing, err := out.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fileCreated, out.WriteFile(path, desired)
+	}
+	if err != nil {
+		return fileUnchanged, err
+	}
+	var current yaml.Node
+	if err := yaml.Unmarshal(existing, &current); err != nil {
+		return fileUnchanged, fmt.Errorf("error parsing %s: %v", path, err)
+	}
+	if current.Kind != yaml.DocumentNode || len(current.Content) == 0 || len(desiredNode.Content) == 0 {
+		return writeFileIfChanged(out, path, desired)
+	}
+	m.mergeNode(current.Content[0], desiredNode.Content[0], "")
+	if strings.HasPrefix(current.HeadComment, "# "+yamlDocumentComment) {
+		// the generated comment names the policy, which may have been renamed
+		current.HeadComment = desiredNode.HeadComment
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&current); err != nil {
+		return fileUnchanged, err
+	}
+	return writeFileIfChanged(out, path, buf.Bytes())
+}
+
+// yamlMerger merges a generated YAML document into an existing one. previous
+// holds the values written by the last run, keyed like recordYAMLValues; nil
+// means nothing was recorded and every value is the generator's.
+type yamlMerger struct {
+	previous map[string]string
+}
+
+// mergeNode updates current to the desired value at key. Mappings are merged
+// key by key, everything else is replaced as a whole keeping its comments.
+func (m *yamlMerger) mergeNode(current, desired *yaml.Node, key string) {
+	if current.Kind == yaml.MappingNode && desired.Kind == yaml.MappingNode {
+		desiredKeys := map[string]bool{}
+		for i := 0; i+1 < len(desired.Content); i += 2 {
+			name := desired.Content[i].Value
+			desiredKeys[name] = true
+			if value := yamlMappingValue(current, name); value != nil {
+				m.mergeNode(value, desired.Content[i+1], yamlKey(key, name))
+			} else {
+				current.Content = append(current.Content, desired.Content[i], desired.Content[i+1])
+			}
+		}
+		var content []*yaml.Node
+		for i := 0; i+1 < len(current.Content); i += 2 {
+			name := current.Content[i].Value
+			if !desiredKeys[name] && (m.previous == nil || recordedBelow(m.previous, yamlKey(key, name))) {
+				continue
+			}
+			content = append(content, current.Content[i], current.Content[i+1])
+		}
+		current.Content = content
+		return
+	}
+
+	currentValue, desiredValue := yamlValueKey(current), yamlValueKey(desired)
+	if currentValue == desiredValue {
+		return
+	}
+	if previous, ok := m.previous[key]; ok && currentValue != previous && desiredValue == previous {
+		// edited by hand while the tenant value stayed the same
+		return
+	}
+	head, line, foot := current.HeadComment, current.LineComment, current.FootComment
+	*current = *desired
+	current.HeadComment, current.LineComment, current.FootComment = head, line, foot
+}
+
+// recordYAMLValues adds every value in node that is not a mapping to values,
+// keyed by the path of mapping keys leading to it.
+func recordYAMLValues(node *yaml.Node, key string, values map[string]string) {
+	switch {
+	case node.Kind == yaml.DocumentNode && len(node.Content) > 0:
+		recordYAMLValues(node.Content[0], key, values)
+	case node.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			recordYAMLValues(node.Content[i+1], yamlKey(key, node.Content[i].Value), values)
+		}
+	default:
+		values[key] = yamlValueKey(node)
+	}
+}
+
+func yamlKey(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+// yamlMappingValue returns the value of key in the mapping node, or nil.
+func yamlMappingValue(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// yamlValueKey returns the value of node as JSON, so values that differ only
+// in style or comments compare equal.
+func yamlValueKey(node *yaml.Node) string {
+	var value any
+	if err := node.Decode(&value); err != nil {
+		return ""
+	}
+	data, _ := json.Marshal(value)
+	return string(data)
+}
@@ -1,46 +1,39 @@
 package main
 
 import (
-	"context"
 	"fmt"
-	"log"
+	"os"
+	"strings"
 
-	msgraphsdk "github.com/microsoftgraph/msgraph-sdk-go"
-	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	"jsontohcl2/converter"
 )
 
 func main() {
-	ctx := context.Background()
-
-	// Configure Azure credentials
-	cred, err := configureCredentials(ctx)
-	if err != nil {
-		fmt.Printf("Error configuring credentials: %v\n", err)
-		return
-	}
-
-	scopes := []string{"https://graph.microsoft.com/.default"}
-	graphClient, err := msgraphsdk.NewGraphServiceClientWithCredentials(cred, scopes)
-	if err != nil {
-		fmt.Errorf("error creating client: %v", err)
-	}
-	var policies []models.ConditionalAccessPolicy
-	policies, err = getExistingPolicies(graphClient)
-	if err != nil {
-		log.Fatalf("error getting existing policies: %v", err)
+	args := os.Args[1:]
+	command := "generate"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
 	}
 
-	// create data.tf
-	if err := createDataFile(); err != nil {
-		fmt.Println("Error creating data file:", err)
-		return
+	switch command {
+	case "generate":
+		os.Exit(converter.RunGenerate(args))
+	case "drift":
+		os.Exit(converter.RunDrift(args))
+	case "restore":
+		os.Exit(converter.RunRestore(args))
+	case "lint":
+		os.Exit(converter.RunLint(args))
+	case "whatif":
+		os.Exit(converter.RunWhatIf(args))
+	case "overlap":
+		os.Exit(converter.RunOverlap(args))
+	case "coverage":
+		os.Exit(converter.RunCoverage(args))
+	case "compare":
+		os.Exit(converter.RunCompare(args))
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q, expected generate, drift, restore, lint, whatif, overlap, coverage or compare\n", command)
+		os.Exit(1)
 	}
-
-	for _, value := range policies {
-		create_azurecapolicy(value, graphClient)
-	}
-	// for _, value := range policies {
-	// 	import_policy_to_tfstate(value)
-	// }
-
 }